@@ -0,0 +1,76 @@
+package db_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExecArray tests executing a statement with Oracle array binds.
+func TestExecArray(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "oracle"
+	})
+	var binds []driver.NamedValue
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		binds = args
+		// like godror, execute once per array element
+		executions := 0
+		for _, arg := range args {
+			if value := reflect.ValueOf(arg.Value); reflect.Slice == value.Kind() {
+				executions = value.Len()
+			}
+		}
+		return driver.RowsAffected(executions), nil
+	}
+
+	stmt, err := database.Prepare("INSERT INTO users (id, name) VALUES (:id, :name)")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	rows, err := stmt.ExecArray(context.Background(), map[string][]interface{}{
+		"id":   {int64(1), int64(2), int64(3)},
+		"name": {"alice", "bob", nil},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), rows)
+	assert.True(t, containsEntry(stub.Log(), "exec: INSERT INTO users (id, name) VALUES (:id, :name)", 1))
+	assert.Equal(t, []driver.NamedValue{
+		{Name: "id", Ordinal: 1, Value: []int64{1, 2, 3}},
+		{Name: "name", Ordinal: 2, Value: []interface{}{"alice", "bob", nil}},
+	}, binds)
+
+	// arrays must have the same length
+	_, err = stmt.ExecArray(context.Background(), map[string][]interface{}{
+		"id":   {int64(1), int64(2)},
+		"name": {"alice"},
+	})
+	assert.Error(t, err)
+	assert.Contains(t, fmt.Sprintf("%+v", err), "array 'name' has 1 values, 2 expected")
+
+	// arrays must not be empty
+	_, err = stmt.ExecArray(context.Background(), map[string][]interface{}{"id": {}})
+	assert.Error(t, err)
+}
+
+// TestExecArrayUnsupported tests that array binding requires the oracle
+// driver type.
+func TestExecArrayUnsupported(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "postgres"
+	})
+
+	stmt, err := database.Prepare("INSERT INTO users (id) VALUES ($1)")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	_, err = stmt.ExecArray(context.Background(), map[string][]interface{}{"id": {int64(1)}})
+	assert.Error(t, err)
+	assert.Contains(t, fmt.Sprintf("%+v", err), "array binding is not supported for driver type 'postgres'")
+	assert.False(t, containsEntry(stub.Log(), "exec: INSERT INTO users (id) VALUES ($1)", 1))
+}
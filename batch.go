@@ -0,0 +1,375 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bdlm/errors/v2"
+)
+
+// BindBatch binds rows of positional values for a batch insert executed with
+// ExecBatch. Each row holds the values for the statement's column list in
+// order, and every row must have the same number of values. Rows accumulate
+// across calls until ExecBatch is called. If the rows are invalid nothing is
+// bound and the error is available from LastErr.
+func (statement *Statement) BindBatch(rows [][]interface{}) *Statement {
+	width := -1
+	if 0 < len(statement.batch) {
+		width = len(statement.batch[0])
+	}
+	for a, row := range rows {
+		if 0 > width {
+			width = len(row)
+		}
+		if 0 == len(row) || len(row) != width {
+			statement.lastErr = errors.Errorf("batch row %d has %d values, %d expected", a, len(row), width)
+			return statement
+		}
+	}
+	statement.batch = append(statement.batch, rows...)
+	return statement
+}
+
+// ExecBatch executes the prepared INSERT statement for the rows bound with
+// BindBatch, in the statement's transaction, and returns the total number of
+// rows affected. Rather than one round trip per row, the VALUES clause of the
+// statement is replaced with one row of positional placeholders per bound
+// row, i.e. for postgres:
+//
+//	INSERT INTO users (id, name) VALUES ($1, $2), ($3, $4), ...
+//
+// For the "oracle" DriverType an `INSERT ALL` statement is generated instead.
+// Large batches are split into statements of up to 1000 rows. For driver
+// types without positional placeholders (see UpdateBatch) the prepared
+// statement is executed once per row with the row's values.
+//
+// Batch mode binds values positionally, so the statement's placeholders are
+// only used for per-row execution and named binds added with Bind can't be
+// combined with a batch. Config.BindTransform is applied to each value with
+// an empty name, as for positional arguments. The bound rows are cleared when
+// ExecBatch returns. An error is returned if no rows are bound, as when
+// BindBatch rejected them.
+func (statement *Statement) ExecBatch(ctx context.Context) (sql.Result, error) {
+	rows := statement.batch
+	statement.batch = nil
+	if 0 == len(rows) {
+		statement.lastErr = errors.New("no rows bound for the batch insert")
+		return nil, statement.lastErr
+	}
+	if 0 < len(statement.binds) {
+		statement.binds = []sql.NamedArg{}
+		statement.lastErr = errors.New("named binds can't be combined with a batch insert")
+		return nil, statement.lastErr
+	}
+
+	var total int64
+	if _, ok := batchPlaceholder(statement.db.Config().DriverType, 1); !ok {
+		for _, row := range rows {
+			result, err := statement.ExecContext(ctx, row...)
+			if nil != err {
+				return driver.RowsAffected(total), err
+			}
+			if rowsAffected, err := result.RowsAffected(); nil == err {
+				total += rowsAffected
+			}
+		}
+		return driver.RowsAffected(total), nil
+	}
+
+	into := insertIntoRegex.FindStringSubmatch(cleanQuery(statement.sql))
+	if nil == into {
+		statement.lastErr = errors.Errorf("batch inserts require an INSERT ... VALUES statement, '%s' given", statement.sql)
+		return nil, statement.lastErr
+	}
+
+	for a, row := range rows {
+		var err error
+		if rows[a], err = statement.transformArray("", row); nil != err {
+			statement.lastErr = err
+			return nil, statement.lastErr
+		}
+	}
+
+	size := maxBatchRows
+	if maxBatchParams/len(rows[0]) < size {
+		size = maxBatchParams / len(rows[0])
+	}
+	for start := 0; start < len(rows); start += size {
+		end := start + size
+		if end > len(rows) {
+			end = len(rows)
+		}
+		query, args := insertBatchSQL(statement.db.Config().DriverType, into[1], rows[start:end])
+		result, err := statement.execTxn(ctx, query, args...)
+		if nil != err {
+			return driver.RowsAffected(total), err
+		}
+		if rowsAffected, err := result.RowsAffected(); nil == err {
+			total += rowsAffected
+		}
+	}
+	return driver.RowsAffected(total), nil
+}
+
+// UpdateBatch updates many rows of the table targeted by the statement, i.e.
+// `users` for a statement prepared with `UPDATE users ...`, in the
+// statement's transaction and returns the total number of rows affected.
+// Each update maps column names to new values and must include the keyCol
+// value identifying its row; columns missing from an update are left
+// unchanged for that row.
+//
+// For "mysql", "oracle", "postgres", and "snowflake" DriverTypes a single
+// statement is executed, setting each column with a CASE expression on the
+// key column:
+//
+//	UPDATE users SET
+//		name = CASE id WHEN $1 THEN $2 WHEN $3 THEN $4 ELSE name END
+//	WHERE id IN ($5, $6)
+//
+// For other driver types each row is updated with its own statement using
+// `:column` named placeholders. Column names are folded according to
+// Config.FoldIdentifiers. Column names are emitted unquoted, so they're
+// limited to plain identifiers. Config.BindTransform is applied to each value
+// with its column name.
+func (statement *Statement) UpdateBatch(ctx context.Context, keyCol string, updates []map[string]interface{}) (int64, error) {
+	if 0 == len(updates) {
+		return 0, nil
+	}
+
+	table := updateTable(statement.sql)
+	if "" == table {
+		statement.lastErr = errors.Errorf("batch updates require an UPDATE statement, '%s' given", statement.sql)
+		return 0, statement.lastErr
+	}
+
+	if !batchColumnRegex.MatchString(keyCol) {
+		statement.lastErr = errors.Errorf("invalid key column name '%s'", keyCol)
+		return 0, statement.lastErr
+	}
+	columns := []string{}
+	seen := map[string]bool{keyCol: true}
+	for a, update := range updates {
+		if _, ok := update[keyCol]; !ok {
+			statement.lastErr = errors.Errorf("update %d has no value for key column '%s'", a, keyCol)
+			return 0, statement.lastErr
+		}
+		for column := range update {
+			if !batchColumnRegex.MatchString(column) {
+				statement.lastErr = errors.Errorf("update %d has invalid column name '%s'", a, column)
+				return 0, statement.lastErr
+			}
+			if !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+	if 0 == len(columns) {
+		statement.lastErr = errors.New("batch updates require at least one column to update")
+		return 0, statement.lastErr
+	}
+	sort.Strings(columns)
+	updates, err := statement.transformUpdates(updates)
+	if nil != err {
+		statement.lastErr = err
+		return 0, statement.lastErr
+	}
+
+	if _, ok := batchPlaceholder(statement.db.Config().DriverType, 1); !ok {
+		return statement.updateRows(ctx, table, keyCol, columns, updates)
+	}
+
+	query, args := updateBatchSQL(statement.db.Config(), table, keyCol, columns, updates)
+	result, err := statement.execTxn(ctx, query, args...)
+	if nil != err {
+		return 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if nil != err {
+		statement.lastErr = errors.Wrap(err, "unable to read rows affected")
+		return 0, statement.lastErr
+	}
+	return rowsAffected, nil
+}
+
+// transformUpdates applies Config.BindTransform to the values of the
+// updates of UpdateBatch, named by their column, returning a copy. See
+// transformArray.
+func (statement *Statement) transformUpdates(updates []map[string]interface{}) ([]map[string]interface{}, error) {
+	if nil == statement.db.Config().BindTransform {
+		return updates, nil
+	}
+	transformed := make([]map[string]interface{}, len(updates))
+	for a, update := range updates {
+		transformed[a] = make(map[string]interface{}, len(update))
+		for column, value := range update {
+			values, err := statement.transformArray(column, []interface{}{value})
+			if nil != err {
+				return nil, err
+			}
+			transformed[a][column] = values[0]
+		}
+	}
+	return transformed, nil
+}
+
+// updateRows updates each row of a batch with its own statement, see
+// UpdateBatch.
+func (statement *Statement) updateRows(ctx context.Context, table, keyCol string, columns []string, updates []map[string]interface{}) (int64, error) {
+	cfg := statement.db.Config()
+	var total int64
+	for _, update := range updates {
+		sets := []string{}
+		args := []interface{}{}
+		for _, column := range columns {
+			if value, ok := update[column]; ok {
+				sets = append(sets, cfg.FoldIdentifier(column)+" = :"+column)
+				args = append(args, sql.Named(column, value))
+			}
+		}
+		args = append(args, sql.Named(keyCol, update[keyCol]))
+		query := fmt.Sprintf("UPDATE %s SET %s WHERE %s = :%s", table, strings.Join(sets, ", "), cfg.FoldIdentifier(keyCol), keyCol)
+
+		result, err := statement.execTxn(ctx, query, args...)
+		if nil != err {
+			return total, err
+		}
+		if rowsAffected, err := result.RowsAffected(); nil == err {
+			total += rowsAffected
+		}
+	}
+	return total, nil
+}
+
+// execTxn executes a query in the statement's transaction.
+func (statement *Statement) execTxn(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	statement.executed = true
+	statement.db.logQuery(ctx, query)
+	start := time.Now()
+	span := statement.db.startSpan(ctx, "exec", query)
+	result, err := statement.txn.ExecContext(ctx, query, args...)
+	statement.db.endSpan(span, err)
+	statement.db.observe(ctx, query, args, start, err)
+	statement.db.breakerRecord(err)
+	if nil != err {
+		statement.lastErr = statement.expired(err)
+		return nil, statement.lastErr
+	}
+	statement.db.onWrite(statement.txn, query, result)
+	if nil != statement.tx {
+		statement.tx.addRowsAffected(result)
+	}
+	return result, nil
+}
+
+// updateBatchSQL returns a single UPDATE statement and its arguments for a
+// batch of updates, see UpdateBatch.
+func updateBatchSQL(cfg *Config, table, keyCol string, columns []string, updates []map[string]interface{}) (string, []interface{}) {
+	args := []interface{}{}
+	bind := func(value interface{}) string {
+		args = append(args, value)
+		placeholder, _ := batchPlaceholder(cfg.DriverType, len(args))
+		return placeholder
+	}
+
+	key := cfg.FoldIdentifier(keyCol)
+	sets := make([]string, 0, len(columns))
+	for _, column := range columns {
+		name := cfg.FoldIdentifier(column)
+		set := name + " = CASE " + key
+		for _, update := range updates {
+			if value, ok := update[column]; ok {
+				set += " WHEN " + bind(update[keyCol]) + " THEN " + bind(value)
+			}
+		}
+		// ELSE keeps rows without a value for the column unchanged, and gives
+		// postgres the column type to resolve untyped parameters with.
+		sets = append(sets, set+" ELSE "+name+" END")
+	}
+
+	keys := make([]string, len(updates))
+	for a, update := range updates {
+		keys[a] = bind(update[keyCol])
+	}
+
+	return fmt.Sprintf(
+		"UPDATE %s SET %s WHERE %s IN (%s)",
+		table,
+		strings.Join(sets, ", "),
+		key,
+		strings.Join(keys, ", "),
+	), args
+}
+
+// insertBatchSQL returns a single INSERT statement and its arguments for a
+// batch of rows, see ExecBatch. into is the `INTO table (columns)` clause.
+func insertBatchSQL(driverType, into string, rows [][]interface{}) (string, []interface{}) {
+	args := make([]interface{}, 0, len(rows)*len(rows[0]))
+	values := make([]string, len(rows))
+	for a, row := range rows {
+		placeholders := make([]string, len(row))
+		for b, value := range row {
+			args = append(args, value)
+			placeholders[b], _ = batchPlaceholder(driverType, len(args))
+		}
+		values[a] = "(" + strings.Join(placeholders, ", ") + ")"
+	}
+
+	// Oracle doesn't support multi-row VALUES lists.
+	if "oracle" == driverType {
+		return "INSERT ALL " + into + " VALUES " +
+			strings.Join(values, " "+into+" VALUES ") +
+			" SELECT 1 FROM DUAL", args
+	}
+	return "INSERT " + into + " VALUES " + strings.Join(values, ", "), args
+}
+
+// batchPlaceholder returns the positional placeholder for the nth argument
+// of a generated statement, i.e. `$1` for postgres or `:1` for oracle, and
+// whether positional placeholders are supported for the driver type.
+func batchPlaceholder(driverType string, n int) (string, bool) {
+	switch driverType {
+	case "mysql", "snowflake":
+		return "?", true
+	case "oracle":
+		return fmt.Sprintf(":%d", n), true
+	case "postgres":
+		return fmt.Sprintf("$%d", n), true
+	}
+	return "", false
+}
+
+// updateTable returns the table targeted by an UPDATE statement, including
+// any schema, or an empty string if the query isn't an UPDATE.
+func updateTable(query string) string {
+	m := updateRegex.FindStringSubmatch(cleanQuery(query))
+	if len(m) < 2 {
+		return ""
+	}
+	return strings.TrimSpace(m[1])
+}
+
+const (
+	// maxBatchRows limits the number of rows inserted by each statement
+	// generated by ExecBatch.
+	maxBatchRows = 1000
+
+	// maxBatchParams limits the number of parameters bound to each statement
+	// generated by ExecBatch, within the postgres and oracle limits.
+	maxBatchParams = 65535
+)
+
+// batchColumnRegex matches valid UpdateBatch column names. Names are emitted
+// unquoted and as named placeholders, so they're limited to plain
+// identifiers.
+var batchColumnRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// insertIntoRegex matches the `INTO table (columns)` clause of an INSERT ...
+// VALUES statement.
+var insertIntoRegex = regexp.MustCompile(`(?is)^insert\s+(into\s+.+?)\s*values\s*\(.*\)[\s;]*$`)
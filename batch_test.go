@@ -0,0 +1,223 @@
+package db_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestUpdateBatch tests updating many rows with a single CASE statement.
+func TestUpdateBatch(t *testing.T) {
+	var args []interface{}
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "postgres"
+	})
+	stub.Exec = func(query string, named []driver.NamedValue) (driver.Result, error) {
+		for _, arg := range named {
+			args = append(args, arg.Value)
+		}
+		return driver.RowsAffected(3), nil
+	}
+
+	stmt, err := database.Prepare("UPDATE app.users SET active = :active")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	rowsAffected, err := stmt.UpdateBatch(context.Background(), "id", []map[string]interface{}{
+		{"id": int64(1), "name": "alice", "active": true},
+		{"id": int64(2), "name": "bob"},
+		{"id": int64(3), "name": "carol", "active": false},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), rowsAffected)
+	assert.Equal(t, "exec: UPDATE app.users SET "+
+		"active = CASE id WHEN $1 THEN $2 WHEN $3 THEN $4 ELSE active END, "+
+		"name = CASE id WHEN $5 THEN $6 WHEN $7 THEN $8 WHEN $9 THEN $10 ELSE name END "+
+		"WHERE id IN ($11, $12, $13)", stub.Log()[2])
+	assert.Equal(t, []interface{}{
+		int64(1), true, int64(3), false,
+		int64(1), "alice", int64(2), "bob", int64(3), "carol",
+		int64(1), int64(2), int64(3),
+	}, args)
+
+	// every update needs a key
+	_, err = stmt.UpdateBatch(context.Background(), "id", []map[string]interface{}{{"name": "dave"}})
+	assert.Error(t, err)
+
+	// column names must be plain identifiers
+	log := len(stub.Log())
+	_, err = stmt.UpdateBatch(context.Background(), "id", []map[string]interface{}{
+		{"id": int64(1), "name = 'x' WHERE 1 = 1; --": "dave"},
+	})
+	assert.EqualError(t, err, "update 0 has invalid column name 'name = 'x' WHERE 1 = 1; --'")
+	_, err = stmt.UpdateBatch(context.Background(), "id) OR (1 = 1", []map[string]interface{}{
+		{"id) OR (1 = 1": int64(1), "name": "dave"},
+	})
+	assert.EqualError(t, err, "invalid key column name 'id) OR (1 = 1'")
+	assert.Len(t, stub.Log(), log)
+}
+
+// TestUpdateBatchRows tests falling back to per-row updates for driver types
+// without positional placeholders.
+func TestUpdateBatchRows(t *testing.T) {
+	var binds []string
+	database, stub := newStubDB(t)
+	stub.Exec = func(query string, named []driver.NamedValue) (driver.Result, error) {
+		for _, arg := range named {
+			binds = append(binds, fmt.Sprintf("%s=%v", arg.Name, arg.Value))
+		}
+		return driver.RowsAffected(1), nil
+	}
+
+	stmt, err := database.Prepare("UPDATE users SET active = :active")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	rowsAffected, err := stmt.UpdateBatch(context.Background(), "id", []map[string]interface{}{
+		{"id": int64(1), "name": "alice", "active": true},
+		{"id": int64(2), "name": "bob"},
+		{"id": int64(3), "name": "carol", "active": false},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), rowsAffected)
+	assert.Equal(t, []string{
+		"begin",
+		"prepare: UPDATE users SET active = :active",
+		"exec: UPDATE users SET active = :active, name = :name WHERE id = :id",
+		"exec: UPDATE users SET name = :name WHERE id = :id",
+		"exec: UPDATE users SET active = :active, name = :name WHERE id = :id",
+	}, stub.Log())
+	assert.Equal(t, []string{
+		"active=true", "name=alice", "id=1",
+		"name=bob", "id=2",
+		"active=false", "name=carol", "id=3",
+	}, binds)
+}
+
+// TestExecBatch tests inserting bound rows with multi-row statements.
+func TestExecBatch(t *testing.T) {
+	var args [][]interface{}
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "postgres"
+	})
+	stub.Exec = func(query string, named []driver.NamedValue) (driver.Result, error) {
+		values := []interface{}{}
+		for _, arg := range named {
+			values = append(values, arg.Value)
+		}
+		args = append(args, values)
+		return driver.RowsAffected(len(named) / 2), nil
+	}
+
+	stmt, err := database.Prepare("INSERT INTO users (id, name) VALUES (:id, :name);")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	result, err := stmt.BindBatch([][]interface{}{
+		{int64(1), "alice"},
+		{int64(2), "bob"},
+	}).BindBatch([][]interface{}{
+		{int64(3), "carol"},
+	}).ExecBatch(context.Background())
+	assert.NoError(t, err)
+	rowsAffected, err := result.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), rowsAffected)
+	assert.Equal(t, "exec: INSERT INTO users (id, name) VALUES ($1, $2), ($3, $4), ($5, $6)", stub.Log()[2])
+	assert.Equal(t, [][]interface{}{{int64(1), "alice", int64(2), "bob", int64(3), "carol"}}, args)
+
+	// large batches are split
+	rows := make([][]interface{}, 2500)
+	for a := range rows {
+		rows[a] = []interface{}{int64(a), fmt.Sprintf("user%d", a)}
+	}
+	result, err = stmt.BindBatch(rows).ExecBatch(context.Background())
+	assert.NoError(t, err)
+	rowsAffected, _ = result.RowsAffected()
+	assert.Equal(t, int64(2500), rowsAffected)
+	assert.Len(t, stub.Log(), 6)
+
+	// rows must have the same number of values
+	_, err = stmt.BindBatch([][]interface{}{{int64(4), "dave"}, {int64(5)}}).ExecBatch(context.Background())
+	assert.Error(t, err)
+	assert.Len(t, stub.Log(), 6)
+
+	// named binds can't be combined with a batch
+	_, err = stmt.Bind("id", 6).BindBatch([][]interface{}{{int64(6), "erin"}}).ExecBatch(context.Background())
+	assert.Error(t, err)
+}
+
+// TestExecBatchOracle tests inserting bound rows with INSERT ALL.
+func TestExecBatchOracle(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "oracle"
+	})
+
+	stmt, err := database.Prepare("INSERT INTO users (id, name) VALUES (:id, :name)")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	_, err = stmt.BindBatch([][]interface{}{
+		{int64(1), "alice"},
+		{int64(2), "bob"},
+	}).ExecBatch(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "exec: INSERT ALL INTO users (id, name) VALUES (:1, :2) INTO users (id, name) VALUES (:3, :4) SELECT 1 FROM DUAL", stub.Log()[2])
+}
+
+// TestBatchBindTransform tests applying Config.BindTransform to the values of
+// batch inserts and updates.
+func TestBatchBindTransform(t *testing.T) {
+	var args []interface{}
+	var names []string
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "postgres"
+		cfg.BindTransform = func(name string, value interface{}) (interface{}, error) {
+			names = append(names, name)
+			if s, ok := value.(string); ok {
+				return "enc:" + s, nil
+			}
+			return value, nil
+		}
+	})
+	stub.Exec = func(query string, named []driver.NamedValue) (driver.Result, error) {
+		for _, arg := range named {
+			args = append(args, arg.Value)
+		}
+		return driver.RowsAffected(len(named) / 2), nil
+	}
+
+	stmt, err := database.Prepare("INSERT INTO users (id, name) VALUES (:id, :name)")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	rows := [][]interface{}{{int64(1), "alice"}, {int64(2), "bob"}}
+	_, err = stmt.BindBatch(rows).ExecBatch(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int64(1), "enc:alice", int64(2), "enc:bob"}, args)
+	assert.Equal(t, []string{"", "", "", ""}, names)
+	assert.Equal(t, "alice", rows[0][1])
+
+	args, names = nil, nil
+	stmt, err = database.Prepare("UPDATE users SET name = :name")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	update := map[string]interface{}{"id": int64(1), "name": "carol"}
+	_, err = stmt.UpdateBatch(context.Background(), "id", []map[string]interface{}{update})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int64(1), "enc:carol", int64(1)}, args)
+	assert.ElementsMatch(t, []string{"id", "name"}, names)
+	assert.Equal(t, "carol", update["name"])
+
+	// transform errors abort the batch
+	database.Config().BindTransform = func(name string, value interface{}) (interface{}, error) {
+		return nil, fmt.Errorf("no key")
+	}
+	log := len(stub.Log())
+	_, err = stmt.UpdateBatch(context.Background(), "id", []map[string]interface{}{update})
+	assert.Error(t, err)
+	assert.Len(t, stub.Log(), log)
+}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/bdlm/errors/v2"
+)
+
+// bindArgs returns the arguments added with Bind followed by args, passed
+// through Config.BindTransform if set. Positional arguments are transformed
+// with an empty name, and sql.Out arguments are passed through unchanged.
+// Named arguments are ordered by position if Config.RebindNamed is set. A
+// pending bind error, see BindStructInsert, is returned instead.
+func (statement *Statement) bindArgs(args []interface{}) ([]interface{}, error) {
+	if err := statement.bindErr; nil != err {
+		statement.bindErr = nil
+		return nil, err
+	}
+	binds := make([]interface{}, 0, len(statement.binds)+len(args))
+	for _, bind := range statement.binds {
+		binds = append(binds, bind)
+	}
+	binds = append(binds, args...)
+	binds, err := statement.transformBinds(binds)
+	if nil != err || !statement.db.Config().RebindNamed {
+		return binds, err
+	}
+	return statement.rebindArgs(binds)
+}
+
+// transformBinds passes arguments through Config.BindTransform, see bindArgs.
+func (statement *Statement) transformBinds(binds []interface{}) ([]interface{}, error) {
+	transform := statement.db.Config().BindTransform
+	if nil == transform {
+		return binds, nil
+	}
+	for a, bind := range binds {
+		name := ""
+		value := bind
+		if named, ok := bind.(sql.NamedArg); ok {
+			name, value = named.Name, named.Value
+		}
+		if _, ok := value.(sql.Out); ok {
+			continue
+		}
+		value, err := transform(name, value)
+		if nil != err {
+			return nil, errors.Wrap(err, "failed to transform bind '%s'", name)
+		}
+		if _, ok := bind.(sql.NamedArg); ok {
+			binds[a] = sql.Named(name, value)
+		} else {
+			binds[a] = value
+		}
+	}
+	return binds, nil
+}
+
+// namedParams returns the names of the `:name` and `@name` placeholders in a
+// query, in order of appearance. See paramSpans.
+func namedParams(query string) []string {
+	spans := paramSpans(query)
+	names := make([]string, len(spans))
+	for a, span := range spans {
+		names[a] = query[span[0]+1 : span[1]]
+	}
+	return names
+}
+
+// paramSpans returns the start and end offsets of the `:name` and `@name`
+// placeholders in a query, in order of appearance. Quoted strings, quoted
+// identifiers, and comments are skipped, as are postgres `::` casts and
+// PL/SQL `:=` assignments.
+func paramSpans(query string) [][2]int {
+	var spans [][2]int
+	for a := 0; a < len(query); a++ {
+		switch c := query[a]; {
+		case '\'' == c || '"' == c || '`' == c:
+			for a++; a < len(query) && query[a] != c; a++ {
+			}
+		case '-' == c && a+1 < len(query) && '-' == query[a+1]:
+			for ; a < len(query) && query[a] != '\n'; a++ {
+			}
+		case '/' == c && a+1 < len(query) && '*' == query[a+1]:
+			for a += 2; a+1 < len(query) && !('*' == query[a] && '/' == query[a+1]); a++ {
+			}
+			a++
+		case ':' == c || '@' == c:
+			if a+1 < len(query) && (':' == query[a+1] || '=' == query[a+1] || '@' == query[a+1]) {
+				a++
+				continue
+			}
+			if 0 < a && isParamChar(query[a-1]) {
+				continue
+			}
+			end := a + 1
+			for end < len(query) && isParamChar(query[end]) {
+				end++
+			}
+			if end > a+1 {
+				spans = append(spans, [2]int{a, end})
+				a = end - 1
+			}
+		}
+	}
+	return spans
+}
+
+// rebind returns the query with its named placeholders replaced by the
+// positional placeholders of the DriverType, and the names bound to each
+// position, see Config.RebindNamed. `$n` placeholders are reused for
+// repeated names, `?` placeholders are not.
+func (cfg *Config) rebind(query string) (string, []string) {
+	spans := paramSpans(query)
+	if 0 == len(spans) {
+		return query, nil
+	}
+	numbered := "cockroach" == cfg.DriverType || "postgres" == cfg.DriverType
+
+	var rebound strings.Builder
+	names := []string{}
+	positions := map[string]int{}
+	last := 0
+	for _, span := range spans {
+		name := query[span[0]+1 : span[1]]
+		rebound.WriteString(query[last:span[0]])
+		last = span[1]
+		if !numbered {
+			names = append(names, name)
+			rebound.WriteString("?")
+			continue
+		}
+		position, ok := positions[name]
+		if !ok {
+			names = append(names, name)
+			position = len(names)
+			positions[name] = position
+		}
+		rebound.WriteString("$" + strconv.Itoa(position))
+	}
+	rebound.WriteString(query[last:])
+	return rebound.String(), names
+}
+
+// driverSQL returns the query sent to the driver, rebound if
+// Config.RebindNamed is set.
+func (cfg *Config) driverSQL(query string) string {
+	if !cfg.RebindNamed {
+		return query
+	}
+	query, _ = cfg.rebind(query)
+	return query
+}
+
+// rebindArgs orders named arguments by the positions of their placeholders
+// in the statement's rebound query, see Config.RebindNamed. Positional
+// arguments follow them.
+func (statement *Statement) rebindArgs(binds []interface{}) ([]interface{}, error) {
+	_, names := statement.db.Config().rebind(statement.sql)
+	named := map[string]interface{}{}
+	args := make([]interface{}, 0, len(binds))
+	positional := []interface{}{}
+	for _, bind := range binds {
+		if arg, ok := bind.(sql.NamedArg); ok {
+			named[arg.Name] = arg.Value
+		} else {
+			positional = append(positional, bind)
+		}
+	}
+	used := map[string]bool{}
+	for _, name := range names {
+		value, ok := named[name]
+		if !ok {
+			return nil, errors.Errorf("no value bound to named parameter '%s'", name)
+		}
+		used[name] = true
+		args = append(args, value)
+	}
+	for name := range named {
+		if !used[name] {
+			return nil, errors.Errorf("named parameter '%s' not found in query", name)
+		}
+	}
+	return append(args, positional...), nil
+}
+
+// isParamChar reports whether c may appear in a placeholder name.
+func isParamChar(c byte) bool {
+	return '_' == c ||
+		('a' <= c && c <= 'z') ||
+		('A' <= c && c <= 'Z') ||
+		('0' <= c && c <= '9')
+}
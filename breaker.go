@@ -0,0 +1,57 @@
+package db
+
+import (
+	"time"
+
+	"github.com/bdlm/errors/v2"
+)
+
+var (
+	// ErrCircuitOpen is returned by queries that fail fast because the
+	// circuit breaker is open. See Config.BreakerThreshold.
+	ErrCircuitOpen = errors.New("circuit breaker open, database unavailable")
+)
+
+// breakerAllow returns ErrCircuitOpen if the circuit breaker is open. Once
+// the cooldown has elapsed the breaker is half-open: a single request is
+// allowed through as a probe and the others still fail fast. The result of
+// the probe, see breakerRecord, closes the breaker or re-opens it. A probe
+// that doesn't report back within another cooldown is replaced.
+func (db *DB) breakerAllow() error {
+	if 0 >= db.Config().BreakerThreshold {
+		return nil
+	}
+	db.breakerMu.Lock()
+	defer db.breakerMu.Unlock()
+	if db.breakerFailures < db.Config().BreakerThreshold {
+		return nil
+	}
+	if time.Since(db.breakerOpened) < db.Config().BreakerCooldown {
+		return ErrCircuitOpen
+	}
+	if !db.breakerProbe.IsZero() && time.Since(db.breakerProbe) < db.Config().BreakerCooldown {
+		return ErrCircuitOpen
+	}
+	db.breakerProbe = time.Now()
+	return nil
+}
+
+// breakerRecord records the result of a request for the circuit breaker.
+// Connection failures (see ErrorClassConnection) count towards opening the
+// breaker, anything else shows the database is reachable and closes it.
+func (db *DB) breakerRecord(err error) {
+	if 0 >= db.Config().BreakerThreshold {
+		return
+	}
+	db.breakerMu.Lock()
+	defer db.breakerMu.Unlock()
+	db.breakerProbe = time.Time{}
+	if nil == err || ErrorClassConnection != ClassifyError(err) {
+		db.breakerFailures = 0
+		return
+	}
+	db.breakerFailures++
+	if db.breakerFailures >= db.Config().BreakerThreshold {
+		db.breakerOpened = time.Now()
+	}
+}
@@ -0,0 +1,94 @@
+package db_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bdlm/db"
+	"github.com/bdlm/errors/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCircuitBreaker tests failing fast after consecutive connection
+// failures.
+func TestCircuitBreaker(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.BreakerCooldown = 50 * time.Millisecond
+		cfg.BreakerThreshold = 2
+		cfg.Driver.(*stubDriver).OpenErr = fmt.Errorf("dial tcp 10.0.0.1:5432: connect: connection refused")
+		cfg.LazyConnect = true
+	})
+	setOpenErr := func(err error) {
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.OpenErr = err
+	}
+
+	// consecutive connection failures open the breaker
+	for a := 0; a < 2; a++ {
+		_, err := database.Exec("DELETE FROM sessions")
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, db.ErrCircuitOpen))
+	}
+
+	// open breakers fail fast
+	setOpenErr(nil)
+	_, err := database.Exec("DELETE FROM sessions")
+	assert.True(t, errors.Is(err, db.ErrCircuitOpen))
+	_, err = database.Prepare("SELECT 1")
+	assert.True(t, errors.Is(err, db.ErrCircuitOpen))
+	assert.Equal(t, 0, stub.Opens())
+
+	// a successful trial closes the breaker
+	time.Sleep(60 * time.Millisecond)
+	_, err = database.Exec("DELETE FROM sessions")
+	assert.NoError(t, err)
+	_, err = database.Exec("DELETE FROM sessions")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stub.Opens())
+}
+
+// TestCircuitBreakerHalfOpen tests that a single probe is let through once
+// the cooldown has elapsed.
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.BreakerCooldown = 50 * time.Millisecond
+		cfg.BreakerThreshold = 1
+		cfg.Driver.(*stubDriver).OpenErr = fmt.Errorf("dial tcp 10.0.0.1:5432: connect: connection refused")
+		cfg.LazyConnect = true
+	})
+	_, err := database.Exec("DELETE FROM sessions")
+	assert.False(t, errors.Is(err, db.ErrCircuitOpen))
+
+	// a failed probe re-opens the breaker
+	time.Sleep(60 * time.Millisecond)
+	_, err = database.Exec("DELETE FROM sessions")
+	assert.False(t, errors.Is(err, db.ErrCircuitOpen))
+	_, err = database.Exec("DELETE FROM sessions")
+	assert.True(t, errors.Is(err, db.ErrCircuitOpen))
+
+	// other requests fail fast while the probe runs
+	block := make(chan struct{})
+	stub.mu.Lock()
+	stub.OpenErr = nil
+	stub.OpenBlock = block
+	stub.mu.Unlock()
+	time.Sleep(60 * time.Millisecond)
+	probe := make(chan error)
+	go func() {
+		_, err := database.Exec("DELETE FROM sessions")
+		probe <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	_, err = database.Exec("DELETE FROM sessions")
+	assert.True(t, errors.Is(err, db.ErrCircuitOpen))
+	_, err = database.Prepare("SELECT 1")
+	assert.True(t, errors.Is(err, db.ErrCircuitOpen))
+
+	// a successful probe closes it
+	close(block)
+	assert.NoError(t, <-probe)
+	_, err = database.Exec("DELETE FROM sessions")
+	assert.NoError(t, err)
+}
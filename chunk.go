@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/bdlm/errors/v2"
+)
+
+// ExecChunked executes a large DELETE or UPDATE in chunks of at most
+// chunkSize rows, until a chunk affects no rows, and returns the total number
+// of rows affected. Each chunk is committed, releasing its locks and undo,
+// and the statement continues in a new transaction; statements prepared by a
+// Tx run every chunk in the shared transaction instead. The statement's
+// condition must exclude rows that have been processed, i.e. `UPDATE users
+// SET archived = 1 WHERE archived = 0 AND ...`, or the loop won't end.
+//
+// If the query contains a `:chunk_size` placeholder, i.e. in a LIMIT clause,
+// the prepared statement is executed with chunkSize bound to it. Otherwise a
+// chunk clause is added for the DriverType:
+//
+//   - "mysql": `LIMIT chunkSize` is appended.
+//   - "oracle": `ROWNUM <= chunkSize` is added to the WHERE clause.
+//   - "postgres": the WHERE clause is replaced with `ctid IN (SELECT ctid
+//     FROM table WHERE ... LIMIT chunkSize)`.
+func (statement *Statement) ExecChunked(ctx context.Context, chunkSize int, args ...interface{}) (int64, error) {
+	if 0 >= chunkSize {
+		statement.lastErr = errors.Errorf("chunk size must be positive, %d given", chunkSize)
+		return 0, statement.lastErr
+	}
+
+	var binds []interface{}
+	for _, bind := range statement.binds {
+		binds = append(binds, bind)
+	}
+	binds = append(binds, args...)
+	statement.binds = []sql.NamedArg{}
+
+	// The rewritten query, if the statement has no chunk size placeholder.
+	// Binds for the prepared statement are transformed by ExecContext.
+	var query string
+	placeholder := false
+	for _, name := range namedParams(statement.sql) {
+		placeholder = placeholder || "chunk_size" == name
+	}
+	if placeholder {
+		binds = append(binds, sql.Named("chunk_size", chunkSize))
+	} else {
+		var err error
+		if query, err = chunkSQL(statement.db.Config().DriverType, statement.sql, chunkSize); nil != err {
+			statement.lastErr = err
+			return 0, statement.lastErr
+		}
+		if binds, err = statement.transformBinds(binds); nil != err {
+			statement.lastErr = err
+			return 0, statement.lastErr
+		}
+	}
+
+	var total int64
+	for {
+		var result sql.Result
+		var err error
+		if "" == query {
+			result, err = statement.ExecContext(ctx, binds...)
+		} else {
+			result, err = statement.execTxn(ctx, query, binds...)
+		}
+		if nil != err {
+			return total, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if nil != err {
+			statement.lastErr = errors.Wrap(err, "unable to read rows affected")
+			return total, statement.lastErr
+		}
+		total += rowsAffected
+
+		if nil == statement.tx {
+			if err = statement.Commit(); nil != err {
+				return total, err
+			}
+			if err = statement.reprepare(); nil != err {
+				statement.lastErr = err
+				return total, statement.lastErr
+			}
+		}
+		if 0 == rowsAffected {
+			return total, nil
+		}
+	}
+}
+
+// chunkSQL adds a driver-specific clause limiting a DELETE or UPDATE
+// statement to chunkSize rows, see ExecChunked.
+func chunkSQL(driverType, query string, chunkSize int) (string, error) {
+	op, _ := ParseStatement(query)
+	if "delete" != op && "update" != op {
+		return "", errors.Errorf("chunked execution requires a DELETE or UPDATE statement, '%s' given", query)
+	}
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	where := topLevelWhere(query)
+
+	switch driverType {
+	case "mysql":
+		return fmt.Sprintf("%s LIMIT %d", query, chunkSize), nil
+
+	case "oracle":
+		if 0 > where {
+			return fmt.Sprintf("%s WHERE ROWNUM <= %d", query, chunkSize), nil
+		}
+		return fmt.Sprintf("%s (%s) AND ROWNUM <= %d", query[:where+5], query[where+6:], chunkSize), nil
+
+	case "postgres":
+		var table string
+		if "delete" == op {
+			table = deleteTable(query)
+		} else {
+			table = updateTable(query)
+		}
+		if "" == table {
+			return "", errors.Errorf("unable to determine the target table of '%s'", query)
+		}
+		if 0 > where {
+			return fmt.Sprintf("%s WHERE ctid IN (SELECT ctid FROM %s LIMIT %d)", query, table, chunkSize), nil
+		}
+		return fmt.Sprintf(
+			"%s ctid IN (SELECT ctid FROM %s WHERE %s LIMIT %d)",
+			query[:where+5], table, query[where+6:], chunkSize,
+		), nil
+	}
+	return "", errors.Errorf("chunked execution is not supported for driver type '%s' without a :chunk_size placeholder", driverType)
+}
+
+// deleteTable returns the table targeted by a DELETE statement, including
+// any schema, or an empty string if the query isn't a DELETE.
+func deleteTable(query string) string {
+	m := sqlOperations["delete"].FindStringSubmatch(cleanQuery(query))
+	if len(m) < 2 {
+		return ""
+	}
+	return strings.TrimSpace(m[1])
+}
+
+// topLevelWhere returns the offset of the `WHERE ` keyword of a statement,
+// ignoring any in subqueries or quoted strings, or -1 if it has none.
+func topLevelWhere(query string) int {
+	depth := 0
+	for a := 0; a < len(query); a++ {
+		switch c := query[a]; {
+		case '\'' == c || '"' == c || '`' == c:
+			for a++; a < len(query) && query[a] != c; a++ {
+			}
+		case '(' == c:
+			depth++
+		case ')' == c:
+			depth--
+		case 0 == depth && 0 < a && !isParamChar(query[a-1]) &&
+			a+6 <= len(query) && strings.EqualFold("where", query[a:a+5]) &&
+			(' ' == query[a+5] || '\t' == query[a+5] || '\n' == query[a+5]):
+			return a
+		}
+	}
+	return -1
+}
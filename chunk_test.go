@@ -0,0 +1,76 @@
+package db_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExecChunked tests executing large deletes and updates in committed
+// chunks.
+func TestExecChunked(t *testing.T) {
+	tests := []struct {
+		driverType string
+		query      string
+		expect     string
+	}{
+		{"mysql", "DELETE FROM sessions WHERE expires < :now;", "DELETE FROM sessions WHERE expires < :now LIMIT 100"},
+		{"oracle", "UPDATE users SET archived = 1 WHERE archived = 0 OR id IN (SELECT id FROM x WHERE y = 1)", "UPDATE users SET archived = 1 WHERE (archived = 0 OR id IN (SELECT id FROM x WHERE y = 1)) AND ROWNUM <= 100"},
+		{"oracle", "DELETE FROM sessions", "DELETE FROM sessions WHERE ROWNUM <= 100"},
+		{"postgres", "DELETE FROM app.sessions WHERE expires < :now", "DELETE FROM app.sessions WHERE ctid IN (SELECT ctid FROM app.sessions WHERE expires < :now LIMIT 100)"},
+		{"postgres", "UPDATE users SET archived = true", "UPDATE users SET archived = true WHERE ctid IN (SELECT ctid FROM users LIMIT 100)"},
+		{"snowflake", "DELETE FROM sessions WHERE id IN (SELECT id FROM sessions LIMIT :chunk_size)", "DELETE FROM sessions WHERE id IN (SELECT id FROM sessions LIMIT :chunk_size)"},
+	}
+
+	for _, test := range tests {
+		counts := []int64{100, 100, 40, 0}
+		var chunkSizes []interface{}
+		database, stub := newStubDB(t, func(cfg *db.Config) {
+			cfg.DriverType = test.driverType
+		})
+		stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+			for _, arg := range args {
+				if "chunk_size" == arg.Name {
+					chunkSizes = append(chunkSizes, arg.Value)
+				}
+			}
+			count := counts[0]
+			counts = counts[1:]
+			return driver.RowsAffected(count), nil
+		}
+
+		stmt, err := database.Prepare(test.query)
+		assert.NoError(t, err)
+		total, err := stmt.ExecChunked(context.Background(), 100)
+		assert.NoError(t, err, test.driverType)
+		assert.Equal(t, int64(240), total, test.driverType)
+		assert.Empty(t, counts)
+		assert.NoError(t, stmt.Close())
+
+		execs, commits := 0, 0
+		for _, entry := range stub.Log() {
+			switch entry {
+			case "exec: " + test.expect:
+				execs++
+			case "commit":
+				commits++
+			}
+		}
+		assert.Equal(t, 4, execs, test.driverType)
+		assert.Equal(t, 4, commits, test.driverType)
+		if "snowflake" == test.driverType {
+			assert.Equal(t, []interface{}{100, 100, 100, 100}, chunkSizes)
+		}
+	}
+
+	// statements without a chunk clause need a supported driver type
+	database, _ := newStubDB(t)
+	stmt, err := database.Prepare("DELETE FROM sessions")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.ExecChunked(context.Background(), 100)
+	assert.Error(t, err)
+}
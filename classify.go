@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"net"
+	"strings"
+
+	"github.com/bdlm/errors/v2"
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrorClass categorizes database errors to support retry decisions.
+type ErrorClass int
+
+const (
+	// ErrorClassUnknown is any error that isn't otherwise classified.
+	ErrorClassUnknown ErrorClass = iota
+
+	// ErrorClassDeadlock is a deadlock detected by the database. The
+	// transaction has been (or must be) rolled back and may be retried.
+	ErrorClassDeadlock
+
+	// ErrorClassSerialization is a serialization failure under serializable
+	// or repeatable-read isolation. The transaction may be retried.
+	ErrorClassSerialization
+
+	// ErrorClassConnection is a failure to connect to or communicate with the
+	// database. Whether the failed statement was applied is unknown, so it
+	// isn't retryable as a transaction, but it counts towards the circuit
+	// breaker (see Config.BreakerThreshold).
+	ErrorClassConnection
+)
+
+// String implements Stringer.
+func (class ErrorClass) String() string {
+	switch class {
+	case ErrorClassDeadlock:
+		return "deadlock"
+	case ErrorClassSerialization:
+		return "serialization"
+	case ErrorClassConnection:
+		return "connection"
+	}
+	return "unknown"
+}
+
+// Retryable reports whether a transaction that failed with this class of
+// error may be safely retried from the beginning.
+func (class ErrorClass) Retryable() bool {
+	return ErrorClassDeadlock == class || ErrorClassSerialization == class
+}
+
+// ClassifyError returns the class of a database error. Driver error codes
+// are used where they're available (mysql error numbers, postgres SQLSTATE
+// codes, Oracle ORA- codes, SQL Server error numbers), falling back to the
+// error messages, so errors wrapped by this package are still classified.
+// Context cancellations and deadlines aren't classified, though
+// context.DeadlineExceeded implements net.Error, as they don't indicate a
+// problem with the connection.
+func ClassifyError(err error) ErrorClass {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return ErrorClassUnknown
+	}
+	for ; nil != err; err = errors.Unwrap(err) {
+		if class := classifyCode(err); ErrorClassUnknown != class {
+			return class
+		}
+		if class := classifyMessage(err.Error()); ErrorClassUnknown != class {
+			return class
+		}
+	}
+	return ErrorClassUnknown
+}
+
+// classifyCode classifies an error using driver-specific error codes.
+func classifyCode(err error) ErrorClass {
+	if driver.ErrBadConn == err || mysql.ErrInvalidConn == err {
+		return ErrorClassConnection
+	}
+	switch err := err.(type) {
+	case net.Error:
+		return ErrorClassConnection
+	case *mysql.MySQLError:
+		switch err.Number {
+		case 1213: // ER_LOCK_DEADLOCK
+			return ErrorClassDeadlock
+		}
+	case interface{ SQLState() string }: // lib/pq, pgx
+		switch err.SQLState() {
+		case "40P01": // deadlock_detected
+			return ErrorClassDeadlock
+		case "40001": // serialization_failure
+			return ErrorClassSerialization
+		}
+		if strings.HasPrefix(err.SQLState(), "08") { // connection_exception
+			return ErrorClassConnection
+		}
+	case interface{ Code() int }: // godror
+		switch err.Code() {
+		case 60: // ORA-00060: deadlock detected while waiting for resource
+			return ErrorClassDeadlock
+		case 8177: // ORA-08177: can't serialize access for this transaction
+			return ErrorClassSerialization
+		case 3113, 3114, 12541: // ORA-03113, ORA-03114, ORA-12541: lost or no connection
+			return ErrorClassConnection
+		}
+	case interface{ SQLErrorNumber() int32 }: // go-mssqldb
+		switch err.SQLErrorNumber() {
+		case 1205:
+			return ErrorClassDeadlock
+		}
+	}
+	return ErrorClassUnknown
+}
+
+// classifyMessage classifies an error using its message.
+func classifyMessage(msg string) ErrorClass {
+	msg = strings.ToLower(msg)
+	switch {
+	case strings.Contains(msg, "deadlock"),
+		strings.Contains(msg, "ora-00060"),
+		strings.Contains(msg, "sqlstate 40p01"):
+		return ErrorClassDeadlock
+	case strings.Contains(msg, "could not serialize"),
+		strings.Contains(msg, "can't serialize"),
+		strings.Contains(msg, "ora-08177"),
+		strings.Contains(msg, "sqlstate 40001"):
+		return ErrorClassSerialization
+	case strings.Contains(msg, "connection refused"),
+		strings.Contains(msg, "connection reset"),
+		strings.Contains(msg, "bad connection"),
+		strings.Contains(msg, "invalid connection"),
+		strings.Contains(msg, "broken pipe"),
+		strings.Contains(msg, "no route to host"),
+		strings.Contains(msg, "ora-03113"),
+		strings.Contains(msg, "ora-03114"),
+		strings.Contains(msg, "ora-12541"):
+		return ErrorClassConnection
+	}
+	return ErrorClassUnknown
+}
@@ -0,0 +1,48 @@
+package db_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/bdlm/db"
+	"github.com/bdlm/errors/v2"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+type sqlStateErr string
+
+func (e sqlStateErr) Error() string    { return "pq: error " + string(e) }
+func (e sqlStateErr) SQLState() string { return string(e) }
+
+// TestClassifyError tests error classification across drivers.
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err    error
+		expect db.ErrorClass
+	}{
+		{nil, db.ErrorClassUnknown},
+		{fmt.Errorf("syntax error"), db.ErrorClassUnknown},
+		{&mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}, db.ErrorClassDeadlock},
+		{sqlStateErr("40P01"), db.ErrorClassDeadlock},
+		{sqlStateErr("40001"), db.ErrorClassSerialization},
+		{fmt.Errorf("ORA-00060: deadlock detected while waiting for resource"), db.ErrorClassDeadlock},
+		{fmt.Errorf("ORA-08177: can't serialize access for this transaction"), db.ErrorClassSerialization},
+		{errors.Wrap(&mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}, "exec failed"), db.ErrorClassDeadlock},
+		{driver.ErrBadConn, db.ErrorClassConnection},
+		{sqlStateErr("08006"), db.ErrorClassConnection},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}, db.ErrorClassConnection},
+		{fmt.Errorf("ORA-03113: end-of-file on communication channel"), db.ErrorClassConnection},
+		{context.DeadlineExceeded, db.ErrorClassUnknown},
+		{errors.Wrap(context.DeadlineExceeded, "query failed"), db.ErrorClassUnknown},
+		{context.Canceled, db.ErrorClassUnknown},
+		{errors.Wrap(context.Canceled, "query failed"), db.ErrorClassUnknown},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expect, db.ClassifyError(test.err), fmt.Sprintf("%v", test.err))
+	}
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/bdlm/errors/v2"
+)
+
+// cockroachClusterOption is the connection option that routes connections to
+// a CockroachDB cluster on a shared host.
+const cockroachClusterOption = "--cluster="
+
+// Generate a CockroachDB DSN string in the `postgresql://` URL form.
+// DSNData["cluster"], if set, is added as the `options=--cluster=name`
+// routing parameter. The port defaults to 26257 and the "sslmode" param to
+// "verify-full".
+func cockroachGenerateDSN(cfg *Config) {
+	port := cfg.DSNData["port"]
+	if "" == port {
+		port = "26257"
+	}
+
+	params := url.Values{}
+	for k, v := range cfg.Params {
+		params.Set(k, v)
+	}
+	if "" == params.Get("sslmode") {
+		params.Set("sslmode", "verify-full")
+	}
+	if cluster := cfg.DSNData["cluster"]; "" != cluster {
+		options := strings.TrimSpace(params.Get("options") + " " + cockroachClusterOption + cluster)
+		params.Set("options", options)
+	}
+
+	dsn := url.URL{
+		Scheme:   "postgresql",
+		User:     url.UserPassword(cfg.DSNData["user"], cfg.DSNData["pass"]),
+		Host:     net.JoinHostPort(cfg.DSNData["host"], port),
+		Path:     "/" + cfg.DSNData["name"],
+		RawQuery: params.Encode(),
+	}
+	cfg.DSNString = dsn.String()
+}
+
+// Parse CockroachDB DSN strings, either in the `postgresql://` URL form or
+// the libpq key/value form. The cluster routing key is read from the
+// `options=--cluster=name` parameter into DSNData["cluster"] and is required.
+func cockroachParseDSN(cfg *Config) error {
+	if strings.HasPrefix(cfg.DSNString, "postgres://") || strings.HasPrefix(cfg.DSNString, "postgresql://") {
+		dsn, err := url.Parse(cfg.DSNString)
+		if nil != err {
+			return err
+		}
+		cfg.DSNData["host"] = dsn.Hostname()
+		cfg.DSNData["port"] = dsn.Port()
+		cfg.DSNData["user"] = dsn.User.Username()
+		cfg.DSNData["pass"], _ = dsn.User.Password()
+		cfg.DSNData["name"] = strings.TrimPrefix(dsn.Path, "/")
+		params := dsn.Query()
+		for k := range params {
+			cfg.Params[k] = params.Get(k)
+		}
+	} else {
+		parsedCfg, err := pqParseDSN(cfg.DSNString)
+		if nil != err {
+			return err
+		}
+		cfg.DSNData["host"] = parsedCfg["host"]
+		cfg.DSNData["port"] = parsedCfg["port"]
+		cfg.DSNData["user"] = parsedCfg["user"]
+		cfg.DSNData["pass"] = parsedCfg["password"]
+		cfg.DSNData["name"] = parsedCfg["dbname"]
+		for k, v := range parsedCfg {
+			if k != "host" && k != "port" && k != "user" && k != "password" && k != "dbname" {
+				cfg.Params[k] = v
+			}
+		}
+	}
+	if "" == cfg.DSNData["port"] {
+		cfg.DSNData["port"] = "26257"
+	}
+
+	// Move the cluster routing key out of the connection options.
+	options := []string{}
+	for _, option := range strings.Fields(cfg.Params["options"]) {
+		if cluster, ok := strings.CutPrefix(option, cockroachClusterOption); ok {
+			cfg.DSNData["cluster"] = cluster
+			continue
+		}
+		options = append(options, option)
+	}
+	if "" == cfg.DSNData["cluster"] {
+		return errors.Errorf("cockroach DSN has no cluster routing key, i.e. options=%sname", cockroachClusterOption)
+	}
+	if 0 == len(options) {
+		delete(cfg.Params, "options")
+	} else {
+		cfg.Params["options"] = strings.Join(options, " ")
+	}
+
+	return nil
+}
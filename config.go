@@ -3,22 +3,77 @@ package db
 import (
 	"context"
 	"crypto/tls"
+	"database/sql"
 	"database/sql/driver"
 	"fmt"
+	"io"
+	"maps"
+	"reflect"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/bdlm/errors/v2"
 	nr "github.com/newrelic/go-agent/v3/newrelic"
+	"go.opentelemetry.io/otel/metric"
 )
 
 // Config represents a database client configuration, used to create DSN
 // strings or store values parsed out of a DSN string.
 type Config struct {
+	// Optional, the time the circuit breaker stays open before requests are
+	// allowed through again. See BreakerThreshold.
+	BreakerCooldown time.Duration
+
+	// Optional, the number of consecutive connection failures (see
+	// ErrorClassConnection) after which queries, statements, and
+	// transactions fail fast with ErrCircuitOpen for BreakerCooldown, rather
+	// than waiting on an unhealthy database. When the cooldown has elapsed
+	// a single request is tried while others keep failing fast; its success
+	// closes the breaker and a connection failure re-opens it. Disabled if
+	// zero.
+	BreakerThreshold int
+
 	// Recommended, a database connector or driver instance is required to instrument
 	// database queries with NewRelic.
 	Connector driver.Connector
 
+	// Optional, function called with the position and name of each result
+	// column read by MapScan, returning the key to store its value under,
+	// i.e. to prefix joined columns with their table name. Its keys must be
+	// unique. By default duplicate column names are suffixed with their
+	// occurrence, i.e. "id" and "id_2".
+	ColumnRenamer func(idx int, name string) string
+
+	// Optional, the maximum time a pooled connection may be idle before it's
+	// closed. See sql.DB.SetConnMaxIdleTime. Driver default if zero.
+	ConnMaxIdleTime time.Duration
+
+	// Optional, the maximum time a pooled connection may be reused before
+	// it's closed. See sql.DB.SetConnMaxLifetime. Driver default if zero.
+	ConnMaxLifetime time.Duration
+
+	// Optional, the maximum time New waits to connect to and ping the
+	// database. Distinct from query timeouts; it doesn't apply to later
+	// reconnects. The error returned on timeout names the host being
+	// connected to, without credentials. Disabled if zero.
+	ConnectTimeout time.Duration
+
+	// Optional, conversions from driver values to destination types used by
+	// Scan, Next, StructScan, and StructNext, keyed by the destination type,
+	// i.e. reflect.TypeOf(Money(0)). Lets domain types be scanned without
+	// implementing sql.Scanner on each of them. The function receives the
+	// driver value, which is nil for NULL columns, and returns a value that
+	// is stored in the destination with the conversion rules of
+	// sql.Rows.Scan, so nil is only accepted for pointer types.
+	Converters map[reflect.Type]func(src interface{}) (interface{}, error)
+
+	// Optional, omit the header row of column names from CSV exports written
+	// by WriteCSV and QueryCSV, i.e. when appending to an existing file.
+	CSVNoHeader bool
+
 	// cancel provides the context cancellation function used internally to manage graceful shutdown.
 	Cancel context.CancelFunc
 
@@ -29,6 +84,31 @@ type Config struct {
 	// metrics. i.e. "CPROD1"
 	DatabaseName string
 
+	// Optional, a statement timeout applied to every new pooled connection
+	// at the session level (`statement_timeout` for postgres,
+	// `max_execution_time` for mysql, `STATEMENT_TIMEOUT_IN_SECONDS` for
+	// snowflake). All queries inherit the limit regardless of the context
+	// they're executed with. Requires a supported DriverType.
+	DefaultStatementTimeout time.Duration
+
+	// Optional, skip all instrumentation even when NewRelic, Tracer, or
+	// MeterProvider is set: the driver isn't wrapped, no New Relic
+	// transactions or spans are started, and no metrics are recorded, i.e.
+	// for local development without removing the configuration. OnQuery and
+	// slow-query logging are unaffected.
+	DisableInstrumentation bool
+
+	// Optional, don't prepare statements on the server for the "cockroach"
+	// and "postgres" DriverTypes. Statements are executed directly in their
+	// transaction instead, so they work through PgBouncer in transaction
+	// pooling mode, where a server-side prepared statement may not exist on
+	// the connection the next execution is routed to. lib/pq then binds
+	// arguments with an unnamed statement; the pgx driver also needs simple
+	// protocol execution enabled, i.e. `default_query_exec_mode=simple_protocol`
+	// in the DSN (pgx.QueryExecModeSimpleProtocol), since it otherwise caches
+	// prepared statements per connection.
+	DisableServerPrepare bool
+
 	// Recommended, a database connector or driver instance is required to instrument
 	// database queries with NewRelic.
 	Driver driver.Driver // database/sql/driver.Driver instance
@@ -38,11 +118,18 @@ type Config struct {
 
 	// Optional, used when generating a DSN string if a DSNString or DSNFn are not provided.
 	// Automatic DSN generation using DSNData is supported for several database drivers
-	DriverType string // i.e. "oracle", "postgres", "snowflake"
+	DriverType string // i.e. "cockroach", "oracle", "postgres", "snowflake", "sqlite", "sqlserver"
 
 	// Optional, any data needed to generate the DSN string.
 	DSNData map[string]string
 
+	// Optional, the DSN format used to parse and generate DSN strings when it
+	// differs from DriverType, i.e. "postgres" for a "cockroach" DriverType
+	// to use the plain postgres format without cluster routing. One of
+	// "cockroach", "mysql", "oracle", "postgres", "snowflake", "sqlite", or
+	// "sqlserver". Defaults to DriverType.
+	DSNDialect string
+
 	// Optional, function to generate the DSN string. *Config.DSNData will be passed in.
 	//
 	// Something like:
@@ -58,17 +145,395 @@ type Config struct {
 	// Optional, DSN string used to connect to the database.
 	DSNString string
 
+	// Optional, word overrides for the snake_case to CamelCase conversion
+	// StructScan uses to match untagged fields, keyed by lowercase column name
+	// segment, i.e. {"sku": "SKU"} maps "item_sku" to ItemSKU. Entries take
+	// precedence over DefaultFieldMapper.
+	FieldMapper map[string]string
+
+	// Optional, function called with the name and value of each argument
+	// bound to a statement executed with Exec, Query, QueryRow, ExecArray,
+	// ExecBatch, or UpdateBatch, returning the value to send to the driver
+	// instead, i.e. to encrypt values bound for encrypted-at-rest columns in
+	// one place. Positional arguments are passed with an empty name. Values
+	// the function doesn't handle should be returned unchanged. An error
+	// aborts the execution. See ScanTransform.
+	BindTransform func(name string, value interface{}) (interface{}, error)
+
+	// Optional, case folding applied to the identifiers emitted by SQL
+	// generating helpers, i.e. FoldUpper for Oracle or FoldLower for
+	// postgres, so generated column lists match how the database folds
+	// unquoted identifiers. Applied by InsertLists, BindStructInsert,
+	// UpdateBatch, InsertReturningID, DeleteReturning, CreateTempTable, and
+	// QuoteIdentifier. See Config.FoldIdentifier.
+	FoldIdentifiers IdentifierFolding
+
+	// Optional, ping the database at this interval for as long as it's open,
+	// reconnecting when a ping fails, so connections behind proxies that drop
+	// idle sessions stay usable. Each ping is bounded by the interval.
+	// Disabled if zero.
+	KeepAliveInterval time.Duration
+
+	// Optional, skip connecting to the database in New. The connection is
+	// made by the first query, statement, or transaction instead, so
+	// applications can start while the database is unavailable.
+	LazyConnect bool
+
+	// Optional, store LOB columns as an io.ReadCloser in MapScan results
+	// instead of a []byte. Values are only streamed if the driver returns LOB
+	// locators, i.e. godror with godror.LobAsReader; otherwise the reader
+	// reads a copy of the value. See Statement.MapScan.
+	LazyLOB bool
+
 	// Location data storage for DSNParser or DSNFn.
 	Loc *time.Location
 
+	// Optional, names of result columns whose values are replaced with
+	// MaskedValue in MapScan results and CSV/JSON exports, i.e. "ssn".
+	// Matching is case-insensitive. Scan, Next, and StructScan still read the
+	// real values.
+	MaskColumns []string
+
+	// Optional, the maximum number of idle connections kept in the pool. Must
+	// not exceed MaxOpenConns when both are set. See sql.DB.SetMaxIdleConns.
+	// Driver default if zero.
+	MaxIdleConns int
+
+	// Optional, the maximum number of open connections to the database,
+	// including those in use. See sql.DB.SetMaxOpenConns. Unlimited if zero.
+	MaxOpenConns int
+
+	// Optional, route QueryReadContext queries to the primary instead of
+	// ReadReplica while the replica lags behind it by more than this, or its
+	// lag can't be read. The lag is read with DB.ReplicaLag and cached for a
+	// second. Lag isn't checked if zero.
+	MaxReplicaLag time.Duration
+
+	// Optional, the maximum lifetime of the transaction created for each
+	// prepared statement. Transactions that aren't committed or rolled back
+	// in time are rolled back automatically, releasing their locks, and
+	// further operations on the statement return ErrTxExpired. Disabled if
+	// zero.
+	MaxTxLifetime time.Duration
+
+	// Optional, an OpenTelemetry meter provider used to record query
+	// metrics: a `db.client.queries` counter, a `db.client.query.duration`
+	// histogram, a `db.client.query.errors` counter, and a
+	// `db.client.prepare.duration` histogram of statement preparation time,
+	// attributed by operation and table. See QueryEvent.
+	MeterProvider metric.MeterProvider
+
 	// NewRelic application instance
 	NewRelic *nr.Application
 
+	// Optional, replace numeric and string literals with `?` in the queries
+	// reported to NewRelic, so queries that differ only in literal values are
+	// reported as one. See NormalizeQuery.
+	NormalizeMetrics bool
+
+	// Optional, function called for every new pooled connection before it is
+	// used. Useful for issuing session setup statements. If an error is
+	// returned the connection is discarded.
+	OnConnect func(ctx context.Context, conn driver.Conn) error
+
+	// Optional, function called by the reconnect monitor when the database
+	// connection is lost, with the failed ping's error, and when it's
+	// re-established, with a nil error. Useful for gating traffic while the
+	// database is unavailable. See DB.StartReconnectMonitor.
+	OnConnectionState func(connected bool, err error)
+
+	// Optional, function called immediately after a transaction is started,
+	// before any statements are prepared on it. Useful for issuing `SET
+	// TRANSACTION` statements. If an error is returned the transaction is
+	// rolled back.
+	OnBeginTx func(ctx context.Context, tx *sql.Tx) error
+
+	// Optional, function called after every query and statement execution
+	// with a description of the execution. It must not block.
+	OnQuery func(event QueryEvent)
+
+	// Optional, function called for each insert, update, or delete statement
+	// once its changes are committed, with the target table and operation
+	// parsed by ParseStatement: immediately for DB.Exec, and when Commit
+	// succeeds for statements executed in a transaction. Rolled back writes
+	// aren't reported. rowsAffected is -1 if the driver doesn't report it.
+	// Useful for invalidating caches keyed by table.
+	OnWrite func(table string, op string, rowsAffected int64)
+
+	// Optional, log a warning with the stack that began any transaction
+	// still open after this long, i.e. to find slow transactions in
+	// production. Only transactions finished by this package are watched,
+	// those begun by Begin, Prepare, PrepareTx, and Transaction; a *sql.Tx
+	// returned by BeginTx is the caller's to finish. Transactions aren't
+	// terminated, see MaxTxLifetime for that. Each transaction is reported
+	// once. Capturing the stack has a cost, so it's only done when this is
+	// set. Disabled if zero.
+	OpenTxWarnAfter time.Duration
+
+	// Optional, the form of connect identifier generated for oracle DSN
+	// strings. See OracleConnectMode.
+	OracleConnectMode OracleConnectMode
+
 	// Additional connection parameter storage for DSNParser or DSNFn.
 	Params map[string]string
 
+	// Optional, returns the current database password when connecting, i.e.
+	// from a secret store that rotates credentials. It's called for each new
+	// physical connection the pool opens, so rotated passwords are picked up
+	// without a restart or reconnect, and may be called concurrently. The
+	// password replaces DSNData["pass"] in the generated DSN and isn't
+	// stored in DSNData or DSNString. Not used when DSNString is set.
+	PasswordFn func(ctx context.Context) (string, error)
+
+	// Optional, a connection to a read replica of the database. Queries run
+	// with QueryReadContext are sent to the replica, see MaxReplicaLag.
+	// A `/* route: replica */` or `/* route: primary */` hint in a query
+	// overrides this routing, see QueryReadContext.
+	ReadReplica *DB
+
+	// Optional, rewrite the `:name` and `@name` placeholders of statements to
+	// the positional placeholders of the DriverType, `$1` for "cockroach" and
+	// "postgres" and `?` for others, and pass bound values by position, for
+	// drivers that don't support sql.Named arguments, i.e. snowflake. A
+	// placeholder repeated in the query is bound to the same value.
+	RebindNamed bool
+
+	// Optional, write the argument values of queries recorded by
+	// RecordQueries in plaintext, so they can be replayed as they were
+	// executed. Values of arguments named in MaskColumns are still replaced
+	// with a hash. Positional arguments have no name and can't be masked, so
+	// only enable this if no query binds sensitive values by position.
+	RecordPlaintext bool
+
+	// Optional, write each executed query with its arguments and start time
+	// to this writer as JSON lines, i.e. to capture a production workload
+	// and replay it in staging with Replay. Argument values are replaced
+	// with a hash unless RecordPlaintext is set, so recordings don't leak
+	// bound secrets. Write errors are logged and otherwise ignored.
+	RecordQueries io.Writer
+
+	// Optional, the context key of a request ID. When set, the request ID
+	// carried by the context of each query is added to executed query logs
+	// and as a custom attribute of New Relic transactions, correlating
+	// database activity with the originating request.
+	RequestIDKey interface{}
+
+	// Optional, the fraction of each retry delay randomly added or
+	// subtracted so that clients don't retry in lockstep, i.e. 0.2 for ±20%.
+	// Used by RetryPolicy, TxRetryDelay, and StartReconnectMonitor unless
+	// RetryPolicy.Jitter or BackoffConfig.Jitter are set.
+	RetryJitter float64
+
+	// Optional, the maximum total time spent on a statement or
+	// TransactionRetry including its retries. No retry is attempted if its
+	// delay would exceed the time left. Unlimited if zero.
+	RetryMaxElapsed time.Duration
+
+	// Optional, retry statement executions that fail with a transient
+	// error, such as a dropped connection, in a new transaction. See
+	// RetryPolicy. No retries by default.
+	RetryPolicy RetryPolicy
+
+	// Optional, resolves DSNData values from a secret store when connecting.
+	// Each DSNData value of the form "secret://name" is replaced with the
+	// value returned for name before the DSN string is generated, i.e.
+	// {"user": "secret://db/user", "pass": "secret://db/pass"}. The bool
+	// result reports whether the secret exists. Secrets are resolved for each
+	// new physical connection and aren't stored in DSNData or DSNString, so
+	// rotated values are picked up as the pool opens connections. Not used
+	// when DSNString is set.
+	SecretResolver func(ctx context.Context, name string) (string, bool, error)
+
+	// Optional, store []byte values read by MapScan as strings, i.e. for
+	// Oracle VARCHAR2 or MySQL TEXT columns returned as bytes, so they
+	// marshal to JSON as text rather than base64. LOB values read with
+	// LazyLOB are still stored as readers.
+	ScanBytesAsString bool
+
+	// Optional, function called with the name and driver value of each
+	// result column read by MapScan and StructScan, returning the value to
+	// store in the destination instead, i.e. to decrypt encrypted-at-rest
+	// columns in one place. Values of columns the function doesn't handle
+	// should be returned unchanged. An error aborts the scan.
+	ScanTransform func(column string, value interface{}) (interface{}, error)
+
+	// Optional, include the call stack that issued a slow query in the
+	// slow-query log entry, excluding frames in this package. Capturing the
+	// stack has a cost, so it's only done when this is set. See
+	// SlowQueryThreshold.
+	SlowQueryStack bool
+
+	// Optional, queries and statements that take longer than this to execute
+	// are logged at the warning level. Disabled if zero.
+	SlowQueryThreshold time.Duration
+
+	// Optional, return an error from StructScan and StructNext for result
+	// columns without a matching struct field, rather than discarding them,
+	// so queries and structs that drift apart are caught.
+	StrictStructScan bool
+
+	// Optional, key the statement cache by the query with its comments
+	// stripped and whitespace collapsed, so queries built with different
+	// formatting share a prepared statement. Quoted strings are left
+	// unchanged, with backslash escapes for the "mysql" DriverType. Queries
+	// that differ only in comments share the statement prepared for the
+	// first of them. Oracle `/*+ ... */` optimizer hints aren't stripped, so
+	// queries with different hints are prepared separately. See
+	// StmtCacheSize.
+	StatementCacheNormalize bool
+
+	// Optional, prepare each distinct query passed to DB.Prepare once on the
+	// connection pool and reuse the prepared statement in each statement's
+	// transaction (see sql.Tx.StmtContext), rather than preparing it again
+	// in every transaction. Up to this many queries are cached, the least
+	// recently used statement is closed when another is added. The cache is
+	// cleared on reconnect and Close, or by DB.ClearStmtCache. Not used if
+	// DisableServerPrepare is set. Disabled if zero.
+	StmtCacheSize int
+
 	// TLS configuration value storage for DSNParser or DSNFn.
 	TLS *tls.Config
+
+	// Optional, traces statement preparation, execution, and queries, and
+	// transaction commits and rollbacks, i.e. with the OpenTelemetry adapter
+	// in the otel subpackage or the Prometheus collector in the prometheus
+	// subpackage. Used alongside the New Relic instrumentation when NewRelic
+	// is also set.
+	Tracer Tracer
+
+	// Optional, the default options transactions are begun with, including
+	// the transactions of prepared statements. See DB.PrepareTx to set them
+	// per statement. Support for isolation levels varies by driver:
+	//
+	//   - "mysql": ReadUncommitted, ReadCommitted, RepeatableRead,
+	//     Serializable, and ReadOnly.
+	//   - "oracle": ReadCommitted, Serializable, and ReadOnly.
+	//   - "postgres": ReadCommitted, RepeatableRead, Serializable, and
+	//     ReadOnly. ReadUncommitted behaves as ReadCommitted.
+	//   - "sqlite": the default level only, transactions are serializable.
+	//   - "sqlserver": all levels including Snapshot, but not ReadOnly.
+	//
+	// Drivers return an error when beginning a transaction with options they
+	// don't support. Driver default if nil.
+	TxOptions *sql.TxOptions
+
+	// Optional, the delay before the first retry made by TransactionRetry,
+	// multiplied by RetryPolicy.Multiplier for each further retry, limited
+	// to RetryPolicy.MaxDelay, and randomized by RetryJitter. Retries are
+	// immediate if zero.
+	TxRetryDelay time.Duration
+
+	// Optional, scan []byte columns without copying them. Scan, Next,
+	// StructScan, and MapScan return []byte values referring to memory owned
+	// by the driver, which is only valid until the next call to Next, Scan,
+	// or Close. Reduces allocations for large scans of binary or text columns.
+	UseRawBytes bool
+}
+
+// ConfigFromStruct returns a Config populated from the `db` tagged fields of
+// an application configuration struct, or a pointer to one, so existing
+// configuration types don't have to be translated by hand:
+//
+//	type AppConfig struct {
+//		Host       string `db:"host"`
+//		Port       int    `db:"port"`
+//		User       string `db:"user"`
+//		Pass       string `db:"pass"`
+//		DriverType string `db:"driver_type"`
+//		SSLMode    string `db:"sslmode"`
+//	}
+//
+// The "driver_type", "driver_name", "database_name", and "dsn" tags set the
+// DriverType, DriverName, DatabaseName, and DSNString fields. DSN component
+// tags, i.e. "host", "port", "user", "pass", and "name", populate DSNData,
+// and any other tags populate Params. Fields of embedded structs are
+// included, and fields that are untagged, tagged "-", or hold the zero value
+// are skipped. Tagged fields must have a string, numeric, bool,
+// time.Duration, or fmt.Stringer type. Durations are written as whole seconds,
+// rounded up, as timeout parameters like postgres connect_timeout expect; tag
+// a field with the "duration" option, i.e. `db:"timeout,duration"`, to write
+// it in time.Duration format instead, as the mysql driver expects.
+func ConfigFromStruct(src interface{}) (*Config, error) {
+	val := reflect.ValueOf(src)
+	for reflect.Ptr == val.Kind() && !val.IsNil() {
+		val = val.Elem()
+	}
+	if reflect.Struct != val.Kind() {
+		return nil, errors.Errorf("cannot read configuration from %T, a struct is required", src)
+	}
+
+	cfg := &Config{
+		DSNData: map[string]string{},
+		Params:  map[string]string{},
+	}
+	if err := cfg.readStruct(val); nil != err {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// readStruct reads the tagged fields of a struct value, see
+// ConfigFromStruct.
+func (cfg *Config) readStruct(val reflect.Value) error {
+	typ := val.Type()
+	for a := 0; a < typ.NumField(); a++ {
+		field := typ.Field(a)
+		tag, tagged := field.Tag.Lookup("db")
+		if "-" == tag {
+			continue
+		}
+		if field.Anonymous && reflect.Struct == field.Type.Kind() && !tagged {
+			if err := cfg.readStruct(val.Field(a)); nil != err {
+				return err
+			}
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+		if !field.IsExported() || "" == name || val.Field(a).IsZero() {
+			continue
+		}
+
+		var value string
+		switch v := val.Field(a).Interface().(type) {
+		case time.Duration:
+			if slices.Contains(opts[1:], "duration") {
+				value = v.String()
+			} else {
+				value = strconv.FormatInt(int64((v+time.Second-1)/time.Second), 10)
+			}
+		case fmt.Stringer:
+			value = v.String()
+		default:
+			switch field.Type.Kind() {
+			case reflect.String, reflect.Bool,
+				reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+				reflect.Float32, reflect.Float64:
+				value = fmt.Sprint(v)
+			default:
+				return errors.Errorf("unsupported type %s for configuration field %s", field.Type, field.Name)
+			}
+		}
+
+		switch name {
+		case "driver_type":
+			cfg.DriverType = value
+		case "driver_name":
+			cfg.DriverName = value
+		case "database_name":
+			cfg.DatabaseName = value
+		case "dsn":
+			cfg.DSNString = value
+		default:
+			if dsnDataKeys[name] {
+				cfg.DSNData[name] = value
+			} else {
+				cfg.Params[name] = value
+			}
+		}
+	}
+	return nil
 }
 
 // DSN returns a DSN string based on configuration values.
@@ -79,6 +544,46 @@ func (cfg *Config) DSN() string {
 	return cfg.DSNString
 }
 
+// dynamicDSN reports whether the DSN is resolved for each connection, see
+// dsn.
+func (cfg *Config) dynamicDSN() bool {
+	return (nil != cfg.SecretResolver || nil != cfg.PasswordFn) && "" == cfg.DSNString
+}
+
+// dsn returns the DSN string used to connect, resolving any secret
+// references in DSNData with SecretResolver and the password with
+// PasswordFn.
+func (cfg *Config) dsn(ctx context.Context) (string, error) {
+	if !cfg.dynamicDSN() {
+		return cfg.DSN(), nil
+	}
+
+	resolved := *cfg
+	resolved.DSNData = make(map[string]string, len(cfg.DSNData))
+	for key, value := range cfg.DSNData {
+		if name, ok := strings.CutPrefix(value, secretScheme); ok && nil != cfg.SecretResolver {
+			secret, found, err := cfg.SecretResolver(ctx, name)
+			if nil != err {
+				return "", errors.Wrap(err, "unable to resolve secret for DSN field '%s'", key)
+			}
+			if !found {
+				return "", errors.Errorf("secret not found for DSN field '%s'", key)
+			}
+			value = secret
+		}
+		resolved.DSNData[key] = value
+	}
+	if nil != cfg.PasswordFn {
+		pass, err := cfg.PasswordFn(ctx)
+		if nil != err {
+			return "", errors.Wrap(err, "unable to resolve the database password")
+		}
+		resolved.DSNData["pass"] = pass
+	}
+	resolved.generateDSN()
+	return resolved.DSNString, nil
+}
+
 // ParseDSN will parse a Data Source Name (DSN) string and return the database
 // configuration values.
 func (cfg *Config) ParseDSN() error {
@@ -100,7 +605,10 @@ func (cfg *Config) ParseDSN() error {
 		return cfg.DSNParser(cfg)
 	}
 
-	switch cfg.DriverType {
+	switch cfg.dsnDialect() {
+	// Parse cockroach DSN strings.
+	case "cockroach":
+		return cockroachParseDSN(cfg)
 	// Parse mysql DSN strings.
 	case "mysql":
 		return mysqlParseDSN(cfg)
@@ -113,6 +621,12 @@ func (cfg *Config) ParseDSN() error {
 		// Parse snowflake DSN strings.
 	case "snowflake":
 		return snowflakeParseDSN(cfg)
+		// Parse sqlite DSN strings.
+	case "sqlite":
+		return sqliteParseDSN(cfg)
+		// Parse sqlserver DSN strings.
+	case "sqlserver":
+		return sqlserverParseDSN(cfg)
 	}
 
 	// Try manually parsing some values out of it.
@@ -186,11 +700,189 @@ func (cfg *Config) ParseDSN() error {
 	return err
 }
 
+// ValidateDSN checks the syntax of the DSN string without connecting, i.e.
+// as a pre-flight check in config loaders. DSNString is validated if set,
+// otherwise a DSN string is generated from DSNData. The DSN string is parsed
+// with DSNParser if set, otherwise with the parser for the DSNDialect, i.e.
+// mysql.ParseDSN or gosnowflake.ParseDSN. The configuration isn't modified.
+func (cfg *Config) ValidateDSN() error {
+	probe := *cfg
+	probe.DSNData = maps.Clone(cfg.DSNData)
+	probe.Params = maps.Clone(cfg.Params)
+	if "" == probe.DSNString {
+		probe.generateDSN()
+		if "" == probe.DSNString {
+			return errors.Errorf("unable to generate a DSN string for driver type '%s'", probe.dsnDialect())
+		}
+	}
+
+	if nil == probe.DSNParser {
+		switch probe.dsnDialect() {
+		case "cockroach", "mysql", "oracle", "postgres", "snowflake", "sqlite", "sqlserver":
+		default:
+			return errors.Errorf("DSN validation is not supported for driver type '%s'", probe.dsnDialect())
+		}
+	}
+
+	probe.DSNData = map[string]string{}
+	probe.Params = map[string]string{}
+	if err := probe.ParseDSN(); nil != err {
+		return errors.Wrap(err, "invalid %s DSN string: %s", probe.dsnDialect(), err)
+	}
+	return nil
+}
+
+// host returns the host, TNS name, or account being connected to, without
+// credentials, for use in error messages. The database name is returned if
+// no host is configured.
+func (cfg *Config) host() string {
+	for _, key := range []string{"host", "tns", "account"} {
+		if host := cfg.DSNData[key]; "" != host {
+			return host
+		}
+	}
+	return cfg.DatabaseName
+}
+
+// dsnDialect returns the DSN format used to parse and generate DSN strings.
+func (cfg *Config) dsnDialect() string {
+	if "" != cfg.DSNDialect {
+		return cfg.DSNDialect
+	}
+	return cfg.DriverType
+}
+
+// columnKeys returns the keys MapScan stores result columns under, see
+// ColumnRenamer.
+func (cfg *Config) columnKeys(columns []string) ([]string, error) {
+	keys := make([]string, len(columns))
+	seen := make(map[string]bool, len(columns))
+	if nil != cfg.ColumnRenamer {
+		for a, column := range columns {
+			keys[a] = cfg.ColumnRenamer(a, column)
+			if seen[keys[a]] {
+				return nil, errors.Errorf("column renamer returned duplicate key '%s' for column %d '%s'", keys[a], a, column)
+			}
+			seen[keys[a]] = true
+		}
+		return keys, nil
+	}
+
+	for _, column := range columns {
+		seen[column] = true
+	}
+	counts := make(map[string]int, len(columns))
+	for a, column := range columns {
+		counts[column]++
+		keys[a] = column
+		if 1 == counts[column] {
+			continue
+		}
+		for n := counts[column]; seen[keys[a]]; n++ {
+			keys[a] = fmt.Sprintf("%s_%d", column, n)
+		}
+		seen[keys[a]] = true
+	}
+	return keys, nil
+}
+
+// masked reports whether a result column is listed in MaskColumns.
+func (cfg *Config) masked(column string) bool {
+	for _, mask := range cfg.MaskColumns {
+		if strings.EqualFold(mask, column) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestID returns the request ID carried by a context, if any.
+func (cfg *Config) requestID(ctx context.Context) string {
+	if nil == cfg.RequestIDKey || nil == ctx {
+		return ""
+	}
+	if id := ctx.Value(cfg.RequestIDKey); nil != id {
+		return fmt.Sprint(id)
+	}
+	return ""
+}
+
+// serverPrepare reports whether statements are prepared on the server, see
+// DisableServerPrepare.
+func (cfg *Config) serverPrepare() bool {
+	if !cfg.DisableServerPrepare {
+		return true
+	}
+	switch cfg.DriverType {
+	case "cockroach", "postgres":
+		return false
+	}
+	return true
+}
+
 // String implements Stringer. Prevent leaking credentials.
 func (cfg *Config) String() string {
 	return ""
 }
 
+// RedactedDSN returns the DSN string with the password, and the values of
+// parameters whose names contain "pass" or "pwd" such as "password", replaced
+// with "****", so it can be logged for debugging. The DSN is generated for
+// the DriverType from DSNData and Params, or from the values parsed out of
+// DSNString, so the password is located correctly for each format; unlike
+// DSN, the generated string isn't stored. DSN strings that can't be
+// generated, i.e. for unknown driver types, are masked using the generic DSN
+// pattern used by ParseDSN.
+func (cfg *Config) RedactedDSN() string {
+	redacted := *cfg
+	if "" != cfg.DSNString {
+		redacted.DSNData = nil
+		redacted.Params = nil
+		if err := redacted.ParseDSN(); nil != err {
+			return redactDSNString(cfg.DSNString)
+		}
+	}
+
+	data := make(map[string]string, len(redacted.DSNData))
+	for key, value := range redacted.DSNData {
+		data[key] = value
+	}
+	if "" != data["pass"] {
+		data["pass"] = redactedPlaceholder
+	}
+	redacted.DSNData = data
+
+	params := make(map[string]string, len(redacted.Params))
+	for key, value := range redacted.Params {
+		if "" != value && passwordParamRegex.MatchString(key) {
+			value = redactedPlaceholder
+		}
+		params[key] = value
+	}
+	redacted.Params = params
+
+	redacted.DSNString = ""
+	redacted.generateDSN()
+	if "" == redacted.DSNString {
+		return redactDSNString(cfg.DSNString)
+	}
+	return strings.ReplaceAll(redacted.DSNString, redactedPlaceholder, MaskedValue)
+}
+
+// redactDSNString masks the password group of the generic DSN pattern, see
+// ParseDSN, and the values of password parameters in a DSN string.
+func redactDSNString(dsn string) string {
+	if match := dsnPattern.FindStringSubmatchIndex(dsn); nil != match {
+		for a, name := range dsnPattern.SubexpNames() {
+			if start, end := match[2*a], match[2*a+1]; "passwd" == name && start < end {
+				dsn = dsn[:start] + MaskedValue + dsn[end:]
+				break
+			}
+		}
+	}
+	return passwordValueRegex.ReplaceAllString(dsn, "${1}"+MaskedValue)
+}
+
 // generateDSN populates DSNString using methods and data provided.
 func (cfg *Config) generateDSN() bool {
 	if nil == cfg.DSNData {
@@ -204,7 +896,9 @@ func (cfg *Config) generateDSN() bool {
 	}
 
 	// Builtin generators.
-	switch cfg.DriverType {
+	switch cfg.dsnDialect() {
+	case "cockroach":
+		cockroachGenerateDSN(cfg)
 	case "mysql":
 		mysqlGenerateDSN(cfg)
 	case "oracle":
@@ -213,6 +907,10 @@ func (cfg *Config) generateDSN() bool {
 		pqGenerateDSN(cfg)
 	case "snowflake":
 		snowflakeGenerateDSN(cfg)
+	case "sqlite":
+		sqliteGenerateDSN(cfg)
+	case "sqlserver":
+		sqlserverGenerateDSN(cfg)
 	default:
 		return false
 	}
@@ -240,6 +938,33 @@ var (
 	// ErrInvalidTLSConfig defines the invalid TLS config error.
 	ErrInvalidTLSConfig = fmt.Errorf("invalid value / unknown config name")
 
+	// MaskedValue replaces the values of masked columns.
+	MaskedValue = "****"
+
+	// dsnDataKeys are the DSNData keys used by the supported DSN formats,
+	// see ConfigFromStruct.
+	dsnDataKeys = map[string]bool{
+		"account": true, "addr": true, "cluster": true, "db": true, "host": true, "name": true,
+		"net": true, "pass": true, "port": true, "role": true, "schema": true,
+		"service": true, "tns": true, "user": true, "warehouse": true,
+	}
+
+	// passwordParamRegex matches the names of DSN parameters holding
+	// passwords, see RedactedDSN.
+	passwordParamRegex = regexp.MustCompile(`(?i)pass|pwd`)
+
+	// passwordValueRegex matches password parameter values in DSN strings,
+	// see RedactedDSN.
+	passwordValueRegex = regexp.MustCompile(`(?i)(\b\w*(?:pass|pwd)\w*=)[^&;\s]*`)
+
+	// redactedPlaceholder stands in for passwords while a redacted DSN is
+	// generated, see RedactedDSN. It's made of characters that no DSN format
+	// escapes.
+	redactedPlaceholder = "REDACTEDxPASSWORD"
+
+	// secretScheme prefixes DSNData values resolved by SecretResolver.
+	secretScheme = "secret://"
+
 	// Data Source Name Parser
 	// https://github.com/go-sql-driver/mysql/blob/f4bf8e8e0aa93d4ead0c6473503ca2f5d5eb65a8/utils.go#L34-L40
 	dsnPattern = regexp.MustCompile(
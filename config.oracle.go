@@ -5,16 +5,74 @@ import (
 	"strings"
 )
 
+// OracleConnectMode selects the form of connect identifier used when
+// generating Oracle DSN strings.
+type OracleConnectMode int
+
+const (
+	// OracleConnectAuto generates an EZConnect identifier when the host, port,
+	// and service are all provided, otherwise the bare host is used.
+	OracleConnectAuto OracleConnectMode = iota
+
+	// OracleEZConnect generates an EZConnect identifier: `host:port/service`.
+	// The port defaults to 1521.
+	OracleEZConnect
+
+	// OracleTNSAlias generates a tnsnames.ora alias identifier from
+	// DSNData["tns"], falling back to DSNData["host"].
+	OracleTNSAlias
+
+	// OracleDescriptor generates a full connect descriptor:
+	// `(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=host)(PORT=port))(CONNECT_DATA=(SERVICE_NAME=service)))`.
+	// The port defaults to 1521.
+	OracleDescriptor
+)
+
 // Generate an Oracle DSN string.
 func oracleGenerateDSN(cfg *Config) {
 	cfg.DSNString = fmt.Sprintf(
 		"%s/%s@%s",
 		cfg.DSNData["user"], // user name
 		cfg.DSNData["pass"], // password
-		cfg.DSNData["host"], // db host address
+		oracleConnectIdentifier(cfg),
 	)
 }
 
+// oracleConnectIdentifier returns the connect identifier for the configured
+// OracleConnectMode.
+func oracleConnectIdentifier(cfg *Config) string {
+	host := cfg.DSNData["host"]       // db host address
+	port := cfg.DSNData["port"]       // db port
+	service := cfg.DSNData["service"] // db service name
+
+	mode := cfg.OracleConnectMode
+	if OracleConnectAuto == mode {
+		if "" == host || "" == port || "" == service {
+			return host
+		}
+		mode = OracleEZConnect
+	}
+	if "" == port {
+		port = "1521"
+	}
+
+	switch mode {
+	case OracleEZConnect:
+		return fmt.Sprintf("%s:%s/%s", host, port, service)
+	case OracleTNSAlias:
+		if alias := cfg.DSNData["tns"]; "" != alias {
+			return alias
+		}
+		return host
+	case OracleDescriptor:
+		return fmt.Sprintf(
+			"(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=%s)(PORT=%s))(CONNECT_DATA=(SERVICE_NAME=%s)))",
+			host, port, service,
+		)
+	}
+	return host
+}
+
 // Parse Oracle DSN strings.
 func oracleParseDSN(cfg *Config) error {
 	if strings.Contains(cfg.DSNString, "/") &&
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Generate a SQLite DSN string. DSNData["name"] is the database file path or
+// ":memory:" for an in-memory database. The `file:` URI form is understood by
+// both mattn/go-sqlite3 and modernc.org/sqlite.
+func sqliteGenerateDSN(cfg *Config) {
+	cfg.DSNString = "file:" + cfg.DSNData["name"]
+	if len(cfg.Params) > 0 {
+		params := url.Values{}
+		for k, v := range cfg.Params {
+			params.Set(k, v)
+		}
+		cfg.DSNString = cfg.DSNString + "?" + params.Encode()
+	}
+}
+
+// Parse SQLite DSN strings, either plain file paths or `file:` URIs, with
+// optional query parameters, i.e. "file:app.db?_busy_timeout=5000&mode=ro".
+func sqliteParseDSN(cfg *Config) error {
+	name, query, _ := strings.Cut(strings.TrimPrefix(cfg.DSNString, "file:"), "?")
+	params, err := url.ParseQuery(query)
+	if nil != err {
+		return err
+	}
+
+	cfg.DSNData["name"] = name
+	for k := range params {
+		cfg.Params[k] = params.Get(k)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/bdlm/errors/v2"
+)
+
+// Generate a SQL Server DSN string in the `sqlserver://` URL form used by
+// microsoft/go-mssqldb. Params such as "encrypt", "TrustServerCertificate",
+// and "app name" are added to the query string.
+func sqlserverGenerateDSN(cfg *Config) {
+	if _, ok := cfg.DSNData["port"]; !ok {
+		cfg.DSNData["port"] = "1433"
+	}
+
+	params := url.Values{}
+	if "" != cfg.DSNData["name"] {
+		params.Set("database", cfg.DSNData["name"])
+	}
+	for k, v := range cfg.Params {
+		params.Set(k, v)
+	}
+
+	dsn := url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(cfg.DSNData["user"], cfg.DSNData["pass"]),
+		Host:     net.JoinHostPort(cfg.DSNData["host"], cfg.DSNData["port"]),
+		RawQuery: strings.ReplaceAll(params.Encode(), "+", "%20"),
+	}
+	cfg.DSNString = dsn.String()
+}
+
+// Parse SQL Server DSN strings in the `sqlserver://` URL form.
+func sqlserverParseDSN(cfg *Config) error {
+	dsn, err := url.Parse(cfg.DSNString)
+	if nil != err {
+		return err
+	}
+	if "sqlserver" != dsn.Scheme {
+		return errors.Errorf("invalid sqlserver DSN scheme '%s'", dsn.Scheme)
+	}
+
+	cfg.DSNData["host"] = dsn.Hostname()
+	cfg.DSNData["port"] = dsn.Port()
+	if "" == cfg.DSNData["port"] {
+		cfg.DSNData["port"] = "1433"
+	}
+	cfg.DSNData["user"] = dsn.User.Username()
+	cfg.DSNData["pass"], _ = dsn.User.Password()
+
+	params := dsn.Query()
+	cfg.DSNData["name"] = params.Get("database")
+	for k := range params {
+		if "database" != k {
+			cfg.Params[k] = params.Get(k)
+		}
+	}
+
+	return nil
+}
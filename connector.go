@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"math"
+
+	"github.com/bdlm/errors/v2"
+)
+
+// connector wraps a driver.Connector and runs the configured session setup
+// on every new pooled connection.
+// https://golang.org/pkg/database/sql/driver/#Connector
+type connector struct {
+	base driver.Connector
+	cfg  *Config
+}
+
+// Connect implements driver.Connector.
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.base.Connect(ctx)
+	if nil != err {
+		return nil, err
+	}
+
+	if 0 < c.cfg.DefaultStatementTimeout {
+		query, err := statementTimeoutSQL(c.cfg)
+		if nil == err {
+			err = connExec(ctx, conn, query)
+		}
+		if nil != err {
+			_ = conn.Close()
+			return nil, errors.Wrap(err, "unable to set the session statement timeout")
+		}
+	}
+
+	if nil != c.cfg.OnConnect {
+		if err = c.cfg.OnConnect(ctx, conn); nil != err {
+			_ = conn.Close()
+			return nil, errors.Wrap(err, "connection hook failed")
+		}
+	}
+
+	return conn, nil
+}
+
+// Driver implements driver.Connector.
+func (c *connector) Driver() driver.Driver {
+	return c.base.Driver()
+}
+
+// dsnConnector is a driver.Connector that opens connections using a DSN
+// string, the same way sql.Open does. If cfg is set the DSN is resolved for
+// each connection, see Config.PasswordFn, and drivers implementing
+// driver.DriverContext are given a new Connector for each resolved DSN.
+type dsnConnector struct {
+	cfg    *Config
+	driver driver.Driver
+	dsn    string
+}
+
+// dsnConnectorFor returns the driver.Connector opening connections for cfg.
+// Credentials from PasswordFn or SecretResolver are resolved for each new
+// connection, so rotated credentials are picked up as the pool grows. A static
+// DSN is handed to the driver's own Connector once if it implements
+// driver.DriverContext.
+func dsnConnectorFor(cfg *Config) (driver.Connector, error) {
+	if cfg.dynamicDSN() {
+		return &dsnConnector{cfg: cfg, driver: cfg.Driver}, nil
+	}
+	if driverCtx, ok := cfg.Driver.(driver.DriverContext); ok {
+		return driverCtx.OpenConnector(cfg.DSN())
+	}
+	return &dsnConnector{driver: cfg.Driver, dsn: cfg.DSN()}, nil
+}
+
+// Connect implements driver.Connector.
+func (c *dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	dsn := c.dsn
+	if nil != c.cfg {
+		var err error
+		if dsn, err = c.cfg.dsn(ctx); nil != err {
+			return nil, err
+		}
+	}
+	if driverCtx, ok := c.driver.(driver.DriverContext); ok {
+		conn, err := driverCtx.OpenConnector(dsn)
+		if nil != err {
+			return nil, err
+		}
+		return conn.Connect(ctx)
+	}
+	return c.driver.Open(dsn)
+}
+
+// Driver implements driver.Connector.
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// connExec executes a query directly on a driver connection.
+func connExec(ctx context.Context, conn driver.Conn, query string) error {
+	if execer, ok := conn.(driver.ExecerContext); ok {
+		_, err := execer.ExecContext(ctx, query, nil)
+		if driver.ErrSkip != err {
+			return err
+		}
+	}
+
+	stmt, err := conn.Prepare(query)
+	if nil != err {
+		return err
+	}
+	defer stmt.Close()
+
+	if execer, ok := stmt.(driver.StmtExecContext); ok {
+		_, err = execer.ExecContext(ctx, nil)
+		return err
+	}
+	_, err = stmt.Exec(nil)
+	return err
+}
+
+// statementTimeoutSQL returns the driver-appropriate statement used to set the
+// session statement timeout.
+func statementTimeoutSQL(cfg *Config) (string, error) {
+	ms := cfg.DefaultStatementTimeout.Milliseconds()
+	switch cfg.DriverType {
+	case "mysql":
+		return fmt.Sprintf("SET SESSION max_execution_time = %d", ms), nil
+	case "postgres":
+		return fmt.Sprintf("SET statement_timeout = %d", ms), nil
+	case "snowflake":
+		return fmt.Sprintf(
+			"ALTER SESSION SET STATEMENT_TIMEOUT_IN_SECONDS = %d",
+			int64(math.Ceil(cfg.DefaultStatementTimeout.Seconds())),
+		), nil
+	}
+	return "", errors.Errorf("session statement timeouts are not supported for driver type '%s'", cfg.DriverType)
+}
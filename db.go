@@ -12,10 +12,13 @@ Usage:
 		Host: "host",
 	})
 
-	// You can also ping the connection, useful for building auto-reconnect
-	// functionality.
+	// You can also ping the connection, useful for health checks.
 	err := db.Ping()
 
+	// Or monitor the connection in the background, reconnecting with
+	// exponential backoff when a ping fails.
+	db.StartReconnectMonitor(10*time.Second, BackoffConfig{BaseDelay: time.Second})
+
 	// Begin a new transaction and return a Statement type. Statements use named
 	// query parameters.
 	stmt, err := db.Prepare("...")
@@ -59,8 +62,10 @@ Usage:
 package db
 
 import (
+	"container/list"
 	"context"
 	"database/sql"
+	"sync"
 	"time"
 
 	"github.com/bdlm/errors/v2"
@@ -73,10 +78,54 @@ type DB struct {
 	// Database configuration
 	Cfg *Config
 
-	// Database connection
+	// Database connection. Replaced when reconnecting, guarded by poolMu.
 	Conn *sql.DB
 
 	Ctx context.Context
+
+	// Connection pool statistics captured when the connection was closed.
+	lastStats sql.DBStats
+
+	closeErr  error
+	closeOnce sync.Once
+
+	// Circuit breaker state, see Config.BreakerThreshold.
+	breakerFailures int
+	breakerMu       sync.Mutex
+	breakerOpened   time.Time
+	breakerProbe    time.Time
+
+	// Guards connecting when Config.LazyConnect or Config.KeepAliveInterval
+	// are set.
+	connMu sync.Mutex
+
+	// Guards replacing Conn, see pool.
+	poolMu sync.RWMutex
+
+	// Query metric instruments, see Config.MeterProvider.
+	metrics *queryMetrics
+
+	// Writes awaiting their transaction's commit, see Config.OnWrite.
+	pendingWrites   map[*sql.Tx][]pendingWrite
+	pendingWritesMu sync.Mutex
+
+	// Serializes writes to Config.RecordQueries.
+	recordMu sync.Mutex
+
+	// Cached read replica lag, see Config.MaxReplicaLag.
+	replicaChecked time.Time
+	replicaLagging bool
+	replicaMu      sync.Mutex
+
+	// Transactions being watched, see Config.OpenTxWarnAfter.
+	openTxs   map[*sql.Tx]*openTx
+	openTxsMu sync.Mutex
+
+	// Prepared statements keyed by query, most recently used first, see
+	// Config.StmtCacheSize.
+	stmtCache    map[string]*list.Element
+	stmtCacheLRU *list.List
+	stmtCacheMu  sync.Mutex
 }
 
 // New returns a new database connection instance.
@@ -85,8 +134,9 @@ type DB struct {
 // - Init config values as necessary.
 // - Begin a NewRelic transaction if applicable.
 // - Instrument the database driver.
-// - Initialize the database client and connect.
+// - Initialize the database client and connect (see Config.LazyConnect).
 // - Start a shutdown handler.
+// - Start a keepalive loop if applicable.
 func New(cfg *Config) (*DB, error) {
 	// Validate required configuration parameters.
 	if nil == cfg.Connector && nil == cfg.Driver {
@@ -101,6 +151,9 @@ func New(cfg *Config) (*DB, error) {
 	if "" == cfg.DriverName {
 		return nil, errors.New("a database driver name is required (*Config.DriverName)")
 	}
+	if 0 < cfg.MaxIdleConns && 0 < cfg.MaxOpenConns && cfg.MaxIdleConns > cfg.MaxOpenConns {
+		return nil, errors.Errorf("the maximum idle connections (%d) can't exceed the maximum open connections (%d) (*Config.MaxIdleConns, *Config.MaxOpenConns)", cfg.MaxIdleConns, cfg.MaxOpenConns)
+	}
 
 	// Init config values as necessary.
 	if nil == cfg.Loc {
@@ -123,7 +176,7 @@ func New(cfg *Config) (*DB, error) {
 	if nil == cfg.Driver {
 		cfg.Driver = cfg.Connector.Driver()
 	}
-	if nil != cfg.NewRelic {
+	if nil != cfg.NewRelic && !cfg.DisableInstrumentation {
 		cfg.Driver = InstrumentSQLDriver(cfg)
 		cfg.DriverName = cfg.DriverName + "-" + cfg.DatabaseName
 		sql.Register(cfg.DriverName, cfg.Driver)
@@ -134,9 +187,18 @@ func New(cfg *Config) (*DB, error) {
 		Cfg: cfg,
 		Ctx: cfg.Ctx,
 	}
-	err := db.Connect()
-	if nil != err {
-		return nil, errors.Wrap(err, "connect failed")
+	if nil != cfg.MeterProvider && !cfg.DisableInstrumentation {
+		metrics, err := newQueryMetrics(cfg)
+		if nil != err {
+			return nil, err
+		}
+		db.metrics = metrics
+	}
+	if !cfg.LazyConnect {
+		err := db.connectTimeout()
+		if nil != err {
+			return nil, errors.Wrap(err, "connect failed")
+		}
 	}
 
 	// Start a shutdown handler.
@@ -145,6 +207,16 @@ func New(cfg *Config) (*DB, error) {
 		db.Close()
 	}()
 
+	// Start the keepalive loop.
+	if 0 < cfg.KeepAliveInterval {
+		go db.keepAlive(cfg.KeepAliveInterval)
+	}
+
+	// Start the open transaction sweeper.
+	if 0 < cfg.OpenTxWarnAfter {
+		go db.sweepOpenTxs(cfg.OpenTxWarnAfter)
+	}
+
 	return db, nil
 }
 
@@ -152,20 +224,86 @@ func New(cfg *Config) (*DB, error) {
 //
 // Transaction instances handle multiple statements and can be committed or
 // rolled back. If a New Relic application has been provided, transaction
-// metrics will be written there.
+// metrics will be written there. If an OnBeginTx hook has been configured it
+// is run on the new transaction before it is returned. ErrCircuitOpen is
+// returned while the circuit breaker is open, see Config.BreakerThreshold.
+// Config.TxOptions is used if opts is nil.
 // https://golang.org/pkg/database/sql/#Conn.BeginTx
 func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
-	return db.Conn.BeginTx(ctx, opts)
+	if err := db.breakerAllow(); nil != err {
+		return nil, err
+	}
+	return db.beginTx(ctx, opts)
+}
+
+// beginTx begins a transaction like BeginTx for callers that have already
+// been let through by the circuit breaker.
+func (db *DB) beginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	if err := db.lazyConnect(); nil != err {
+		db.breakerRecord(err)
+		return nil, err
+	}
+
+	if nil == opts {
+		opts = db.Config().TxOptions
+	}
+	txn, err := db.pool().BeginTx(ctx, opts)
+	db.breakerRecord(err)
+	if nil != err {
+		return nil, err
+	}
+
+	if nil != db.Config().OnBeginTx {
+		if err = db.Config().OnBeginTx(ctx, txn); nil != err {
+			err = errors.Wrap(err, "transaction begin hook failed")
+			if err2 := txn.Rollback(); nil != err2 {
+				err = errors.WrapE(err, err2)
+			}
+			return nil, err
+		}
+	}
+
+	return txn, nil
 }
 
 // Close closes the database, releasing any open resources. It is rare to
 // Close a DB, as the DB handle is meant to be long-lived and shared
 // between many goroutines.
+//
+// Connection pool statistics are captured just before the connection is
+// closed and are available from LastStats. Connections still in use at
+// shutdown are logged. Subsequent calls return the result of the first.
 // https://golang.org/pkg/database/sql/#DB.Close
 func (db *DB) Close() error {
-	_ = db.Ping()
-	db.Cfg.Cancel()
-	return db.Conn.Close()
+	db.closeOnce.Do(func() {
+		db.connMu.Lock()
+		defer db.connMu.Unlock()
+
+		conn := db.pool()
+		if nil == conn {
+			db.Cfg.Cancel()
+			return
+		}
+
+		_ = db.Ping()
+		db.lastStats = conn.Stats()
+		db.Cfg.Cancel()
+
+		if 0 < db.lastStats.InUse {
+			log.WithFields(log.Fields{
+				"database":  db.Config().DatabaseName,
+				"idle":      db.lastStats.Idle,
+				"in_use":    db.lastStats.InUse,
+				"open":      db.lastStats.OpenConnections,
+				"wait":      db.lastStats.WaitCount,
+				"wait_time": db.lastStats.WaitDuration.String(),
+			}).Warn("database connections still in use at shutdown")
+		}
+
+		db.ClearStmtCache()
+		db.closeErr = conn.Close()
+	})
+	return db.closeErr
 }
 
 // Config returns the database configuration.
@@ -174,91 +312,322 @@ func (db *DB) Config() *Config {
 }
 
 // Connect opens a connection to the database with the provided credentials.
-// If a database connection exists it will be disconnected before trying to
-// reconnect.
+// If a database connection exists it is replaced once the new connection
+// pool has been opened and pinged, and then closed. If connecting fails the
+// existing connection is kept.
 func (db *DB) Connect() error {
+	return db.connect(db.Ctx)
+}
+
+// connect opens a connection to the database, see Connect. ctx bounds
+// resolving secrets and the initial ping.
+func (db *DB) connect(ctx context.Context) error {
 	if "" == db.Config().DriverName {
 		return errors.New("must provide a database driver name")
 	}
 
-	conn, err := sql.Open(db.Config().DriverName, db.Config().DSN())
+	base, err := dsnConnectorFor(db.Config())
 	if nil != err {
-		return errors.Wrap(err, "unable to open connection")
+		return errors.Wrap(err, "unable to open database connector")
+	}
+
+	var conn *sql.DB
+	if nil != db.Config().OnConnect || 0 < db.Config().DefaultStatementTimeout {
+		// Wrap the driver to run session setup on each new connection.
+		conn = sql.OpenDB(&connector{base: base, cfg: db.Config()})
+	} else {
+		conn = sql.OpenDB(base)
 	}
+	db.configurePool(conn)
+
+	if err = conn.PingContext(ctx); nil != err {
+		_ = conn.Close()
+		return err
+	}
+
+	db.poolMu.Lock()
+	old := db.Conn
 	db.Conn = conn
+	db.poolMu.Unlock()
 
-	return db.Ping()
+	// Statements cached on the old pool can't be used with the new one.
+	if nil != old {
+		db.ClearStmtCache()
+		_ = old.Close()
+	}
+	return nil
+}
+
+// reconnect replaces the connection pool, see Connect. Reconnects are
+// serialized so that concurrent callers don't each open a pool.
+func (db *DB) reconnect() error {
+	db.connMu.Lock()
+	defer db.connMu.Unlock()
+	return db.Connect()
+}
+
+// pool returns the current connection pool, or nil if not connected.
+func (db *DB) pool() *sql.DB {
+	db.poolMu.RLock()
+	defer db.poolMu.RUnlock()
+	return db.Conn
+}
+
+// configurePool applies the configured connection pool limits to the
+// connection. Limits that aren't set are left at the database/sql defaults.
+func (db *DB) configurePool(conn *sql.DB) {
+	cfg := db.Config()
+	if 0 < cfg.MaxOpenConns {
+		conn.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if 0 < cfg.MaxIdleConns {
+		conn.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if 0 < cfg.ConnMaxLifetime {
+		conn.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if 0 < cfg.ConnMaxIdleTime {
+		conn.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+}
+
+// connectTimeout connects to the database, giving up after
+// Config.ConnectTimeout if it's set. Drivers don't always honor context
+// deadlines while dialing, so the connection is made in the background and
+// closed if it completes after the timeout.
+func (db *DB) connectTimeout() error {
+	timeout := db.Config().ConnectTimeout
+	if 0 >= timeout {
+		return db.Connect()
+	}
+
+	ctx, cancel := context.WithTimeout(db.Ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		err := db.connect(ctx)
+		if conn := db.pool(); nil != ctx.Err() && nil != conn {
+			_ = conn.Close()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "timed out after %s connecting to '%s'", timeout, db.Config().host())
+	}
 }
 
 // Exec implements database/sql.Exec
 func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
-	return db.Conn.ExecContext(db.Ctx, query, args...)
+	return db.ExecContext(db.Ctx, query, args...)
 }
 
 // ExecContext implements database/sql.ExecContext
 func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
-	return db.Conn.ExecContext(ctx, query, args...)
+	target, query := db.route(query)
+	if nil != target && db != target {
+		return target.ExecContext(ctx, query, args...)
+	}
+	if err := db.breakerAllow(); nil != err {
+		return nil, err
+	}
+	if err := db.lazyConnect(); nil != err {
+		db.breakerRecord(err)
+		return nil, err
+	}
+
+	db.logQuery(ctx, query)
+	start := time.Now()
+	span := db.startSpan(ctx, "exec", query)
+	result, err := db.pool().ExecContext(ctx, query, args...)
+	db.endSpan(span, err)
+	db.observe(ctx, query, args, start, err)
+	db.breakerRecord(err)
+	if nil == err {
+		db.onWrite(nil, query, result)
+	}
+	return result, err
+}
+
+// LastStats returns the connection pool statistics captured by the most
+// recent call to Close.
+// https://golang.org/pkg/database/sql/#DBStats
+func (db *DB) LastStats() sql.DBStats {
+	return db.lastStats
 }
 
 // Ping verifies a connection to the database is still alive, establishing a
 // connection if necessary.
 func (db *DB) Ping() error {
-	if nil == db || nil == db.Conn {
+	if nil == db || nil == db.pool() {
+		return errors.New("no database connection")
+	}
+	return db.pool().PingContext(db.Ctx)
+}
+
+// PingTimeout is Ping bounded by a timeout, i.e. for health checks that must
+// fail fast when the network connection hangs. The timeout is applied to the
+// database context (Config.Ctx).
+func (db *DB) PingTimeout(d time.Duration) error {
+	if nil == db || nil == db.pool() {
 		return errors.New("no database connection")
 	}
-	return db.Conn.PingContext(db.Ctx)
+	ctx, cancel := context.WithTimeout(db.Ctx, d)
+	defer cancel()
+	if err := db.pool().PingContext(ctx); nil != err {
+		if nil != ctx.Err() {
+			return errors.Wrap(ctx.Err(), "ping timed out after %s", d)
+		}
+		return err
+	}
+	return nil
 }
 
 // Prepare is the constructor for Statement instances.
 //
-// Statement instances handle all transaction logic.
+// Statement instances handle all transaction logic. The statement runs in
+// the database context (Config.Ctx), so cancelling it cancels the
+// statement's transaction and any executions in flight. Use PrepareContext to
+// bind a statement to a request-scoped context or deadline.
 func (db *DB) Prepare(query string) (*Statement, error) {
-	return db.PrepareContext(context.Background(), query)
+	return db.PrepareContext(db.Ctx, query)
 }
 
 // PrepareContext is the constructor for Statement instances.
 //
-// Statement instances handle all transaction logic.
+// Statement instances handle all transaction logic. The statement isn't
+// prepared on the server if Config.DisableServerPrepare is set.
 func (db *DB) PrepareContext(ctx context.Context, query string) (*Statement, error) {
+	return db.PrepareTx(ctx, query, nil)
+}
+
+// PrepareReadOnly prepares a statement in a read-only transaction, so the
+// database rejects writes, i.e. for reporting queries. The isolation level
+// of Config.TxOptions is kept. Drivers without read-only transactions return
+// an error, see Config.TxOptions.
+func (db *DB) PrepareReadOnly(ctx context.Context, query string) (*Statement, error) {
+	opts := &sql.TxOptions{ReadOnly: true}
+	if nil != db.Config().TxOptions {
+		opts.Isolation = db.Config().TxOptions.Isolation
+	}
+	return db.PrepareTx(ctx, query, opts)
+}
+
+// PrepareTx is PrepareContext with the options the statement's transaction
+// is begun with, overriding Config.TxOptions, i.e. to run a statement with
+// sql.LevelSerializable isolation or in a read-only transaction. See
+// Config.TxOptions for the levels supported by each driver.
+func (db *DB) PrepareTx(ctx context.Context, query string, opts *sql.TxOptions) (*Statement, error) {
+	target, query := db.route(query)
+	if nil != target && db != target {
+		return target.PrepareTx(ctx, query, opts)
+	}
+	if err := db.breakerAllow(); nil != err {
+		return nil, err
+	}
+	if err := db.lazyConnect(); nil != err {
+		db.breakerRecord(err)
+		return nil, err
+	}
+
 	err := db.Ping()
 	if nil != err {
 		err = errors.Wrap(err, "ping failed")
-		err2 := db.Connect()
+		err2 := db.reconnect()
 		if nil != err2 {
-			return nil, errors.WrapE(err, err2)
+			err = errors.WrapE(err, err2)
+			db.breakerRecord(err)
+			return nil, err
 		}
 	}
 
-	var nrtxn *nr.Transaction
-	if nil != db.Config().NewRelic {
-		nrtxn = db.Config().NewRelic.StartTransaction(db.Config().DriverName)
-		ctx = nr.NewContext(ctx, nrtxn)
+	ctx, nrtxn := db.startNewRelic(ctx)
+
+	// Limit the transaction lifetime.
+	var cancel context.CancelFunc
+	if 0 < db.Config().MaxTxLifetime {
+		ctx, cancel = context.WithTimeoutCause(ctx, db.Config().MaxTxLifetime, ErrTxExpired)
 	}
 
-	txn, err := db.BeginTx(ctx, nil)
+	// Prepare cached statements before the transaction holds a connection.
+	var cached *sql.Stmt
+	if 0 < db.Config().StmtCacheSize && db.Config().serverPrepare() {
+		if cached, err = db.cachedStmt(ctx, query); nil != err {
+			if nil != cancel {
+				cancel()
+			}
+			return nil, errors.Wrap(err, "error preparing statement")
+		}
+	}
+
+	txn, err := db.beginTx(ctx, opts)
 	if nil != err {
+		if nil != cancel {
+			cancel()
+		}
 		return nil, errors.Wrap(err, "unable to initialize database transaction")
 	}
 
-	stmt, err := txn.PrepareContext(ctx, query)
-	if nil != err {
-		return nil, errors.Wrap(err, "error preparing statement")
+	var stmt *sql.Stmt
+	if nil != cached {
+		stmt = txn.StmtContext(ctx, cached)
+	} else if db.Config().serverPrepare() {
+		stmt, err = db.prepare(ctx, txn, query)
+		if nil != err {
+			if nil != cancel {
+				cancel()
+			}
+			return nil, errors.Wrap(err, "error preparing statement")
+		}
 	}
+	db.trackTx(ctx, txn)
 
 	return &Statement{
+		nil,
+		nil,
 		make([]sql.NamedArg, 0),
+		cancel,
 		ctx,
 		db,
+		false,
+		false,
 		nil,
 		nrtxn,
+		opts,
 		nil,
 		nil,
 		query,
 		stmt,
+		0,
+		nil,
+		nil,
 		txn,
 	}, nil
 }
 
+// Preparef is the constructor for Statement instances that formats the query
+// with identifiers first, so table and column names can be injected safely:
+//
+//	stmt, err := db.Preparef(ctx, "SELECT * FROM %i WHERE id = :id", table)
+//	stmt.Bind("id", id)
+//
+// Each `%i` verb is replaced by the next argument, which must be a string,
+// quoted with Config.QuoteIdentifier. `%%` produces a literal percent sign;
+// other verbs are rejected so values can't be formatted into the query and
+// must be bound instead. Percent signs within quoted strings are left
+// unchanged.
+func (db *DB) Preparef(ctx context.Context, format string, args ...interface{}) (*Statement, error) {
+	query, err := db.Config().formatIdentifiers(format, args)
+	if nil != err {
+		return nil, errors.Wrap(err, "unable to format query")
+	}
+	return db.PrepareContext(ctx, query)
+}
+
 // Query implements Tx.Query. Query executes a query that returns rows,
 // typically a SELECT.
 // https://golang.org/pkg/database/sql/#Tx.Query
@@ -270,41 +639,164 @@ func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
 // typically a SELECT.
 // https://golang.org/pkg/database/sql/#Tx.Query
 func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
-	var nrtxn *nr.Transaction
-	if nil != db.Config().NewRelic {
-		nrtxn = db.Config().NewRelic.StartTransaction(db.Config().DriverName)
-		ctx = nr.NewContext(ctx, nrtxn)
+	target, query := db.route(query)
+	if nil != target && db != target {
+		return target.QueryContext(ctx, query, args...)
 	}
+	ctx, _ = db.startNewRelic(ctx)
 
 	tx, err := db.BeginTx(ctx, nil)
 	if nil != err {
 		return nil, errors.Wrap(err, "unable to initialize database transaction")
 	}
 
-	return tx.QueryContext(ctx, query, args...)
+	db.logQuery(ctx, query)
+	start := time.Now()
+	span := db.startSpan(ctx, "query", query)
+	rows, err := tx.QueryContext(ctx, query, args...)
+	db.endSpan(span, err)
+	db.observe(ctx, query, args, start, err)
+	return rows, err
 }
 
 func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
 	return db.QueryRowContext(context.Background(), query, args...)
 }
 
-// QueryContext implemtnts Tx.Query. QueryRow executes a query that returns row,
-// typically a SELECT.
-// https://golang.org/pkg/database/sql/#Tx.Query
+// QueryRowContext executes a query that is expected to return at most one
+// row in a new transaction, see QueryRowContextE. If the transaction can't
+// be begun the error is logged and nil is returned, which panics at the
+// caller's Scan call. It's retained for compatibility; QueryRowContextE is
+// preferred.
+// https://golang.org/pkg/database/sql/#Tx.QueryRow
 func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
-	var nrtxn *nr.Transaction
-	if nil != db.Config().NewRelic {
-		nrtxn = db.Config().NewRelic.StartTransaction(db.Config().DriverName)
-		ctx = nr.NewContext(ctx, nrtxn)
+	row, err := db.QueryRowContextE(ctx, query, args...)
+	if nil != err {
+		log.WithError(err).Error("query failed")
+		return nil
+	}
+	return row
+}
+
+// QueryRowContextE executes a query that is expected to return at most one
+// row in a new transaction. Unlike QueryRowContext, an error is returned if
+// the transaction can't be begun. Query errors are deferred until the row's
+// Scan is called.
+// https://golang.org/pkg/database/sql/#Tx.QueryRow
+func (db *DB) QueryRowContextE(ctx context.Context, query string, args ...interface{}) (*sql.Row, error) {
+	target, query := db.route(query)
+	if nil != target && db != target {
+		return target.QueryRowContextE(ctx, query, args...)
 	}
+	ctx, _ = db.startNewRelic(ctx)
 
 	tx, err := db.BeginTx(ctx, nil)
 	if nil != err {
-		log.WithError(errors.Wrap(err, "unable to initialize database transaction")).Error("query failed")
+		return nil, errors.Wrap(err, "unable to initialize database transaction")
+	}
+
+	db.logQuery(ctx, query)
+	start := time.Now()
+	span := db.startSpan(ctx, "query", query)
+	row := tx.QueryRowContext(ctx, query, args...)
+	db.endSpan(span, row.Err())
+	db.observe(ctx, query, args, start, row.Err())
+	return row, nil
+}
+
+// lazyConnect connects to the database if no connection has been made yet,
+// as when Config.LazyConnect is set.
+func (db *DB) lazyConnect() error {
+	db.connMu.Lock()
+	defer db.connMu.Unlock()
+	if nil != db.pool() {
 		return nil
 	}
+	if err := db.Connect(); nil != err {
+		return errors.Wrap(err, "connect failed")
+	}
+	return nil
+}
 
-	return tx.QueryRowContext(ctx, query, args...)
+// logQuery logs the execution of a query at the debug level, tagged with the
+// request ID carried by the context (see Config.RequestIDKey). Nothing is
+// logged if the context carries no request ID.
+func (db *DB) logQuery(ctx context.Context, query string) {
+	id := db.Config().requestID(ctx)
+	if "" == id {
+		return
+	}
+	log.WithFields(log.Fields{
+		"database":   db.Config().DatabaseName,
+		"query":      query,
+		"request_id": id,
+	}).Debug("executing query")
+}
+
+// startNewRelic starts a New Relic transaction if a New Relic application has
+// been configured, returning a context carrying the transaction. The request
+// ID carried by the context, if any, is added as a custom attribute.
+func (db *DB) startNewRelic(ctx context.Context) (context.Context, *nr.Transaction) {
+	if nil == db.Config().NewRelic || db.Config().DisableInstrumentation {
+		return ctx, nil
+	}
+	nrtxn := db.Config().NewRelic.StartTransaction(db.Config().DriverName)
+	if id := db.Config().requestID(ctx); "" != id {
+		nrtxn.AddAttribute("request_id", id)
+	}
+	return nr.NewContext(ctx, nrtxn), nrtxn
+}
+
+// pendingWrite is a write reported to the OnWrite hook once its transaction
+// commits.
+type pendingWrite struct {
+	op           string
+	rowsAffected int64
+	table        string
+}
+
+// onWrite reports insert, update, and delete statements to the OnWrite hook,
+// if any. Writes executed in txn are held until it commits, see endWrites;
+// writes executed outside a transaction are reported immediately.
+func (db *DB) onWrite(txn *sql.Tx, query string, result sql.Result) {
+	if nil == db.Config().OnWrite {
+		return
+	}
+	op, table := ParseStatement(query)
+	switch op {
+	case "insert", "update", "delete":
+	default:
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if nil != err {
+		rowsAffected = -1
+	}
+	if nil == txn {
+		db.Config().OnWrite(table, op, rowsAffected)
+		return
+	}
+	db.pendingWritesMu.Lock()
+	defer db.pendingWritesMu.Unlock()
+	if nil == db.pendingWrites {
+		db.pendingWrites = map[*sql.Tx][]pendingWrite{}
+	}
+	db.pendingWrites[txn] = append(db.pendingWrites[txn], pendingWrite{op, rowsAffected, table})
+}
+
+// endWrites reports the writes held for a transaction to the OnWrite hook if
+// it committed, and discards them otherwise.
+func (db *DB) endWrites(txn *sql.Tx, committed bool) {
+	db.pendingWritesMu.Lock()
+	writes := db.pendingWrites[txn]
+	delete(db.pendingWrites, txn)
+	db.pendingWritesMu.Unlock()
+	if !committed {
+		return
+	}
+	for _, write := range writes {
+		db.Config().OnWrite(write.table, write.op, write.rowsAffected)
+	}
 }
 
 var (
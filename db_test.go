@@ -0,0 +1,392 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bdlm/db"
+	"github.com/bdlm/errors/v2"
+	newrelic "github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestOnBeginTx tests the transaction begin hook.
+func TestOnBeginTx(t *testing.T) {
+	var hookErr error
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.OnBeginTx = func(ctx context.Context, tx *sql.Tx) error {
+			if nil != hookErr {
+				return hookErr
+			}
+			_, err := tx.ExecContext(ctx, "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE")
+			return err
+		}
+	})
+
+	// the hook runs on the fresh transaction before the statement is prepared
+	stmt, err := database.Prepare("SELECT 1")
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Close())
+	assert.Equal(t, []string{
+		"begin",
+		"exec: SET TRANSACTION ISOLATION LEVEL SERIALIZABLE",
+		"prepare: SELECT 1",
+		"rollback",
+	}, stub.Log())
+
+	// a hook error rolls back the transaction and aborts preparation
+	hookErr = fmt.Errorf("hook failed")
+	stmt, err = database.Prepare("SELECT 2")
+	assert.Error(t, err)
+	assert.Nil(t, stmt)
+	assert.True(t, errors.Is(err, hookErr))
+	assert.Equal(t, []string{"begin", "rollback"}, stub.Log()[4:])
+}
+
+// TestDefaultStatementTimeout tests that the session statement timeout is set
+// on every new pooled connection.
+func TestDefaultStatementTimeout(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "postgres"
+		cfg.DefaultStatementTimeout = 1500 * time.Millisecond
+	})
+
+	// hold the first connection open in a transaction to force a second
+	// connection to be opened
+	stmt, err := database.Prepare("SELECT 1")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = database.Exec("UPDATE users SET active = 1")
+	assert.NoError(t, err)
+
+	timeouts := 0
+	for _, entry := range stub.Log() {
+		if "exec: SET statement_timeout = 1500" == entry {
+			timeouts++
+		}
+	}
+	assert.Equal(t, 2, stub.Opens())
+	assert.Equal(t, 2, timeouts)
+
+	// unsupported driver types fail to connect
+	_, err = db.New(&db.Config{
+		Ctx:                     context.Background(),
+		DatabaseName:            "unsupported",
+		Driver:                  stub,
+		DriverName:              database.Config().DriverName,
+		DriverType:              "oracle",
+		DefaultStatementTimeout: time.Second,
+	})
+	assert.Error(t, err)
+}
+
+// TestLastStats tests capturing connection pool statistics on Close.
+func TestLastStats(t *testing.T) {
+	database, _ := newStubDB(t)
+
+	stmt1, err := database.Prepare("SELECT 1")
+	assert.NoError(t, err)
+	defer stmt1.Close()
+	stmt2, err := database.Prepare("SELECT 2")
+	assert.NoError(t, err)
+	defer stmt2.Close()
+
+	assert.NoError(t, database.Close())
+	assert.Equal(t, 2, database.LastStats().InUse)
+
+	// closing again doesn't replace the snapshot
+	assert.NoError(t, database.Close())
+	assert.Equal(t, 2, database.LastStats().InUse)
+}
+
+// TestStats tests reading the current connection pool statistics.
+func TestStats(t *testing.T) {
+	// not connected
+	database, _ := newStubDB(t, func(cfg *db.Config) {
+		cfg.LazyConnect = true
+	})
+	assert.Equal(t, sql.DBStats{}, database.Stats())
+	assert.Equal(t, db.PoolStats{}, database.StatsSnapshot())
+
+	database, _ = newStubDB(t)
+	stmt1, err := database.Prepare("SELECT 1")
+	assert.NoError(t, err)
+	defer stmt1.Close()
+	stmt2, err := database.Prepare("SELECT 2")
+	assert.NoError(t, err)
+	assert.NoError(t, stmt2.Close())
+
+	assert.Equal(t, 2, database.Stats().OpenConnections)
+	snapshot := database.StatsSnapshot()
+	assert.Equal(t, 2, snapshot.Open)
+	assert.Equal(t, 1, snapshot.InUse)
+	assert.Equal(t, 1, snapshot.Idle)
+}
+
+type requestIDKey struct{}
+
+// TestRequestID tests tagging executed queries with a context-carried request
+// ID.
+func TestRequestID(t *testing.T) {
+	app, collector := newNewRelicApp(t)
+	database, _ := newStubDB(t, func(cfg *db.Config) {
+		cfg.NewRelic = app
+		cfg.RequestIDKey = requestIDKey{}
+	})
+	logs := captureLogs(t)
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req-1234")
+	stmt, err := database.PrepareContext(ctx, "SELECT 1")
+	assert.NoError(t, err)
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Close())
+
+	_, err = database.Exec("SELECT 2")
+	assert.NoError(t, err)
+
+	entries := logs.Entries("executing query")
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "req-1234", entries[0].Data["request_id"])
+	assert.Equal(t, "SELECT 1", entries[0].Data["query"])
+
+	// the request ID is added to the New Relic transaction
+	app.Shutdown(time.Second)
+	assert.Equal(t, []map[string]interface{}{{"request_id": "req-1234"}}, collector.Attributes())
+}
+
+// TestDisableInstrumentation tests that no instrumentation is set up when
+// Config.DisableInstrumentation is set.
+func TestDisableInstrumentation(t *testing.T) {
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName("db-test"),
+		newrelic.ConfigEnabled(false),
+	)
+	assert.NoError(t, err)
+	recorder := &spanRecorder{}
+	var txns []*newrelic.Transaction
+	var driverName string
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.NewRelic = app
+		cfg.Tracer = recorder
+		cfg.DisableInstrumentation = true
+		cfg.OnBeginTx = func(ctx context.Context, tx *sql.Tx) error {
+			txns = append(txns, newrelic.FromContext(ctx))
+			return nil
+		}
+		driverName = cfg.DriverName
+	})
+
+	// the driver isn't wrapped and registered under a new name
+	assert.Equal(t, driverName, database.Config().DriverName)
+	assert.Equal(t, stub, database.Config().Driver)
+
+	stmt, err := database.Prepare("SELECT 1")
+	assert.NoError(t, err)
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Close())
+
+	// no New Relic transactions or spans are started
+	assert.Equal(t, []*newrelic.Transaction{nil}, txns)
+	assert.Empty(t, recorder.spans)
+}
+
+// TestLazyConnect tests deferring the database connection until first use.
+func TestLazyConnect(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.Driver.(*stubDriver).OpenErr = fmt.Errorf("connection refused")
+		cfg.LazyConnect = true
+	})
+	assert.Nil(t, database.Conn)
+
+	// the first query connects, failed connections aren't kept
+	_, err := database.Exec("DELETE FROM sessions")
+	assert.Error(t, err)
+	assert.Nil(t, database.Conn)
+	assert.Equal(t, 0, stub.Opens())
+
+	// the database became available
+	stub.mu.Lock()
+	stub.OpenErr = nil
+	stub.mu.Unlock()
+	stmt, err := database.Prepare("SELECT 1")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	assert.Equal(t, 1, stub.Opens())
+}
+
+// TestKeepAlive tests reconnecting from the keepalive loop when pings fail.
+func TestKeepAlive(t *testing.T) {
+	logs := captureLogs(t)
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.KeepAliveInterval = 5 * time.Millisecond
+	})
+	opens := stub.Opens()
+	old := database.Conn
+
+	// the keepalive ping fails, reconnecting succeeds
+	stub.mu.Lock()
+	stub.PingErr = fmt.Errorf("server closed the connection unexpectedly")
+	stub.PingFails = 1
+	stub.mu.Unlock()
+
+	// the replaced connection pool is closed
+	deadline := time.Now().Add(time.Second)
+	for 0 < old.Stats().OpenConnections && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.EqualError(t, old.Ping(), "sql: database is closed")
+	assert.Greater(t, stub.Opens(), opens)
+	assert.NotEmpty(t, logs.Entries("database keepalive ping failed, reconnecting"))
+	assert.NotSame(t, old, database.Conn)
+	assert.NoError(t, database.Ping())
+	assert.NoError(t, database.Close())
+}
+
+// TestPingTimeout tests bounding pings with a timeout.
+func TestPingTimeout(t *testing.T) {
+	database, stub := newStubDB(t)
+	assert.NoError(t, database.PingTimeout(time.Second))
+
+	stub.mu.Lock()
+	stub.PingBlock = make(chan struct{})
+	stub.mu.Unlock()
+	defer close(stub.PingBlock)
+
+	start := time.Now()
+	err := database.PingTimeout(50 * time.Millisecond)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+// TestQueryRowContextE tests that begin failures are returned rather than
+// a nil row.
+func TestQueryRowContextE(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows([]string{"count"}, []driver.Value{int64(3)}), nil
+	}
+
+	row, err := database.QueryRowContextE(context.Background(), "SELECT count(*) FROM users")
+	assert.NoError(t, err)
+	var count int64
+	assert.NoError(t, row.Scan(&count))
+	assert.Equal(t, int64(3), count)
+
+	// closed connection
+	assert.NoError(t, database.Close())
+	row, err = database.QueryRowContextE(context.Background(), "SELECT count(*) FROM users")
+	assert.Nil(t, row)
+	assert.EqualError(t, err, "unable to initialize database transaction")
+	assert.Nil(t, database.QueryRowContext(context.Background(), "SELECT count(*) FROM users"))
+}
+
+// TestConnectTimeout tests bounding the initial connection made by New.
+func TestConnectTimeout(t *testing.T) {
+	stub := &stubDriver{OpenBlock: make(chan struct{})}
+	defer close(stub.OpenBlock)
+	name := fmt.Sprintf("stub-%d", atomic.AddInt64(&stubCount, 1))
+	sql.Register(name, stub)
+
+	start := time.Now()
+	database, err := db.New(&db.Config{
+		ConnectTimeout: 50 * time.Millisecond,
+		Ctx:            context.Background(),
+		DatabaseName:   name,
+		DSNData:        map[string]string{"host": "db.example.com", "pass": "secret"},
+		Driver:         stub,
+		DriverName:     name,
+		DriverType:     "postgres",
+	})
+	assert.Nil(t, database)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Contains(t, fmt.Sprintf("%+v", err), "db.example.com")
+	assert.NotContains(t, fmt.Sprintf("%+v", err), "secret")
+}
+
+// TestDriverContext tests opening connections through the driver's Connector.
+func TestDriverContext(t *testing.T) {
+	var stub *stubContextDriver
+	database, _ := newStubDB(t, func(cfg *db.Config) {
+		stub = &stubContextDriver{stubDriver: cfg.Driver.(*stubDriver)}
+		cfg.Driver = stub
+		cfg.DriverType = "oracle"
+		cfg.DSNData = map[string]string{"user": "appuser", "pass": "secret", "host": "hostname"}
+	})
+	tx1, err := database.BeginTx(context.Background(), nil)
+	assert.NoError(t, err)
+	defer tx1.Rollback()
+	tx2, err := database.BeginTx(context.Background(), nil)
+	assert.NoError(t, err)
+	defer tx2.Rollback()
+
+	// a static DSN opens a single connector
+	assert.Equal(t, []string{"appuser/secret@hostname"}, stub.Connectors())
+	assert.Equal(t, []string{"appuser/secret@hostname", "appuser/secret@hostname"}, stub.DSNs())
+
+	// resolved credentials open a connector for each connection
+	passwords := []string{"first", "rotated"}
+	calls := 0
+	database, _ = newStubDB(t, func(cfg *db.Config) {
+		stub = &stubContextDriver{stubDriver: cfg.Driver.(*stubDriver)}
+		cfg.Driver = stub
+		cfg.DriverType = "oracle"
+		cfg.DSNData = map[string]string{"user": "appuser", "host": "hostname"}
+		cfg.PasswordFn = func(ctx context.Context) (string, error) {
+			calls++
+			return passwords[calls-1], nil
+		}
+	})
+	tx1, err = database.BeginTx(context.Background(), nil)
+	assert.NoError(t, err)
+	defer tx1.Rollback()
+	tx2, err = database.BeginTx(context.Background(), nil)
+	assert.NoError(t, err)
+	defer tx2.Rollback()
+	assert.Equal(t, []string{"appuser/first@hostname", "appuser/rotated@hostname"}, stub.Connectors())
+	assert.Equal(t, []string{"appuser/first@hostname", "appuser/rotated@hostname"}, stub.DSNs())
+}
+
+// TestPoolLimits tests applying the configured connection pool limits.
+func TestPoolLimits(t *testing.T) {
+	database, _ := newStubDB(t, func(cfg *db.Config) {
+		cfg.ConnMaxIdleTime = time.Minute
+		cfg.ConnMaxLifetime = time.Hour
+		cfg.MaxIdleConns = 2
+		cfg.MaxOpenConns = 4
+	})
+	assert.Equal(t, 4, database.Conn.Stats().MaxOpenConnections)
+
+	// closed connections beyond the idle limit are discarded
+	stmts := []*db.Statement{}
+	for a := 0; a < 4; a++ {
+		stmt, err := database.Prepare("SELECT 1")
+		assert.NoError(t, err)
+		stmts = append(stmts, stmt)
+	}
+	assert.Equal(t, 4, database.Conn.Stats().InUse)
+	for _, stmt := range stmts {
+		assert.NoError(t, stmt.Close())
+	}
+	assert.Equal(t, 2, database.Conn.Stats().Idle)
+
+	// more idle connections than open connections are rejected
+	_, err := db.New(&db.Config{
+		Ctx:          context.Background(),
+		DatabaseName: "invalid",
+		Driver:       database.Config().Driver,
+		DriverName:   database.Config().DriverName,
+		MaxIdleConns: 5,
+		MaxOpenConns: 4,
+	})
+	assert.Error(t, err)
+}
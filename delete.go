@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bdlm/errors/v2"
+)
+
+// deleteFromRegex matches the table and the remaining `WHERE ...` clause of a
+// `DELETE FROM` statement.
+var deleteFromRegex = regexp.MustCompile(`(?is)^delete\s+from\s+(\[[^\]]*\]|"[^"]*"|\S+)(.*?)[\s;]*$`)
+
+// DeleteReturning executes the prepared DELETE statement with any arguments
+// that have been added using Bind() calls and returns the values of
+// idColumn in the deleted rows, i.e. to invalidate cached keys after a bulk
+// delete. How the values are fetched depends on the DriverType:
+//
+//   - "cockroach", "postgres", "sqlite": the statement is executed with
+//     `RETURNING idColumn` appended.
+//   - "sqlserver": the statement is executed with an `OUTPUT
+//     DELETED.idColumn` clause after the table name.
+//   - "mysql", "oracle": the rows are selected and locked in the statement's
+//     transaction first, with `SELECT idColumn FROM ... FOR UPDATE` using the
+//     statement's FROM and WHERE clauses, then deleted.
+//   - Others, i.e. "snowflake": the rows are selected the same way but
+//     without a lock, which these databases don't support for queries, so
+//     rows changed by concurrent transactions between the two statements may
+//     be deleted without being returned, or returned without being deleted.
+//
+// The statement must be a single-table `DELETE FROM` statement. []byte
+// values are returned as strings. The rewritten statements are executed
+// directly in the statement's transaction rather than with the prepared
+// statement.
+func (statement *Statement) DeleteReturning(ctx context.Context, idColumn string, args ...interface{}) ([]interface{}, error) {
+	from := deleteFromRegex.FindStringSubmatch(cleanQuery(statement.sql))
+	if nil == from {
+		statement.binds = []sql.NamedArg{}
+		statement.lastErr = errors.Errorf("DeleteReturning requires a DELETE FROM statement, '%s' given", statement.sql)
+		return nil, statement.lastErr
+	}
+	table, where := from[1], from[2]
+
+	binds, err := statement.bindArgs(args)
+	statement.binds = []sql.NamedArg{}
+	if nil != err {
+		statement.lastErr = err
+		return nil, statement.lastErr
+	}
+
+	cfg := statement.db.Config()
+	column := cfg.FoldIdentifier(idColumn)
+	var query string
+	switch cfg.DriverType {
+	case "cockroach", "postgres", "sqlite":
+		query = strings.TrimRight(strings.TrimSpace(statement.sql), ";") + " RETURNING " + column
+	case "sqlserver":
+		query = "DELETE FROM " + table + " OUTPUT DELETED." + column + where
+	}
+	if "" != query {
+		ids, err := statement.queryIDs(ctx, query, binds)
+		if nil != err {
+			statement.lastErr = errors.Wrap(err, "unable to delete rows")
+			return nil, statement.lastErr
+		}
+		statement.db.onWrite(statement.txn, query, driver.RowsAffected(len(ids)))
+		if nil != statement.tx {
+			statement.tx.addRowsAffected(driver.RowsAffected(len(ids)))
+		}
+		return ids, nil
+	}
+
+	query = "SELECT " + column + " FROM " + table + where
+	if "mysql" == cfg.DriverType || "oracle" == cfg.DriverType {
+		query += " FOR UPDATE"
+	}
+	ids, err := statement.queryIDs(ctx, query, binds)
+	if nil != err {
+		statement.lastErr = errors.Wrap(err, "unable to select rows to delete")
+		return nil, statement.lastErr
+	}
+	if _, err := statement.execTxn(ctx, cfg.driverSQL(statement.sql), binds...); nil != err {
+		statement.lastErr = errors.Wrap(err, "unable to delete rows")
+		return nil, statement.lastErr
+	}
+	return ids, nil
+}
+
+// queryIDs runs a query returning a single column in the statement's
+// transaction and returns its values.
+func (statement *Statement) queryIDs(ctx context.Context, query string, binds []interface{}) ([]interface{}, error) {
+	statement.db.logQuery(ctx, query)
+	start := time.Now()
+	span := statement.db.startSpan(ctx, "query", query)
+	rows, err := statement.txn.QueryContext(ctx, statement.db.Config().driverSQL(query), binds...)
+	statement.db.endSpan(span, err)
+	statement.db.observe(ctx, query, binds, start, err)
+	statement.db.breakerRecord(err)
+	if nil != err {
+		return nil, statement.expired(err)
+	}
+	defer rows.Close()
+
+	ids := []interface{}{}
+	for rows.Next() {
+		var id interface{}
+		if err := rows.Scan(&id); nil != err {
+			return nil, err
+		}
+		if data, ok := id.([]byte); ok {
+			id = string(data)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
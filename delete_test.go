@@ -0,0 +1,102 @@
+package db_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDeleteReturning tests fetching the ids of deleted rows for each driver
+// type.
+func TestDeleteReturning(t *testing.T) {
+	del := "DELETE FROM sessions WHERE user_id = :user_id;"
+
+	// postgres appends a RETURNING clause
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "postgres"
+	})
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows([]string{"id"}, []driver.Value{int64(3)}, []driver.Value{int64(5)}), nil
+	}
+	stmt, err := database.Prepare(del)
+	assert.NoError(t, err)
+	defer stmt.Close()
+	ids, err := stmt.Bind("user_id", 1).DeleteReturning(context.Background(), "id")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int64(3), int64(5)}, ids)
+	assert.Equal(t, []string{
+		"begin",
+		"prepare: " + del,
+		"query: DELETE FROM sessions WHERE user_id = :user_id RETURNING id",
+	}, stub.Log())
+
+	// mysql selects and locks the rows before deleting them
+	database, stub = newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "mysql"
+	})
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		assert.EqualValues(t, 1, args[0].Value)
+		return newStubRows([]string{"id"}, []driver.Value{[]byte("a1")}, []driver.Value{[]byte("b2")}), nil
+	}
+	stmt, err = database.Prepare(del)
+	assert.NoError(t, err)
+	defer stmt.Close()
+	ids, err = stmt.Bind("user_id", 1).DeleteReturning(context.Background(), "id")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"a1", "b2"}, ids)
+	assert.Equal(t, []string{
+		"begin",
+		"prepare: " + del,
+		"query: SELECT id FROM sessions WHERE user_id = :user_id FOR UPDATE",
+		"exec: " + del,
+	}, stub.Log())
+
+	// sqlserver outputs the deleted rows
+	database, stub = newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "sqlserver"
+	})
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows([]string{"id"}, []driver.Value{int64(7)}), nil
+	}
+	stmt, err = database.Prepare(del)
+	assert.NoError(t, err)
+	defer stmt.Close()
+	ids, err = stmt.Bind("user_id", 1).DeleteReturning(context.Background(), "id")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int64(7)}, ids)
+	assert.Equal(t, []string{
+		"begin",
+		"prepare: " + del,
+		"query: DELETE FROM sessions OUTPUT DELETED.id WHERE user_id = :user_id",
+	}, stub.Log())
+
+	// snowflake selects the rows without a lock
+	database, stub = newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "snowflake"
+	})
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows([]string{"id"}, []driver.Value{int64(7)}), nil
+	}
+	stmt, err = database.Prepare(del)
+	assert.NoError(t, err)
+	defer stmt.Close()
+	ids, err = stmt.Bind("user_id", 1).DeleteReturning(context.Background(), "id")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int64(7)}, ids)
+	assert.Equal(t, []string{
+		"begin",
+		"prepare: " + del,
+		"query: SELECT id FROM sessions WHERE user_id = :user_id",
+		"exec: " + del,
+	}, stub.Log())
+
+	// only DELETE FROM statements are supported
+	stmt, err = database.Prepare("UPDATE sessions SET expired = 1")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.DeleteReturning(context.Background(), "id")
+	assert.EqualError(t, err, "DeleteReturning requires a DELETE FROM statement, 'UPDATE sessions SET expired = 1' given")
+}
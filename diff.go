@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bdlm/errors/v2"
+)
+
+// DiffResults executes two prepared statements and compares their results,
+// such as the same query against a source and a target database. Rows are
+// matched by the values of the key columns. Rows only returned by b are
+// added, rows only returned by a are removed, and rows returned by both with
+// different values in any other column are changed; changed rows hold the
+// values from b. Rows are read with MapScan and returned in the order they
+// were read.
+//
+// Values are compared by their string form after the same normalization as
+// WriteCSV, so `1` and `"1"` are equal, []byte values compare as strings, and
+// time.Time values compare as RFC3339. Columns returned by only one of the
+// statements are compared as NULL.
+func DiffResults(ctx context.Context, a, b *Statement, keyCols []string) (added, removed, changed []map[string]interface{}, err error) {
+	if 0 == len(keyCols) {
+		return nil, nil, nil, errors.New("at least one key column is required")
+	}
+
+	rowsA, keysA, err := diffRows(ctx, a, keyCols)
+	if nil != err {
+		return nil, nil, nil, err
+	}
+	rowsB, keysB, err := diffRows(ctx, b, keyCols)
+	if nil != err {
+		return nil, nil, nil, err
+	}
+
+	indexA := make(map[string]map[string]interface{}, len(rowsA))
+	for i, row := range rowsA {
+		indexA[keysA[i]] = row
+	}
+	indexB := make(map[string]bool, len(rowsB))
+
+	added = []map[string]interface{}{}
+	changed = []map[string]interface{}{}
+	for i, row := range rowsB {
+		indexB[keysB[i]] = true
+		prev, ok := indexA[keysB[i]]
+		if !ok {
+			added = append(added, row)
+		} else if !diffEqual(prev, row) {
+			changed = append(changed, row)
+		}
+	}
+
+	removed = []map[string]interface{}{}
+	for i, row := range rowsA {
+		if !indexB[keysA[i]] {
+			removed = append(removed, row)
+		}
+	}
+
+	return added, removed, changed, nil
+}
+
+// diffRows executes a statement for DiffResults and returns its rows along
+// with the key of each row.
+func diffRows(ctx context.Context, stmt *Statement, keyCols []string) ([]map[string]interface{}, []string, error) {
+	rows, err := stmt.QueryContext(ctx)
+	if nil != err {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	columns, err := stmt.exportColumns()
+	if nil != err {
+		return nil, nil, err
+	}
+	for _, key := range keyCols {
+		found := false
+		for _, column := range columns {
+			found = found || key == column
+		}
+		if !found {
+			return nil, nil, errors.Errorf("key column '%s' not found in results of '%s'", key, stmt.sql)
+		}
+	}
+
+	results := []map[string]interface{}{}
+	keys := []string{}
+	for rows.Next() {
+		row := map[string]interface{}{}
+		if err = stmt.MapScan(row); nil != err {
+			stmt.lastErr = err
+			return nil, nil, err
+		}
+		key := make([]string, len(keyCols))
+		for a, column := range keyCols {
+			if key[a], err = diffValue(row[column]); nil != err {
+				return nil, nil, err
+			}
+		}
+		results = append(results, row)
+		keys = append(keys, strings.Join(key, "\x00"))
+	}
+	if err = stmt.Err(); nil != err {
+		return nil, nil, err
+	}
+	return results, keys, nil
+}
+
+// diffEqual reports whether two rows hold the same values.
+func diffEqual(a, b map[string]interface{}) bool {
+	for _, row := range []map[string]interface{}{a, b} {
+		for column := range row {
+			valueA, errA := diffValue(a[column])
+			valueB, errB := diffValue(b[column])
+			if nil != errA || nil != errB || valueA != valueB {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// diffValue returns the comparable string form of a value, distinguishing
+// NULL from an empty string.
+func diffValue(value interface{}) (string, error) {
+	value, err := exportValue(value)
+	if nil != err {
+		return "", err
+	}
+	if nil == value {
+		return "\x00NULL", nil
+	}
+	return fmt.Sprint(value), nil
+}
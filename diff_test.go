@@ -0,0 +1,54 @@
+package db_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDiffResults tests comparing the results of two statements.
+func TestDiffResults(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		switch query {
+		case "SELECT id, name, balance FROM source":
+			return newStubRows(
+				[]string{"id", "name", "balance"},
+				[]driver.Value{int64(1), "alice", int64(100)},
+				[]driver.Value{int64(2), "bob", int64(200)},
+				[]driver.Value{int64(3), "carol", nil},
+			), nil
+		case "SELECT id, name, balance FROM target":
+			return newStubRows(
+				[]string{"id", "name", "balance"},
+				[]driver.Value{[]byte("1"), []byte("alice"), int64(100)},
+				[]driver.Value{int64(3), "carol", int64(0)},
+				[]driver.Value{int64(4), "dave", int64(400)},
+			), nil
+		}
+		return newStubRows([]string{"name"}), nil
+	}
+
+	source, err := database.Prepare("SELECT id, name, balance FROM source")
+	assert.NoError(t, err)
+	defer source.Close()
+	target, err := database.Prepare("SELECT id, name, balance FROM target")
+	assert.NoError(t, err)
+	defer target.Close()
+
+	added, removed, changed, err := db.DiffResults(context.Background(), source, target, []string{"id"})
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{"id": int64(4), "name": "dave", "balance": int64(400)}}, added)
+	assert.Equal(t, []map[string]interface{}{{"id": int64(2), "name": "bob", "balance": int64(200)}}, removed)
+	assert.Equal(t, []map[string]interface{}{{"id": int64(3), "name": "carol", "balance": int64(0)}}, changed)
+
+	// missing key columns
+	other, err := database.Prepare("SELECT name FROM other")
+	assert.NoError(t, err)
+	defer other.Close()
+	_, _, _, err = db.DiffResults(context.Background(), source, other, []string{"id"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bdlm/errors/v2"
+)
+
+// QueryJSON executes the statement with any arguments that have been added
+// using Bind() calls and writes the results to w as a JSON array of objects,
+// see WriteJSON, i.e. to proxy a query result to an HTTP client. Rows are
+// written as they're read. If a row can't be read the array is left
+// unterminated and the error is returned.
+func (statement *Statement) QueryJSON(w io.Writer, args ...interface{}) error {
+	if _, err := statement.Query(args...); nil != err {
+		return err
+	}
+	return statement.WriteJSON(w)
+}
+
+// QueryCSV executes the statement with any arguments that have been added
+// using Bind() calls and writes the results to w as CSV, see WriteCSV, i.e.
+// for finance exports. Rows are written as they're read.
+func (statement *Statement) QueryCSV(w io.Writer, args ...interface{}) error {
+	if _, err := statement.Query(args...); nil != err {
+		return err
+	}
+	return statement.WriteCSV(w)
+}
+
+// WriteCSV writes the remaining rows of the current result cursor to w as
+// CSV, preceded by a header row of column names unless Config.CSVNoHeader is
+// set. Rows are read using MapScan,
+// so values in Config.MaskColumns columns are masked. Values are formatted
+// deterministically: NULL as an empty string, []byte as a string, and
+// time.Time as RFC3339. Rows are written as they're read rather than buffered.
+func (statement *Statement) WriteCSV(w io.Writer) error {
+	columns, err := statement.exportColumns()
+	if nil != err {
+		return err
+	}
+	defer statement.rows.Close()
+
+	writer := csv.NewWriter(w)
+	if !statement.db.Config().CSVNoHeader {
+		if err = writer.Write(columns); nil != err {
+			return errors.Wrap(err, "failed to write CSV header")
+		}
+	}
+
+	record := make([]string, len(columns))
+	for statement.rows.Next() {
+		row := map[string]interface{}{}
+		if err = statement.MapScan(row); nil != err {
+			statement.lastErr = err
+			return err
+		}
+		for a, column := range columns {
+			value, err := exportValue(row[column])
+			if nil != err {
+				statement.lastErr = err
+				return err
+			}
+			record[a] = csvValue(value)
+		}
+		if err = writer.Write(record); nil != err {
+			return errors.Wrap(err, "failed to write CSV record")
+		}
+	}
+
+	writer.Flush()
+	if err = writer.Error(); nil != err {
+		return errors.Wrap(err, "failed to write CSV record")
+	}
+	return statement.Err()
+}
+
+// WriteJSON writes the remaining rows of the current result cursor to w as a
+// JSON array of objects keyed by column name, in column order. Rows are read
+// using MapScan, so values in Config.MaskColumns columns are masked. []byte
+// values are written as strings and time.Time values as RFC3339. Rows are
+// written as they're read rather than buffered.
+func (statement *Statement) WriteJSON(w io.Writer) error {
+	columns, err := statement.exportColumns()
+	if nil != err {
+		return err
+	}
+	defer statement.rows.Close()
+
+	keys := make([][]byte, len(columns))
+	for a, column := range columns {
+		if keys[a], err = json.Marshal(column); nil != err {
+			return errors.Wrap(err, "failed to encode column name")
+		}
+	}
+
+	if _, err = io.WriteString(w, "["); nil != err {
+		return err
+	}
+	for count := 0; statement.rows.Next(); count++ {
+		row := map[string]interface{}{}
+		if err = statement.MapScan(row); nil != err {
+			statement.lastErr = err
+			return err
+		}
+
+		buf := []byte("{")
+		if 0 < count {
+			buf = []byte(",{")
+		}
+		for a, column := range columns {
+			value, err := exportValue(row[column])
+			if nil != err {
+				statement.lastErr = err
+				return err
+			}
+			encoded, err := json.Marshal(value)
+			if nil != err {
+				statement.lastErr = errors.Wrap(err, "failed to encode column '%s'", column)
+				return statement.lastErr
+			}
+			if 0 < a {
+				buf = append(buf, ',')
+			}
+			buf = append(append(append(buf, keys[a]...), ':'), encoded...)
+		}
+		if _, err = w.Write(append(buf, '}')); nil != err {
+			return err
+		}
+	}
+	if err = statement.Err(); nil != err {
+		return err
+	}
+	_, err = io.WriteString(w, "]")
+	return err
+}
+
+// exportColumns returns the MapScan keys of the current result cursor's
+// columns.
+func (statement *Statement) exportColumns() ([]string, error) {
+	if nil == statement.rows {
+		statement.lastErr = errors.Errorf("no cursor found. did you remember to run `statement.Query()`?")
+		return nil, statement.lastErr
+	}
+	columns, err := statement.rows.Columns()
+	if nil != err {
+		statement.lastErr = errors.Wrap(err, "failed to list result columns")
+		return nil, statement.lastErr
+	}
+	keys, err := statement.db.Config().columnKeys(columns)
+	if nil != err {
+		statement.lastErr = err
+		return nil, statement.lastErr
+	}
+	return keys, nil
+}
+
+// exportValue normalizes a MapScan value for export.
+func exportValue(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case []byte:
+		return string(value), nil
+	case time.Time:
+		return value.Format(time.RFC3339), nil
+	case io.Reader:
+		data, err := io.ReadAll(value)
+		if nil != err {
+			return nil, errors.Wrap(err, "failed to read LOB value")
+		}
+		return string(data), nil
+	}
+	return value, nil
+}
+
+// csvValue formats an exported value as a CSV field.
+func csvValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,132 @@
+package db_test
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExportMaskColumns tests that masked columns are masked in CSV and JSON
+// exports while other columns are intact.
+func TestExportMaskColumns(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.MaskColumns = []string{"SSN"}
+	})
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"id", "name", "ssn", "created"},
+			[]driver.Value{int64(1), []byte("alice"), "123-45-6789", created},
+			[]driver.Value{int64(2), "bob", nil, nil},
+		), nil
+	}
+
+	stmt, err := database.Prepare("SELECT id, name, ssn, created FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	// CSV
+	buf := &bytes.Buffer{}
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.WriteCSV(buf))
+	assert.Equal(t, "id,name,ssn,created\n1,alice,****,2024-03-01T12:30:00Z\n2,bob,****,\n", buf.String())
+
+	// JSON
+	buf.Reset()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.WriteJSON(buf))
+	assert.Equal(t, `[{"id":1,"name":"alice","ssn":"****","created":"2024-03-01T12:30:00Z"},{"id":2,"name":"bob","ssn":"****","created":null}]`, buf.String())
+
+	// Scan still reads the real values
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+	var id int64
+	var name, ssn string
+	var createdAt time.Time
+	assert.True(t, stmt.Next(&id, &name, &ssn, &createdAt))
+	assert.Equal(t, "123-45-6789", ssn)
+}
+
+// TestQueryJSON tests streaming query results as JSON.
+func TestQueryJSON(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.ScanTransform = func(column string, value interface{}) (interface{}, error) {
+			if "name" == column && "mallory" == value {
+				return nil, fmt.Errorf("unable to decrypt")
+			}
+			return value, nil
+		}
+	})
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		switch args[0].Value {
+		case "active":
+			return newStubRows(
+				[]string{"id", "name", "created"},
+				[]driver.Value{int64(1), []byte("alice"), created},
+				[]driver.Value{int64(2), "bob", nil},
+			), nil
+		case "locked":
+			return newStubRows(
+				[]string{"id", "name", "created"},
+				[]driver.Value{int64(3), "carol", nil},
+				[]driver.Value{int64(4), "mallory", nil},
+			), nil
+		}
+		return newStubRows([]string{"id", "name", "created"}), nil
+	}
+
+	stmt, err := database.Prepare("SELECT id, name, created FROM users WHERE status = :status")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, stmt.Bind("status", "active").QueryJSON(buf))
+	assert.Equal(t, `[{"id":1,"name":"alice","created":"2024-03-01T12:30:00Z"},{"id":2,"name":"bob","created":null}]`, buf.String())
+
+	// empty results
+	buf.Reset()
+	assert.NoError(t, stmt.Bind("status", "inactive").QueryJSON(buf))
+	assert.Equal(t, `[]`, buf.String())
+
+	// scan errors abort the stream
+	buf.Reset()
+	err = stmt.Bind("status", "locked").QueryJSON(buf)
+	assert.Error(t, err)
+	assert.Equal(t, err, stmt.LastErr())
+	assert.Equal(t, `[{"id":3,"name":"carol","created":null}`, buf.String())
+}
+
+// TestQueryCSV tests streaming query results as CSV.
+func TestQueryCSV(t *testing.T) {
+	for _, noHeader := range []bool{false, true} {
+		database, stub := newStubDB(t, func(cfg *db.Config) {
+			cfg.CSVNoHeader = noHeader
+		})
+		stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+			return newStubRows(
+				[]string{"id", "memo", "posted"},
+				[]driver.Value{int64(1), []byte("rent, march"), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+				[]driver.Value{int64(2), nil, nil},
+			), nil
+		}
+
+		stmt, err := database.Prepare("SELECT id, memo, posted FROM ledger WHERE account = :account")
+		assert.NoError(t, err)
+		buf := &bytes.Buffer{}
+		assert.NoError(t, stmt.Bind("account", 42).QueryCSV(buf))
+		expect := "1,\"rent, march\",2024-03-01T00:00:00Z\n2,,\n"
+		if !noHeader {
+			expect = "id,memo,posted\n" + expect
+		}
+		assert.Equal(t, expect, buf.String())
+		stmt.Close()
+	}
+}
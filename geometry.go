@@ -0,0 +1,93 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+
+	"github.com/bdlm/errors/v2"
+)
+
+// GeometryScanner is implemented by scan destinations for geometry and
+// spatial columns, such as PostGIS `geometry` or Oracle `SDO_GEOMETRY`
+// columns. Scan, Next, and StructScan pass the column's well-known binary
+// (WKB) representation to ScanGeometry rather than converting it; hex encoded
+// WKB, as returned by PostGIS in text mode, is decoded first. NULL values
+// leave the destination unchanged. Geometry columns are detected by a driver
+// database type name containing "GEOMETRY" or "GEOGRAPHY".
+//
+// The data refers to memory owned by the driver and is only valid until
+// ScanGeometry returns.
+type GeometryScanner interface {
+	ScanGeometry(wkb []byte) error
+}
+
+// WKB is a minimal GeometryScanner holding a copy of a geometry value in
+// well-known binary format.
+type WKB []byte
+
+// ScanGeometry implements GeometryScanner.
+func (wkb *WKB) ScanGeometry(data []byte) error {
+	*wkb = append((*wkb)[:0], data...)
+	return nil
+}
+
+// Type returns the WKB geometry type code, i.e. 1 for a point or 3 for a
+// polygon, or 0 if the value is too short to hold a header. Extended WKB
+// flags such as the SRID flag are removed.
+func (wkb WKB) Type() uint32 {
+	if len(wkb) < 5 {
+		return 0
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if 1 == wkb[0] {
+		order = binary.LittleEndian
+	}
+	return order.Uint32(wkb[1:5]) & 0x0fffffff
+}
+
+// geometryScanner passes the WKB value of a geometry column to a
+// GeometryScanner.
+type geometryScanner struct {
+	dest GeometryScanner
+}
+
+// Scan implements sql.Scanner.
+func (scanner *geometryScanner) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		if isHexWKB(src) {
+			return scanner.Scan(string(src))
+		}
+		return scanner.dest.ScanGeometry(src)
+	case string:
+		data, err := hex.DecodeString(src)
+		if nil != err {
+			return errors.Wrap(err, "cannot decode geometry value")
+		}
+		return scanner.dest.ScanGeometry(data)
+	}
+	return errors.Errorf("cannot scan %T into a geometry", src)
+}
+
+// isHexWKB reports whether a value is hex encoded WKB, which starts with the
+// hex encoded byte order marker "00" or "01".
+func isHexWKB(data []byte) bool {
+	if len(data) < 10 || 0 != len(data)%2 || '0' != data[0] || ('0' != data[1] && '1' != data[1]) {
+		return false
+	}
+	for _, c := range data {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// isGeometryType reports whether a database type name is a geometry type.
+func isGeometryType(name string) bool {
+	name = strings.ToUpper(name)
+	return strings.Contains(name, "GEOMETRY") || strings.Contains(name, "GEOGRAPHY")
+}
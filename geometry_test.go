@@ -0,0 +1,48 @@
+package db_test
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"testing"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestScanGeometry tests passing the WKB value of geometry columns to
+// GeometryScanner destinations.
+func TestScanGeometry(t *testing.T) {
+	// POINT(1 2), little endian
+	point, _ := hex.DecodeString("0101000000000000000000f03f0000000000000040")
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"id", "location", "boundary"},
+			[]driver.Value{int64(1), point, "0101000000000000000000f03f0000000000000040"},
+		).WithTypes("INT8", "geometry", "SDO_GEOMETRY"), nil
+	}
+
+	stmt, err := database.Prepare("SELECT id, location, boundary FROM places")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+
+	var id int64
+	var location, boundary db.WKB
+	assert.True(t, stmt.Next(&id, &location, &boundary))
+	assert.Equal(t, db.WKB(point), location)
+	assert.Equal(t, db.WKB(point), boundary)
+	assert.Equal(t, uint32(1), location.Type())
+
+	// struct fields
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+	var place struct {
+		ID       int64  `db:"id"`
+		Location db.WKB `db:"location"`
+	}
+	assert.True(t, stmt.Rows().Next())
+	assert.NoError(t, stmt.StructScan(&place))
+	assert.Equal(t, db.WKB(point), place.Location)
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/bdlm/errors/v2"
+)
+
+// IdentifierFolding selects the case folding applied to generated SQL
+// identifiers.
+type IdentifierFolding int
+
+const (
+	// FoldNone emits identifiers as given.
+	FoldNone IdentifierFolding = iota
+
+	// FoldUpper emits unquoted identifiers in uppercase, as Oracle folds
+	// them.
+	FoldUpper
+
+	// FoldLower emits unquoted identifiers in lowercase, as postgres folds
+	// them.
+	FoldLower
+)
+
+// FoldIdentifier applies the configured FoldIdentifiers case folding to an
+// identifier. Each part of a qualified name such as `schema.table` is folded
+// separately, and quoted parts are left unchanged because the database
+// doesn't fold them either.
+func (cfg *Config) FoldIdentifier(name string) string {
+	if FoldNone == cfg.FoldIdentifiers {
+		return name
+	}
+
+	parts := strings.Split(name, ".")
+	for a, part := range parts {
+		if strings.HasPrefix(part, `"`) || strings.HasPrefix(part, "`") || strings.HasPrefix(part, "[") {
+			continue
+		}
+		switch cfg.FoldIdentifiers {
+		case FoldUpper:
+			parts[a] = strings.ToUpper(part)
+		case FoldLower:
+			parts[a] = strings.ToLower(part)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// QuoteIdentifier quotes an identifier for safe use in a query, i.e. a table
+// or column name from user input. Each part of a qualified name such as
+// `schema.table` is quoted separately, with backticks for the "mysql"
+// DriverType and double quotes otherwise; embedded quote characters are
+// doubled. Parts are folded according to FoldIdentifiers first, because
+// quoted identifiers are case-sensitive.
+func (cfg *Config) QuoteIdentifier(name string) string {
+	quote := `"`
+	if "mysql" == cfg.DriverType {
+		quote = "`"
+	}
+
+	parts := strings.Split(name, ".")
+	for a, part := range parts {
+		parts[a] = quote + strings.ReplaceAll(cfg.FoldIdentifier(part), quote, quote+quote) + quote
+	}
+	return strings.Join(parts, ".")
+}
+
+// formatIdentifiers replaces the `%i` verbs in a query with the quoted
+// identifiers in args, see DB.Preparef. `%%` produces a literal percent sign.
+// Verbs within quoted strings are left unchanged.
+func (cfg *Config) formatIdentifiers(format string, args []interface{}) (string, error) {
+	var out strings.Builder
+	next := 0
+	for a := 0; a < len(format); a++ {
+		c := format[a]
+		switch {
+		case '\'' == c:
+			end := strings.IndexByte(format[a+1:], c)
+			if end < 0 {
+				out.WriteString(format[a:])
+				a = len(format)
+				continue
+			}
+			out.WriteString(format[a : a+end+2])
+			a += end + 1
+		case '%' == c && a+1 < len(format) && '%' == format[a+1]:
+			out.WriteByte('%')
+			a++
+		case '%' == c && a+1 < len(format) && 'i' == format[a+1]:
+			if next >= len(args) {
+				return "", errors.Errorf("missing identifier for %%i verb %d", next+1)
+			}
+			name, ok := args[next].(string)
+			if !ok || "" == name {
+				return "", errors.Errorf("identifier %d must be a non-empty string, %T given", next+1, args[next])
+			}
+			out.WriteString(cfg.QuoteIdentifier(name))
+			next++
+			a++
+		case '%' == c:
+			return "", errors.Errorf("unsupported verb at offset %d, only %%i and %%%% are supported", a)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	if next < len(args) {
+		return "", errors.Errorf("%d identifiers given for %d %%i verbs", len(args), next)
+	}
+	return out.String(), nil
+}
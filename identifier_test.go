@@ -0,0 +1,75 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFoldIdentifier tests folding generated identifiers per the configured
+// setting.
+func TestFoldIdentifier(t *testing.T) {
+	tests := []struct {
+		fold   db.IdentifierFolding
+		name   string
+		expect string
+	}{
+		{db.FoldNone, "UserName", "UserName"},
+		{db.FoldUpper, "UserName", "USERNAME"},
+		{db.FoldLower, "UserName", "username"},
+		{db.FoldUpper, "app.UserAccounts", "APP.USERACCOUNTS"},
+		{db.FoldLower, `App."UserAccounts"`, `app."UserAccounts"`},
+		{db.FoldUpper, "`MixedCase`", "`MixedCase`"},
+	}
+
+	for _, test := range tests {
+		cfg := &db.Config{FoldIdentifiers: test.fold}
+		assert.Equal(t, test.expect, cfg.FoldIdentifier(test.name))
+	}
+}
+
+// TestQuoteIdentifier tests quoting identifiers per driver type.
+func TestQuoteIdentifier(t *testing.T) {
+	tests := []struct {
+		driverType string
+		fold       db.IdentifierFolding
+		name       string
+		expect     string
+	}{
+		{"postgres", db.FoldNone, "users", `"users"`},
+		{"postgres", db.FoldNone, `app.user"s`, `"app"."user""s"`},
+		{"oracle", db.FoldUpper, "app.users", `"APP"."USERS"`},
+		{"mysql", db.FoldNone, "user`s", "`user``s`"},
+	}
+
+	for _, test := range tests {
+		cfg := &db.Config{DriverType: test.driverType, FoldIdentifiers: test.fold}
+		assert.Equal(t, test.expect, cfg.QuoteIdentifier(test.name))
+	}
+}
+
+// TestPreparef tests formatting quoted identifiers into prepared queries.
+func TestPreparef(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "postgres"
+	})
+
+	stmt, err := database.Preparef(context.Background(), "SELECT * FROM %i WHERE id = :id AND name LIKE 'a%' AND pct = 100%%", "users; DROP TABLE users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	assert.Equal(t, `prepare: SELECT * FROM "users; DROP TABLE users" WHERE id = :id AND name LIKE 'a%' AND pct = 100%`, stub.Log()[1])
+
+	// values can't be formatted into the query
+	_, err = database.Preparef(context.Background(), "SELECT * FROM users WHERE id = %d", 1)
+	assert.Error(t, err)
+
+	// identifiers must match the verbs
+	_, err = database.Preparef(context.Background(), "SELECT * FROM %i")
+	assert.Error(t, err)
+	_, err = database.Preparef(context.Background(), "SELECT * FROM %i", "users", "extra")
+	assert.Error(t, err)
+	_, err = database.Preparef(context.Background(), "SELECT * FROM %i", 1)
+	assert.Error(t, err)
+}
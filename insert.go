@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"time"
+
+	"github.com/bdlm/errors/v2"
+)
+
+// InsertReturningID executes the prepared INSERT statement with any arguments
+// that have been added using Bind() calls and returns the generated primary
+// key from idColumn. How the key is fetched depends on the DriverType:
+//
+//   - "postgres": the statement is executed with `RETURNING idColumn`
+//     appended.
+//   - "oracle": the statement is executed with `RETURNING idColumn INTO
+//     :insert_id` appended, binding an output parameter.
+//   - Others, i.e. "mysql": the statement is executed as is and the key is
+//     read from sql.Result.LastInsertId.
+//
+// The rewritten statements are executed directly in the statement's
+// transaction rather than with the prepared statement.
+func (statement *Statement) InsertReturningID(ctx context.Context, idColumn string, args ...interface{}) (int64, error) {
+	driverType := statement.db.Config().DriverType
+	if "oracle" != driverType && "postgres" != driverType {
+		result, err := statement.ExecContext(ctx, args...)
+		if nil != err {
+			return 0, err
+		}
+		id, err := result.LastInsertId()
+		if nil != err {
+			statement.lastErr = errors.Wrap(err, "unable to read the generated id")
+			return 0, statement.lastErr
+		}
+		return id, nil
+	}
+
+	binds, err := statement.bindArgs(args)
+	statement.binds = []sql.NamedArg{}
+	if nil != err {
+		statement.lastErr = err
+		return 0, statement.lastErr
+	}
+
+	var id int64
+	query := strings.TrimRight(strings.TrimSpace(statement.sql), ";")
+	column := statement.db.Config().FoldIdentifier(idColumn)
+
+	if "oracle" == driverType {
+		query += " RETURNING " + column + " INTO :insert_id"
+		binds = append(binds, sql.Named("insert_id", sql.Out{Dest: &id}))
+		if _, err := statement.execTxn(ctx, query, binds...); nil != err {
+			statement.lastErr = errors.Wrap(err, "unable to insert row")
+			return 0, statement.lastErr
+		}
+		return id, nil
+	}
+
+	query += " RETURNING " + column
+	statement.db.logQuery(ctx, query)
+	start := time.Now()
+	span := statement.db.startSpan(ctx, "query", query)
+	err = statement.txn.QueryRowContext(ctx, statement.db.Config().driverSQL(query), binds...).Scan(&id)
+	statement.db.endSpan(span, err)
+	statement.db.observe(ctx, query, binds, start, err)
+	statement.db.breakerRecord(err)
+	if nil != err {
+		statement.lastErr = errors.Wrap(statement.expired(err), "unable to insert row")
+		return 0, statement.lastErr
+	}
+	statement.db.onWrite(statement.txn, query, driver.RowsAffected(1))
+	if nil != statement.tx {
+		statement.tx.addRowsAffected(driver.RowsAffected(1))
+	}
+	return id, nil
+}
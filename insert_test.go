@@ -0,0 +1,68 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestInsertReturningID tests fetching generated primary keys for each
+// driver type.
+func TestInsertReturningID(t *testing.T) {
+	insert := "INSERT INTO users (name) VALUES (:name);"
+
+	// mysql reads the id from the result
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "mysql"
+	})
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		return stubResult{lastInsertID: 7, rowsAffected: 1}, nil
+	}
+	stmt, err := database.Prepare(insert)
+	assert.NoError(t, err)
+	defer stmt.Close()
+	id, err := stmt.Bind("name", "alice").InsertReturningID(context.Background(), "id")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), id)
+	assert.Equal(t, "exec: "+insert, stub.Log()[2])
+
+	// postgres appends a RETURNING clause
+	database, stub = newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "postgres"
+	})
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows([]string{"id"}, []driver.Value{int64(8)}), nil
+	}
+	stmt, err = database.Prepare(insert)
+	assert.NoError(t, err)
+	defer stmt.Close()
+	id, err = stmt.Bind("name", "bob").InsertReturningID(context.Background(), "id")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), id)
+	assert.Equal(t, "query: INSERT INTO users (name) VALUES (:name) RETURNING id", stub.Log()[2])
+
+	// oracle returns the id into an output parameter
+	database, stub = newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "oracle"
+		cfg.FoldIdentifiers = db.FoldUpper
+	})
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		for _, arg := range args {
+			if out, ok := arg.Value.(sql.Out); ok && "insert_id" == arg.Name {
+				*out.Dest.(*int64) = 9
+			}
+		}
+		return driver.RowsAffected(1), nil
+	}
+	stmt, err = database.Prepare(insert)
+	assert.NoError(t, err)
+	defer stmt.Close()
+	id, err = stmt.Bind("name", "carol").InsertReturningID(context.Background(), "id")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9), id)
+	assert.Equal(t, "exec: INSERT INTO users (name) VALUES (:name) RETURNING ID INTO :insert_id", stub.Log()[2])
+}
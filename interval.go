@@ -0,0 +1,163 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bdlm/errors/v2"
+)
+
+// durationScanner populates a time.Duration from an interval column, see
+// parseInterval. Integer values are nanoseconds, as database/sql would
+// store them. NULL values leave the destination unchanged.
+type durationScanner struct {
+	dest       *time.Duration
+	driverType string
+}
+
+// Scan implements sql.Scanner.
+func (scanner *durationScanner) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case time.Duration:
+		*scanner.dest = src
+	case int64:
+		*scanner.dest = time.Duration(src)
+	case []byte:
+		return scanner.Scan(string(src))
+	case string:
+		d, err := parseInterval(scanner.driverType, src)
+		if nil != err {
+			return err
+		}
+		*scanner.dest = d
+	default:
+		return errors.Errorf("cannot scan %T into *time.Duration", src)
+	}
+	return nil
+}
+
+// parseInterval parses the text representation of an interval returned by
+// the database into a duration:
+//
+//   - "cockroach", "postgres": the default `postgres` IntervalStyle, i.e.
+//     "1 day 02:03:04" or "-3 days +00:00:01.5". Intervals with years or
+//     months don't have a fixed length and aren't supported.
+//   - "oracle": INTERVAL DAY TO SECOND, i.e. "+01 02:03:04.000000".
+//
+// For other driver types each format is tried, followed by Go duration
+// strings, i.e. "26h3m4s".
+func parseInterval(driverType, interval string) (time.Duration, error) {
+	switch driverType {
+	case "cockroach", "postgres":
+		return parsePostgresInterval(interval)
+	case "oracle":
+		return parseOracleInterval(interval)
+	}
+	if d, err := parsePostgresInterval(interval); nil == err {
+		return d, nil
+	}
+	if d, err := parseOracleInterval(interval); nil == err {
+		return d, nil
+	}
+	if d, err := time.ParseDuration(interval); nil == err {
+		return d, nil
+	}
+	return 0, errors.Errorf("cannot parse interval %q", interval)
+}
+
+// parsePostgresInterval parses an interval in the `postgres` IntervalStyle,
+// i.e. "1 day 02:03:04".
+func parsePostgresInterval(interval string) (time.Duration, error) {
+	fields := strings.Fields(interval)
+	if 0 == len(fields) {
+		return 0, errors.Errorf("cannot parse interval %q", interval)
+	}
+
+	var d time.Duration
+	for a := 0; a < len(fields); a++ {
+		if strings.Contains(fields[a], ":") {
+			clock, err := parseClock(fields[a])
+			if nil != err {
+				return 0, errors.Wrap(err, "cannot parse interval %q", interval)
+			}
+			d += clock
+			continue
+		}
+
+		n, err := strconv.ParseInt(fields[a], 10, 64)
+		if nil != err || a+1 == len(fields) {
+			return 0, errors.Errorf("cannot parse interval %q", interval)
+		}
+		a++
+		switch strings.TrimSuffix(fields[a], "s") {
+		case "day":
+			d += time.Duration(n) * 24 * time.Hour
+		case "year", "mon":
+			return 0, errors.Errorf("cannot convert interval %q with years or months to a duration", interval)
+		default:
+			return 0, errors.Errorf("cannot parse interval %q", interval)
+		}
+	}
+	return d, nil
+}
+
+// parseOracleInterval parses an Oracle INTERVAL DAY TO SECOND value, i.e.
+// "+01 02:03:04.000000". The sign applies to the whole interval.
+func parseOracleInterval(interval string) (time.Duration, error) {
+	fields := strings.Fields(interval)
+	if 2 != len(fields) {
+		return 0, errors.Errorf("cannot parse interval %q", interval)
+	}
+	days, err := strconv.ParseInt(strings.TrimLeft(fields[0], "+-"), 10, 64)
+	if nil != err {
+		return 0, errors.Errorf("cannot parse interval %q", interval)
+	}
+	clock, err := parseClock(fields[1])
+	if nil != err || strings.HasPrefix(fields[1], "-") {
+		return 0, errors.Errorf("cannot parse interval %q", interval)
+	}
+
+	d := time.Duration(days)*24*time.Hour + clock
+	if strings.HasPrefix(fields[0], "-") {
+		d = -d
+	}
+	return d, nil
+}
+
+// parseClock parses a signed "hh:mm:ss[.fraction]" or "hh:mm" time of an
+// interval. Hours may exceed 24.
+func parseClock(clock string) (time.Duration, error) {
+	neg := strings.HasPrefix(clock, "-")
+	parts := strings.Split(strings.TrimLeft(clock, "+-"), ":")
+	if 2 != len(parts) && 3 != len(parts) {
+		return 0, errors.Errorf("invalid interval time %q", clock)
+	}
+
+	hours, err := strconv.ParseUint(parts[0], 10, 32)
+	if nil != err {
+		return 0, errors.Errorf("invalid interval time %q", clock)
+	}
+	minutes, err := strconv.ParseUint(parts[1], 10, 8)
+	if nil != err || 59 < minutes {
+		return 0, errors.Errorf("invalid interval time %q", clock)
+	}
+	d := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+	if 3 == len(parts) {
+		if "" == parts[2] || "" != strings.Trim(parts[2], "0123456789.") || 1 < strings.Count(parts[2], ".") {
+			return 0, errors.Errorf("invalid interval time %q", clock)
+		}
+		seconds, err := time.ParseDuration(parts[2] + "s")
+		if nil != err || 60 <= seconds.Seconds() {
+			return 0, errors.Errorf("invalid interval time %q", clock)
+		}
+		d += seconds
+	}
+
+	if neg {
+		d = -d
+	}
+	return d, nil
+}
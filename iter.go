@@ -0,0 +1,48 @@
+//go:build go1.23
+
+package db
+
+import (
+	"iter"
+)
+
+// Iter executes the prepared statement and returns an iterator over the
+// result rows, each scanned into a T using StructScan:
+//
+//	for user, err := range db.Iter[User](stmt) {
+//		if nil != err {
+//			...
+//		}
+//		...
+//	}
+//
+// An error executing the query, scanning a row, or iterating the cursor is
+// yielded once with the zero value of T, after which iteration stops. The
+// cursor is closed when iteration completes, fails, or the loop exits early.
+// https://pkg.go.dev/iter#Seq2
+func Iter[T any](stmt *Statement, args ...interface{}) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+
+		rows, err := stmt.Query(args...)
+		if nil != err {
+			yield(zero, err)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var dest T
+			if err := stmt.StructScan(&dest); nil != err {
+				yield(zero, err)
+				return
+			}
+			if !yield(dest, nil) {
+				return
+			}
+		}
+		if err := stmt.Err(); nil != err {
+			yield(zero, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+//go:build go1.23
+
+package db_test
+
+import (
+	"database/sql/driver"
+	"testing"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIter tests ranging over typed result rows.
+func TestIter(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"id", "name"},
+			[]driver.Value{int64(1), "alice"},
+			[]driver.Value{int64(2), "bob"},
+			[]driver.Value{int64(3), "carol"},
+		), nil
+	}
+
+	// all rows
+	stmt, err := database.Prepare("SELECT id, name FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	users := []user{}
+	for row, err := range db.Iter[user](stmt) {
+		assert.NoError(t, err)
+		users = append(users, row)
+	}
+	assert.Equal(t, []user{{1, "alice"}, {2, "bob"}, {3, "carol"}}, users)
+
+	// early exit closes the cursor
+	stmt, err = database.Prepare("SELECT id, name FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	users = []user{}
+	for row, err := range db.Iter[user](stmt) {
+		assert.NoError(t, err)
+		users = append(users, row)
+		break
+	}
+	assert.Equal(t, []user{{1, "alice"}}, users)
+	var id int64
+	var name string
+	assert.Error(t, stmt.Rows().Scan(&id, &name))
+	assert.False(t, stmt.Rows().Next())
+
+	// scan errors are yielded
+	stmt, err = database.Prepare("SELECT id, name FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	count := 0
+	for _, err := range db.Iter[int64](stmt) {
+		assert.Error(t, err)
+		count++
+	}
+	assert.Equal(t, 1, count)
+}
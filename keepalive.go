@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/bdlm/log/v2"
+)
+
+// keepAlive pings the database every interval until the database is closed,
+// reconnecting when a ping fails. See Config.KeepAliveInterval.
+func (db *DB) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.Ctx.Done():
+			return
+		case <-ticker.C:
+			db.keepAlivePing(interval)
+		}
+	}
+}
+
+// keepAlivePing pings the database, giving up after timeout, and reconnects
+// if the ping fails.
+func (db *DB) keepAlivePing(timeout time.Duration) {
+	// Closed, or not yet connected with Config.LazyConnect.
+	conn := db.pool()
+	if nil != db.Ctx.Err() || nil == conn {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(db.Ctx, timeout)
+	err := conn.PingContext(ctx)
+	cancel()
+	if nil == err {
+		return
+	}
+	log.WithError(err).WithFields(log.Fields{
+		"database": db.Config().DatabaseName,
+	}).Warn("database keepalive ping failed, reconnecting")
+
+	if err = db.reconnect(); nil != err {
+		log.WithError(err).WithFields(log.Fields{
+			"database": db.Config().DatabaseName,
+		}).Error("database reconnect failed")
+	}
+}
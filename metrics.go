@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/bdlm/errors/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// queryMetrics records query metrics with OpenTelemetry instruments, see
+// Config.MeterProvider.
+type queryMetrics struct {
+	// Base attributes recorded with every measurement.
+	attrs []attribute.KeyValue
+
+	// Query counter, by operation and table.
+	count metric.Int64Counter
+
+	// Query duration histogram in seconds, by operation and table.
+	duration metric.Float64Histogram
+
+	// Failed query counter, by operation and table.
+	errors metric.Int64Counter
+
+	// Statement preparation duration histogram in seconds, by operation and
+	// table.
+	prepare metric.Float64Histogram
+}
+
+// newQueryMetrics creates the query metric instruments from the configured
+// meter provider.
+// https://pkg.go.dev/go.opentelemetry.io/otel/metric#MeterProvider
+func newQueryMetrics(cfg *Config) (*queryMetrics, error) {
+	var err error
+	meter := cfg.MeterProvider.Meter(pkgPath)
+	metrics := &queryMetrics{
+		attrs: []attribute.KeyValue{
+			attribute.String("db.system", cfg.DriverType),
+			attribute.String("db.name", cfg.DatabaseName),
+		},
+	}
+
+	metrics.count, err = meter.Int64Counter(
+		"db.client.queries",
+		metric.WithDescription("Number of queries executed."),
+		metric.WithUnit("{query}"),
+	)
+	if nil != err {
+		return nil, errors.Wrap(err, "unable to create query counter")
+	}
+
+	metrics.duration, err = meter.Float64Histogram(
+		"db.client.query.duration",
+		metric.WithDescription("Duration of query execution."),
+		metric.WithUnit("s"),
+	)
+	if nil != err {
+		return nil, errors.Wrap(err, "unable to create query duration histogram")
+	}
+
+	metrics.errors, err = meter.Int64Counter(
+		"db.client.query.errors",
+		metric.WithDescription("Number of queries that returned an error."),
+		metric.WithUnit("{query}"),
+	)
+	if nil != err {
+		return nil, errors.Wrap(err, "unable to create query error counter")
+	}
+
+	metrics.prepare, err = meter.Float64Histogram(
+		"db.client.prepare.duration",
+		metric.WithDescription("Duration of statement preparation on the server."),
+		metric.WithUnit("s"),
+	)
+	if nil != err {
+		return nil, errors.Wrap(err, "unable to create prepare duration histogram")
+	}
+
+	return metrics, nil
+}
+
+// attributes returns the measurement attributes for a query.
+func (metrics *queryMetrics) attributes(operation, table string) metric.MeasurementOption {
+	return metric.WithAttributes(append(
+		metrics.attrs,
+		attribute.String("db.operation", operation),
+		attribute.String("db.sql.table", table),
+	)...)
+}
+
+// record records the metrics for a query execution. Query text isn't
+// recorded, keeping attribute cardinality bounded by the tables queried.
+func (metrics *queryMetrics) record(ctx context.Context, event QueryEvent) {
+	attrs := metrics.attributes(event.Operation, event.Table)
+
+	metrics.count.Add(ctx, 1, attrs)
+	metrics.duration.Record(ctx, event.Duration.Seconds(), attrs)
+	if nil != event.Err {
+		metrics.errors.Add(ctx, 1, attrs)
+	}
+}
+
+// recordPrepare records the time taken to prepare a query on the server.
+func (metrics *queryMetrics) recordPrepare(ctx context.Context, query string, duration time.Duration) {
+	operation, table := ParseStatement(query)
+	metrics.prepare.Record(ctx, duration.Seconds(), metrics.attributes(operation, table))
+}
@@ -0,0 +1,135 @@
+package db_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// TestMeterProvider tests recording query metrics with OpenTelemetry
+// instruments.
+func TestMeterProvider(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	var events []db.QueryEvent
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "stub"
+		cfg.MeterProvider = sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+		cfg.OnQuery = func(event db.QueryEvent) {
+			events = append(events, event)
+		}
+	})
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		if "DELETE FROM locked" == query {
+			return nil, fmt.Errorf("lock wait timeout")
+		}
+		return driver.RowsAffected(1), nil
+	}
+
+	_, err := database.Exec("INSERT INTO users (name) VALUES ('alice')")
+	assert.NoError(t, err)
+	_, err = database.Exec("INSERT INTO users (name) VALUES ('bob')")
+	assert.NoError(t, err)
+	_, err = database.Exec("DELETE FROM locked")
+	assert.Error(t, err)
+
+	assert.Len(t, events, 3)
+	assert.Equal(t, "insert", events[0].Operation)
+	assert.Equal(t, "users", events[0].Table)
+	assert.Error(t, events[2].Err)
+
+	var data metricdata.ResourceMetrics
+	assert.NoError(t, reader.Collect(context.Background(), &data))
+	metrics := map[string]metricdata.Metrics{}
+	for _, scope := range data.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			metrics[m.Name] = m
+		}
+	}
+
+	insert := attribute.NewSet(
+		attribute.String("db.system", "stub"),
+		attribute.String("db.name", database.Config().DatabaseName),
+		attribute.String("db.operation", "insert"),
+		attribute.String("db.sql.table", "users"),
+	)
+	remove := attribute.NewSet(
+		attribute.String("db.system", "stub"),
+		attribute.String("db.name", database.Config().DatabaseName),
+		attribute.String("db.operation", "delete"),
+		attribute.String("db.sql.table", "locked"),
+	)
+
+	counts := map[attribute.Distinct]int64{}
+	for _, point := range metrics["db.client.queries"].Data.(metricdata.Sum[int64]).DataPoints {
+		counts[point.Attributes.Equivalent()] = point.Value
+	}
+	assert.Equal(t, int64(2), counts[insert.Equivalent()])
+	assert.Equal(t, int64(1), counts[remove.Equivalent()])
+
+	durations := map[attribute.Distinct]uint64{}
+	for _, point := range metrics["db.client.query.duration"].Data.(metricdata.Histogram[float64]).DataPoints {
+		durations[point.Attributes.Equivalent()] = point.Count
+	}
+	assert.Equal(t, uint64(2), durations[insert.Equivalent()])
+	assert.Equal(t, uint64(1), durations[remove.Equivalent()])
+
+	errs := metrics["db.client.query.errors"].Data.(metricdata.Sum[int64]).DataPoints
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, remove.Equivalent(), errs[0].Attributes.Equivalent())
+		assert.Equal(t, int64(1), errs[0].Value)
+	}
+}
+
+// TestPrepareMetrics tests recording statement preparation time separately
+// from execution time.
+func TestPrepareMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "stub"
+		cfg.MeterProvider = sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	})
+	stub.PrepareDelay = 20 * time.Millisecond
+
+	stmt, err := database.Prepare("UPDATE users SET active = 1")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Exec()
+	assert.NoError(t, err)
+
+	var data metricdata.ResourceMetrics
+	assert.NoError(t, reader.Collect(context.Background(), &data))
+	metrics := map[string]metricdata.Metrics{}
+	for _, scope := range data.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			metrics[m.Name] = m
+		}
+	}
+
+	update := attribute.NewSet(
+		attribute.String("db.system", "stub"),
+		attribute.String("db.name", database.Config().DatabaseName),
+		attribute.String("db.operation", "update"),
+		attribute.String("db.sql.table", "users"),
+	)
+
+	prepares := metrics["db.client.prepare.duration"].Data.(metricdata.Histogram[float64]).DataPoints
+	if assert.Len(t, prepares, 1) {
+		assert.Equal(t, update.Equivalent(), prepares[0].Attributes.Equivalent())
+		assert.Equal(t, uint64(1), prepares[0].Count)
+		assert.GreaterOrEqual(t, prepares[0].Sum, stub.PrepareDelay.Seconds())
+	}
+
+	execs := metrics["db.client.query.duration"].Data.(metricdata.Histogram[float64]).DataPoints
+	if assert.Len(t, execs, 1) {
+		assert.Equal(t, uint64(1), execs[0].Count)
+		assert.Less(t, execs[0].Sum, stub.PrepareDelay.Seconds())
+	}
+}
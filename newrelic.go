@@ -47,24 +47,131 @@ func parseDsnFn(cfg *Config) func(segment *nr.DatastoreSegment, dsn string) {
 
 func parseQueryFn(cfg *Config) func(segment *nr.DatastoreSegment, query string) {
 	return func(segment *nr.DatastoreSegment, query string) {
-		qry := cCommentRegex.ReplaceAllString(query, "")
-		qry = lineCommentRegex.ReplaceAllString(qry, "")
-		qry = sqlPrefixRegex.ReplaceAllString(qry, "")
-
 		segment.DatabaseName = cfg.DatabaseName
 		segment.Host = cfg.DSNData["host"]
-		segment.ParameterizedQuery = qry
+		segment.ParameterizedQuery = cleanQuery(query)
+		if cfg.NormalizeMetrics {
+			segment.ParameterizedQuery = NormalizeQuery(segment.ParameterizedQuery)
+		}
+		segment.Operation, segment.Collection = ParseStatement(query)
+	}
+}
 
-		op := strings.ToLower(firstWordRegex.FindString(qry))
-		if rg, ok := sqlOperations[op]; ok {
-			segment.Operation = op
-			if nil != rg {
-				if m := rg.FindStringSubmatch(qry); len(m) > 1 {
-					segment.Collection = extractTable(m[1])
+// ParseStatement returns the lowercase operation and the target table of a
+// SQL statement, i.e. "update" and "users" for `UPDATE users SET ...`.
+// Comments are ignored. For statements with common table expressions, i.e.
+// `WITH recent AS (...) SELECT ... FROM orders`, the main statement following
+// the CTEs is classified. The operation is empty if it isn't recognized, and
+// the table is empty if it can't be determined.
+func ParseStatement(query string) (operation, table string) {
+	qry := cleanQuery(query)
+	op := strings.ToLower(firstWordRegex.FindString(qry))
+	if "with" == op {
+		if main := skipCTEs(qry); "" != main {
+			mainOp := strings.ToLower(firstWordRegex.FindString(main))
+			if _, ok := sqlOperations[mainOp]; ok && "with" != mainOp {
+				qry, op = main, mainOp
+			}
+		}
+	}
+	if rg, ok := sqlOperations[op]; ok {
+		operation = op
+		if nil != rg {
+			if m := rg.FindStringSubmatch(qry); len(m) > 1 {
+				table = extractTable(m[1])
+			}
+		}
+	}
+	return operation, table
+}
+
+// NormalizeQuery replaces the numeric and string literals in a query with `?`
+// so queries that differ only in literal values, i.e. `WHERE id = 1` and
+// `WHERE id = 2`, produce the same string. Quoted identifiers, bind
+// placeholders like `$1` and `:1`, and digits within identifiers are left
+// unchanged.
+func NormalizeQuery(query string) string {
+	var out strings.Builder
+	out.Grow(len(query))
+	for a := 0; a < len(query); a++ {
+		c := query[a]
+		switch {
+		case '\'' == c:
+			// string literal, '' or \' escapes a quote
+			for a++; a < len(query); a++ {
+				if '\\' == query[a] {
+					a++
+				} else if '\'' == query[a] {
+					if a+1 < len(query) && '\'' == query[a+1] {
+						a++
+						continue
+					}
+					break
 				}
 			}
+			out.WriteByte('?')
+		case '"' == c || '`' == c:
+			// quoted identifier
+			end := strings.IndexByte(query[a+1:], c)
+			if end < 0 {
+				out.WriteString(query[a:])
+				return out.String()
+			}
+			out.WriteString(query[a : a+end+2])
+			a += end + 1
+		case '0' <= c && c <= '9' && (0 == a || !continuesIdentifier(query[a-1])):
+			// numeric literal, including decimals, exponents, and hex
+			for a+1 < len(query) && (isParamChar(query[a+1]) || '.' == query[a+1] ||
+				(('+' == query[a+1] || '-' == query[a+1]) && ('e' == query[a] || 'E' == query[a]))) {
+				a++
+			}
+			out.WriteByte('?')
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
+
+// skipCTEs returns the main statement of a query starting with a WITH clause,
+// following its common table expressions, or an empty string if it can't be
+// found.
+func skipCTEs(query string) string {
+	depth := 0
+	for a := 0; a < len(query); a++ {
+		switch c := query[a]; c {
+		case '\'', '"', '`':
+			for a++; a < len(query) && query[a] != c; a++ {
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if 0 != depth {
+				continue
+			}
+			// The main statement follows the last CTE body, rather than a
+			// column list or another CTE.
+			rest := strings.TrimLeft(query[a+1:], " \t\r\n")
+			if _, ok := sqlOperations[strings.ToLower(firstWordRegex.FindString(rest))]; ok {
+				return rest
+			}
 		}
 	}
+	return ""
+}
+
+// continuesIdentifier reports whether a digit following c is part of an
+// identifier or bind placeholder rather than the start of a numeric literal.
+func continuesIdentifier(c byte) bool {
+	return isParamChar(c) || '$' == c || ':' == c || '@' == c
+}
+
+// cleanQuery strips comments and leading separators from a query.
+func cleanQuery(query string) string {
+	qry := cCommentRegex.ReplaceAllString(query, "")
+	qry = lineCommentRegex.ReplaceAllString(qry, "")
+	return sqlPrefixRegex.ReplaceAllString(qry, "")
 }
 
 func extractTable(s string) string {
@@ -87,6 +194,8 @@ var (
 		"delete":   regexp.MustCompile(`(?is)^.*?\sfrom` + tablePattern),
 		"insert":   regexp.MustCompile(`(?is)^.*?\sinto?` + tablePattern),
 		"update":   updateRegex,
+		"merge":    regexp.MustCompile(`(?is)^merge\s+into` + tablePattern),
+		"truncate": regexp.MustCompile(`(?is)^truncate(?:\s+table)?` + tablePattern),
 		"call":     nil,
 		"create":   nil,
 		"drop":     nil,
@@ -97,6 +206,9 @@ var (
 		"alter":    nil,
 		"commit":   nil,
 		"rollback": nil,
+		"grant":    nil,
+		"revoke":   nil,
+		"with":     nil,
 	}
 	sqlPrefixRegex = regexp.MustCompile(`^[\s;]*`)
 	tablePattern   = `(` + `\s+` + basicTable + `|` + `\s*` + enclosedTable + `)`
@@ -0,0 +1,157 @@
+package db_test
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bdlm/db"
+	newrelic "github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/stretchr/testify/assert"
+)
+
+// nrCollector is a New Relic collector that accepts agent connections and
+// records the custom attributes of the transaction events reported to it,
+// see newNewRelicApp.
+type nrCollector struct {
+	mu    sync.Mutex
+	attrs []map[string]interface{}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (c *nrCollector) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := "{}"
+	switch req.URL.Query().Get("method") {
+	case "preconnect":
+		body = `{"return_value":{"redirect_host":"collector.test"}}`
+	case "connect":
+		body = `{"return_value":{"agent_run_id":"run"}}`
+	case "analytic_event_data":
+		if err := c.record(req.Body); nil != err {
+			return nil, err
+		}
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// record decodes a gzipped transaction event payload,
+// [run ID, reservoir, [[intrinsics, custom attributes, agent attributes]...]].
+func (c *nrCollector) record(body io.Reader) error {
+	zr, err := gzip.NewReader(body)
+	if nil != err {
+		return err
+	}
+	data, err := io.ReadAll(zr)
+	if nil != err {
+		return err
+	}
+	var payload []json.RawMessage
+	if err = json.Unmarshal(data, &payload); nil != err || 3 > len(payload) {
+		return err
+	}
+	var events [][]map[string]interface{}
+	if err = json.Unmarshal(payload[2], &events); nil != err {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, event := range events {
+		if 1 < len(event) {
+			c.attrs = append(c.attrs, event[1])
+		}
+	}
+	return nil
+}
+
+// Attributes returns the custom attributes of each reported transaction.
+func (c *nrCollector) Attributes() []map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]map[string]interface{}{}, c.attrs...)
+}
+
+// newNewRelicApp returns a New Relic application connected to a local
+// collector. Transaction events are reported when the application is shut
+// down.
+func newNewRelicApp(t *testing.T) (*newrelic.Application, *nrCollector) {
+	collector := &nrCollector{}
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName("db-test"),
+		newrelic.ConfigLicense(strings.Repeat("0", 40)),
+		func(cfg *newrelic.Config) {
+			cfg.Transport = collector
+		},
+	)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err = app.WaitForConnection(time.Second); nil != err {
+		t.Fatal(err)
+	}
+	return app, collector
+}
+
+// TestParseStatement tests extracting the operation and table from queries.
+func TestParseStatement(t *testing.T) {
+	tests := []struct {
+		query string
+		op    string
+		table string
+	}{
+		{"SELECT id FROM users WHERE id = 1", "select", "users"},
+		{"/* comment */ UPDATE my_schema.users SET name = 'x'", "update", "users"},
+		{"INSERT INTO `orders` (id) VALUES (1)", "insert", "orders"},
+		{"DELETE FROM sessions -- expired\nWHERE expires < now()", "delete", "sessions"},
+		{"MERGE INTO app.accounts a USING staged s ON (a.id = s.id) WHEN MATCHED THEN UPDATE SET a.balance = s.balance", "merge", "accounts"},
+		{"TRUNCATE TABLE audit_log", "truncate", "audit_log"},
+		{"TRUNCATE sessions", "truncate", "sessions"},
+		{"GRANT SELECT ON users TO reporting", "grant", ""},
+		{"REVOKE SELECT ON users FROM reporting", "revoke", ""},
+		{"WITH recent (id, total) AS (SELECT id, sum(amount) FROM payments GROUP BY id), \"top\" AS (SELECT id FROM recent WHERE total > ')') SELECT * FROM customers WHERE id IN (SELECT id FROM \"top\")", "select", "customers"},
+		{"WITH RECURSIVE tree AS (SELECT id FROM nodes UNION ALL SELECT n.id FROM nodes n JOIN tree t ON n.parent = t.id) DELETE FROM nodes WHERE id IN (SELECT id FROM tree)", "delete", "nodes"},
+		{"WITH", "with", ""},
+		{"COMMIT", "commit", ""},
+		{"EXPLAIN SELECT 1", "", ""},
+	}
+
+	for _, test := range tests {
+		op, table := db.ParseStatement(test.query)
+		assert.Equal(t, test.op, op, test.query)
+		assert.Equal(t, test.table, table, test.query)
+	}
+}
+
+// TestNormalizeQuery tests replacing literal values in queries.
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		query  string
+		expect string
+	}{
+		{"SELECT * FROM users WHERE id = 1", "SELECT * FROM users WHERE id = ?"},
+		{"SELECT * FROM users WHERE id=2", "SELECT * FROM users WHERE id=?"},
+		{"SELECT * FROM users WHERE name = 'o''brien' AND age > 42.5", "SELECT * FROM users WHERE name = ? AND age > ?"},
+		{"SELECT * FROM users WHERE name = 'it\\'s'", "SELECT * FROM users WHERE name = ?"},
+		{"SELECT col1, \"Table 2\".x FROM t1 WHERE y IN (1, 2e-3, 0x1F)", "SELECT col1, \"Table 2\".x FROM t1 WHERE y IN (?, ?, ?)"},
+		{"UPDATE t SET a = $1, b = :2, c = @p3", "UPDATE t SET a = $1, b = :2, c = @p3"},
+		{"SELECT `col9` FROM `t0`", "SELECT `col9` FROM `t0`"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expect, db.NormalizeQuery(test.query), test.query)
+	}
+
+	assert.Equal(t,
+		db.NormalizeQuery("SELECT * FROM orders WHERE id = 1 AND status = 'new'"),
+		db.NormalizeQuery("SELECT * FROM orders WHERE id = 73 AND status = 'shipped'"),
+	)
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"database/sql/driver"
+)
+
+// Nullable holds a value of a nullable column, replacing the sql.Null* types
+// with a single generic type, i.e. Nullable[int64] or Nullable[string]. It
+// can be used as a scan destination, a struct field populated by StructScan,
+// or a bind value:
+//
+//	type User struct {
+//		ID    int64               `db:"id"`
+//		Email db.Nullable[string] `db:"email"`
+//	}
+//
+// NULL values are scanned as the zero value with Valid unset.
+type Nullable[T any] struct {
+	Val   T
+	Valid bool
+}
+
+// NewNullable returns a valid Nullable holding val.
+func NewNullable[T any](val T) Nullable[T] {
+	return Nullable[T]{Val: val, Valid: true}
+}
+
+// Get returns the value and whether it's valid, i.e. not NULL.
+func (nullable Nullable[T]) Get() (T, bool) {
+	return nullable.Val, nullable.Valid
+}
+
+// Scan implements sql.Scanner. Driver values are converted to T with the
+// rules of sql.Rows.Scan, i.e. text is parsed into numeric and bool types.
+// A T that implements sql.Scanner scans the value itself.
+func (nullable *Nullable[T]) Scan(src interface{}) error {
+	if nil == src {
+		*nullable = Nullable[T]{}
+		return nil
+	}
+
+	var val T
+	if err := assign(&val, src); nil != err {
+		return err
+	}
+	nullable.Val, nullable.Valid = val, true
+	return nil
+}
+
+// Value implements driver.Valuer. Invalid values are NULL.
+func (nullable Nullable[T]) Value() (driver.Value, error) {
+	if !nullable.Valid {
+		return nil, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(nullable.Val)
+}
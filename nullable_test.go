@@ -0,0 +1,68 @@
+package db_test
+
+import (
+	"database/sql/driver"
+	"testing"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNullable tests scanning NULL and non-NULL values into Nullable struct
+// fields.
+func TestNullable(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"id", "age", "email"},
+			[]driver.Value{int64(1), int64(42), []byte("alice@example.com")},
+			[]driver.Value{int64(2), nil, nil},
+			[]driver.Value{int64(3), []byte("7"), "carol@example.com"},
+		), nil
+	}
+
+	stmt, err := database.Prepare("SELECT id, age, email FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+
+	var row struct {
+		ID    int64               `db:"id"`
+		Age   db.Nullable[int64]  `db:"age"`
+		Email db.Nullable[string] `db:"email"`
+	}
+
+	// non-NULL values
+	assert.True(t, stmt.StructNext(&row))
+	age, ok := row.Age.Get()
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), age)
+	email, ok := row.Email.Get()
+	assert.True(t, ok)
+	assert.Equal(t, "alice@example.com", email)
+
+	// NULL values
+	assert.True(t, stmt.StructNext(&row))
+	_, ok = row.Age.Get()
+	assert.False(t, ok)
+	email, ok = row.Email.Get()
+	assert.False(t, ok)
+	assert.Equal(t, "", email)
+
+	// text values are parsed
+	assert.True(t, stmt.StructNext(&row))
+	assert.Equal(t, db.NewNullable(int64(7)), row.Age)
+	assert.Equal(t, db.NewNullable("carol@example.com"), row.Email)
+}
+
+// TestNullableValue tests binding Nullable values.
+func TestNullableValue(t *testing.T) {
+	value, err := db.NewNullable(int32(5)).Value()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), value)
+
+	value, err = db.Nullable[string]{}.Value()
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+}
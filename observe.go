@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/bdlm/log/v2"
+)
+
+// maxStackFrames limits the number of frames captured for slow-query stack
+// traces.
+const maxStackFrames = 16
+
+// pkgPath is the import path of this package, used to exclude its frames
+// from captured stacks.
+var pkgPath = reflect.TypeOf(DB{}).PkgPath()
+
+// QueryEvent describes the execution of a query, see Config.OnQuery.
+type QueryEvent struct {
+	// Database name, see Config.DatabaseName.
+	Database string
+
+	// Time taken to execute the query. For queries that return rows this
+	// doesn't include reading the rows.
+	Duration time.Duration
+
+	// The error returned by the query, if any.
+	Err error
+
+	// The lowercase operation and target table of the query, see
+	// ParseStatement.
+	Operation string
+	Table     string
+
+	// The SQL query string.
+	Query string
+
+	// The request ID carried by the query context, see Config.RequestIDKey.
+	RequestID string
+}
+
+// observe records the execution of a query that started at start, logging
+// it if it exceeded Config.SlowQueryThreshold, reporting it to the
+// configured metrics and Config.OnQuery hook, and writing it with its
+// arguments to Config.RecordQueries.
+func (db *DB) observe(ctx context.Context, query string, args []interface{}, start time.Time, err error) {
+	cfg := db.Config()
+	if nil != cfg.RecordQueries {
+		db.recordQuery(query, args, start)
+	}
+	if nil == cfg.OnQuery && nil == db.metrics && 0 >= cfg.SlowQueryThreshold {
+		return
+	}
+
+	event := QueryEvent{
+		Database:  cfg.DatabaseName,
+		Duration:  time.Since(start),
+		Err:       err,
+		Query:     query,
+		RequestID: cfg.requestID(ctx),
+	}
+	event.Operation, event.Table = ParseStatement(query)
+
+	if 0 < cfg.SlowQueryThreshold && event.Duration >= cfg.SlowQueryThreshold {
+		db.logSlowQuery(event)
+	}
+	if nil != db.metrics {
+		db.metrics.record(ctx, event)
+	}
+	if nil != cfg.OnQuery {
+		cfg.OnQuery(event)
+	}
+}
+
+// prepare prepares a query in a transaction. The time taken is recorded
+// separately from execution time, as a "prepare" span of the configured
+// tracers (see Config.Tracer) and in the `db.client.prepare.duration`
+// histogram of the configured metrics (see Config.MeterProvider), so
+// expensive compiles can be told apart from slow executions.
+func (db *DB) prepare(ctx context.Context, txn *sql.Tx, query string) (*sql.Stmt, error) {
+	span := db.startSpan(ctx, "prepare", query)
+	start := time.Now()
+	stmt, err := txn.PrepareContext(ctx, db.Config().driverSQL(query))
+	db.endSpan(span, err)
+	if nil != db.metrics {
+		db.metrics.recordPrepare(ctx, query, time.Since(start))
+	}
+	return stmt, err
+}
+
+// logSlowQuery logs a query that exceeded Config.SlowQueryThreshold.
+func (db *DB) logSlowQuery(event QueryEvent) {
+	fields := log.Fields{
+		"database": event.Database,
+		"duration": event.Duration.String(),
+		"query":    event.Query,
+	}
+	if "" != event.RequestID {
+		fields["request_id"] = event.RequestID
+	}
+	if db.Config().SlowQueryStack {
+		fields["stack"] = callerStack()
+	}
+	log.WithFields(fields).Warn("slow query")
+}
+
+// callerStack returns the current call stack as "function (file:line)"
+// entries, excluding frames in this package and the runtime.
+func callerStack() []string {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+
+	stack := []string{}
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, pkgPath+".") &&
+			!strings.HasPrefix(frame.Function, "runtime.") {
+			stack = append(stack, fmt.Sprintf("%s (%s:%d)", frame.Function, frame.File, frame.Line))
+			if maxStackFrames == len(stack) {
+				break
+			}
+		}
+		if !more {
+			break
+		}
+	}
+	return stack
+}
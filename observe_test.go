@@ -0,0 +1,50 @@
+package db_test
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSlowQueryStack tests logging the caller stack of slow queries.
+func TestSlowQueryStack(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.SlowQueryStack = true
+		cfg.SlowQueryThreshold = 5 * time.Millisecond
+	})
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		if strings.Contains(query, "slow") {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return driver.RowsAffected(1), nil
+	}
+	logs := captureLogs(t)
+
+	_, err := database.Exec("UPDATE fast SET x = 1")
+	assert.NoError(t, err)
+	assert.Empty(t, logs.Entries("slow query"))
+
+	stmt, err := database.Prepare("UPDATE slow SET x = 1")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Exec()
+	assert.NoError(t, err)
+
+	entries := logs.Entries("slow query")
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "UPDATE slow SET x = 1", entries[0].Data["query"])
+		stack, ok := entries[0].Data["stack"].([]string)
+		assert.True(t, ok)
+		if assert.NotEmpty(t, stack) {
+			assert.Contains(t, stack[0], "db_test.TestSlowQueryStack")
+			assert.Contains(t, stack[0], "observe_test.go")
+		}
+		for _, frame := range stack {
+			assert.NotContains(t, frame, "github.com/bdlm/db.")
+		}
+	}
+}
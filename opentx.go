@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bdlm/log/v2"
+)
+
+// openTx is a transaction watched by the open transaction sweeper, see
+// Config.OpenTxWarnAfter.
+type openTx struct {
+	ctx     context.Context
+	stack   []string
+	started time.Time
+	warned  bool
+}
+
+// trackTx starts watching a transaction, see Config.OpenTxWarnAfter.
+func (db *DB) trackTx(ctx context.Context, txn *sql.Tx) {
+	if 0 >= db.Config().OpenTxWarnAfter {
+		return
+	}
+	db.openTxsMu.Lock()
+	defer db.openTxsMu.Unlock()
+	if nil == db.openTxs {
+		db.openTxs = map[*sql.Tx]*openTx{}
+	}
+	db.openTxs[txn] = &openTx{ctx: ctx, stack: callerStack(), started: time.Now()}
+}
+
+// untrackTx stops watching a transaction that has been committed or rolled
+// back.
+func (db *DB) untrackTx(txn *sql.Tx) {
+	db.openTxsMu.Lock()
+	defer db.openTxsMu.Unlock()
+	delete(db.openTxs, txn)
+}
+
+// sweepOpenTxs logs a warning for each transaction open longer than
+// threshold until the database is closed, see Config.OpenTxWarnAfter.
+func (db *DB) sweepOpenTxs(threshold time.Duration) {
+	ticker := time.NewTicker(threshold / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.Ctx.Done():
+			return
+		case <-ticker.C:
+			db.warnOpenTxs(threshold)
+		}
+	}
+}
+
+// warnOpenTxs logs a warning for each transaction open longer than
+// threshold that hasn't been reported yet. Transactions whose context is
+// done have been rolled back by database/sql and are forgotten.
+func (db *DB) warnOpenTxs(threshold time.Duration) {
+	db.openTxsMu.Lock()
+	defer db.openTxsMu.Unlock()
+	for txn, open := range db.openTxs {
+		if nil != open.ctx.Err() {
+			delete(db.openTxs, txn)
+			continue
+		}
+		age := time.Since(open.started)
+		if open.warned || age < threshold {
+			continue
+		}
+		open.warned = true
+		log.WithFields(log.Fields{
+			"age":      age.String(),
+			"database": db.Config().DatabaseName,
+			"stack":    open.stack,
+		}).Warn("transaction open longer than threshold")
+	}
+}
@@ -0,0 +1,62 @@
+// Package otel traces the database calls made by the github.com/bdlm/db
+// package with OpenTelemetry spans. Use NewTracer to create a db.Tracer for
+// db.Config.Tracer:
+//
+//	cfg := &db.Config{
+//		...
+//		Tracer: otel.NewTracer(otelapi.GetTracerProvider()),
+//	}
+package otel
+
+import (
+	"context"
+	"strings"
+
+	"github.com/bdlm/db"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the spans created by this package.
+const instrumentationName = "github.com/bdlm/db"
+
+// Tracer implements db.Tracer with OpenTelemetry spans.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer returns a Tracer that creates spans with the provider's tracer.
+// https://pkg.go.dev/go.opentelemetry.io/otel/trace#TracerProvider
+func NewTracer(provider trace.TracerProvider) *Tracer {
+	return &Tracer{provider.Tracer(instrumentationName)}
+}
+
+// StartSpan implements db.Tracer. Spans are named for the call, operation,
+// and table, i.e. "exec update users", and carry the `db.*` semantic
+// convention attributes.
+func (tracer *Tracer) StartSpan(ctx context.Context, span db.Span) context.Context {
+	name := strings.Join(strings.Fields(span.Kind+" "+span.Operation+" "+span.Table), " ")
+	ctx, _ = tracer.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", span.DriverType),
+			attribute.String("db.name", span.Database),
+			attribute.String("db.operation", span.Operation),
+			attribute.String("db.sql.table", span.Table),
+			attribute.String("db.statement", span.Query),
+		),
+	)
+	return ctx
+}
+
+// EndSpan implements db.Tracer. Errors are recorded on the span and set its
+// status.
+func (tracer *Tracer) EndSpan(ctx context.Context, err error) {
+	span := trace.SpanFromContext(ctx)
+	if nil != err {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
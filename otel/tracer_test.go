@@ -0,0 +1,47 @@
+package otel_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/bdlm/db"
+	"github.com/bdlm/db/otel"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// TestTracer tests recording database calls as OpenTelemetry spans.
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := otel.NewTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctx := tracer.StartSpan(context.Background(), db.Span{
+		Database:   "app",
+		DriverType: "postgres",
+		Kind:       "exec",
+		Operation:  "update",
+		Table:      "users",
+		Query:      "UPDATE users SET active = 1",
+	})
+	tracer.EndSpan(ctx, nil)
+
+	ctx = tracer.StartSpan(context.Background(), db.Span{Kind: "prepare", Query: "EXPLAIN SELECT 1"})
+	tracer.EndSpan(ctx, fmt.Errorf("syntax error"))
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "exec update users", spans[0].Name())
+	assert.Contains(t, spans[0].Attributes(), attribute.String("db.system", "postgres"))
+	assert.Contains(t, spans[0].Attributes(), attribute.String("db.name", "app"))
+	assert.Contains(t, spans[0].Attributes(), attribute.String("db.sql.table", "users"))
+	assert.Contains(t, spans[0].Attributes(), attribute.String("db.statement", "UPDATE users SET active = 1"))
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+
+	assert.Equal(t, "prepare", spans[1].Name())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Equal(t, "syntax error", spans[1].Status().Description)
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bdlm/log/v2"
+)
+
+// Paginate appends a driver-specific clause to a SELECT query returning at
+// most limit rows after skipping offset rows, i.e. for paginated APIs. The
+// query should have an ORDER BY clause so pages are stable. The clause depends
+// on the DriverType:
+//
+//   - "cockroach", "mysql", "postgres", "sqlite": `LIMIT n OFFSET m`.
+//   - "oracle": `OFFSET m ROWS FETCH NEXT n ROWS ONLY`, requires 12c or later.
+//
+// The limit and offset are written as integer literals rather than bound, so
+// they don't collide with the query's own placeholders. Negative values are
+// treated as zero.
+//
+// The query is returned unchanged, and a warning logged, for other driver
+// types.
+func (db *DB) Paginate(query string, limit, offset int) string {
+	trimmed := strings.TrimRight(strings.TrimSpace(query), ";")
+	limit, offset = max(limit, 0), max(offset, 0)
+
+	switch db.Config().DriverType {
+	case "cockroach", "mysql", "postgres", "sqlite":
+		return fmt.Sprintf("%s LIMIT %d OFFSET %d", trimmed, limit, offset)
+
+	case "oracle":
+		return fmt.Sprintf("%s OFFSET %d ROWS FETCH NEXT %d ROWS ONLY", trimmed, offset, limit)
+	}
+
+	log.WithFields(log.Fields{
+		"database":    db.Config().DatabaseName,
+		"driver_type": db.Config().DriverType,
+	}).Warn("pagination is not supported for driver type, query not paginated")
+	return query
+}
@@ -0,0 +1,55 @@
+package db_test
+
+import (
+	"database/sql/driver"
+	"testing"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPaginate tests the pagination clause generated for each driver.
+func TestPaginate(t *testing.T) {
+	tests := map[string]string{
+		"cockroach": "SELECT id FROM users ORDER BY id LIMIT 10 OFFSET 20",
+		"mysql":     "SELECT id FROM users ORDER BY id LIMIT 10 OFFSET 20",
+		"oracle":    "SELECT id FROM users ORDER BY id OFFSET 20 ROWS FETCH NEXT 10 ROWS ONLY",
+		"postgres":  "SELECT id FROM users ORDER BY id LIMIT 10 OFFSET 20",
+		"sqlite":    "SELECT id FROM users ORDER BY id LIMIT 10 OFFSET 20",
+	}
+	for driverType, expect := range tests {
+		database, _ := newStubDB(t, func(cfg *db.Config) {
+			cfg.DriverType = driverType
+		})
+		assert.Equal(t, expect, database.Paginate("SELECT id FROM users ORDER BY id;", 10, 20), driverType)
+	}
+
+	// the query's own placeholders are left alone
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "postgres"
+		cfg.RebindNamed = true
+	})
+	var bound []driver.NamedValue
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		bound = args
+		return newStubRows([]string{"id"}), nil
+	}
+	stmt, err := database.Prepare(database.Paginate("SELECT id FROM users WHERE org_id = :org ORDER BY id", 10, 20))
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Bind("org", 7).Query()
+	assert.NoError(t, err)
+	assert.Equal(t, "query: SELECT id FROM users WHERE org_id = $1 ORDER BY id LIMIT 10 OFFSET 20", stub.Log()[len(stub.Log())-1])
+	assert.Equal(t, []driver.NamedValue{{Ordinal: 1, Value: 7}}, bound)
+
+	// negative values are treated as zero
+	assert.Equal(t, "SELECT id FROM users ORDER BY id LIMIT 0 OFFSET 0", database.Paginate("SELECT id FROM users ORDER BY id", -1, -10))
+
+	// unsupported drivers
+	logs := captureLogs(t)
+	database, _ = newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "snowflake"
+	})
+	assert.Equal(t, "SELECT id FROM users ORDER BY id", database.Paginate("SELECT id FROM users ORDER BY id", 10, 20))
+	assert.Len(t, logs.Entries("pagination is not supported for driver type, query not paginated"), 1)
+}
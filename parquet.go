@@ -0,0 +1,253 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	"github.com/apache/arrow/go/v14/parquet"
+	"github.com/apache/arrow/go/v14/parquet/pqarrow"
+	"github.com/bdlm/errors/v2"
+)
+
+// parquetRowGroupRows limits the number of rows buffered for each Parquet row
+// group written by WriteParquet.
+const parquetRowGroupRows = 10000
+
+// WriteParquet writes the remaining rows of the current result cursor to w
+// as a Parquet file. The schema is inferred from the column scan types
+// reported by the driver (see sql.ColumnType.ScanType): integers as INT64,
+// floats as DOUBLE, booleans as BOOLEAN, time.Time as a microsecond UTC
+// TIMESTAMP, []byte as BYTE_ARRAY, and other types as UTF8 strings. All
+// columns are nullable. Rows are read using MapScan, so values in
+// Config.MaskColumns columns are masked; masked columns are written as UTF8
+// strings whatever their type. Rows are written in row groups of up to 10000
+// rows. If an error occurs the rows written so far are closed off as a
+// complete Parquet file. w is not closed.
+func (statement *Statement) WriteParquet(w io.Writer) error {
+	columns, err := statement.exportColumns()
+	if nil != err {
+		return err
+	}
+	defer statement.rows.Close()
+
+	types, err := statement.rows.ColumnTypes()
+	if nil != err {
+		statement.lastErr = errors.Wrap(err, "failed to list result column types")
+		return statement.lastErr
+	}
+	fields := make([]arrow.Field, len(columns))
+	for a, column := range columns {
+		typ := parquetType(types[a])
+		if statement.db.Config().masked(column) {
+			typ = arrow.BinaryTypes.String
+		}
+		fields[a] = arrow.Field{Name: column, Type: typ, Nullable: true}
+	}
+	schema := arrow.NewSchema(fields, nil)
+
+	// Hide any Close method, the writer closes its sink.
+	writer, err := pqarrow.NewFileWriter(
+		schema,
+		struct{ io.Writer }{w},
+		parquet.NewWriterProperties(parquet.WithMaxRowGroupLength(parquetRowGroupRows)),
+		pqarrow.DefaultWriterProps(),
+	)
+	if nil != err {
+		return errors.Wrap(err, "failed to create Parquet writer")
+	}
+	// Close is a no-op once the writer has been closed below.
+	defer writer.Close()
+
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer builder.Release()
+
+	flush := func() error {
+		record := builder.NewRecord()
+		defer record.Release()
+		if 0 == record.NumRows() {
+			return nil
+		}
+		if err := writer.Write(record); nil != err {
+			return errors.Wrap(err, "failed to write Parquet row group")
+		}
+		return nil
+	}
+
+	for rows := 0; statement.rows.Next(); rows++ {
+		row := map[string]interface{}{}
+		if err = statement.MapScan(row); nil != err {
+			statement.lastErr = err
+			return err
+		}
+		for a, column := range columns {
+			if err = appendParquetValue(builder.Field(a), row[column]); nil != err {
+				statement.lastErr = errors.Wrap(err, "failed to convert column '%s'", column)
+				return statement.lastErr
+			}
+		}
+		if parquetRowGroupRows-1 == rows%parquetRowGroupRows {
+			if err = flush(); nil != err {
+				return err
+			}
+		}
+	}
+	if err = statement.Err(); nil != err {
+		return err
+	}
+	if err = flush(); nil != err {
+		return err
+	}
+	if err = writer.Close(); nil != err {
+		return errors.Wrap(err, "failed to write Parquet footer")
+	}
+	return nil
+}
+
+// parquetType returns the Arrow type a result column is written as, see
+// WriteParquet.
+func parquetType(column *sql.ColumnType) arrow.DataType {
+	typ := column.ScanType()
+	if nil == typ {
+		return arrow.BinaryTypes.String
+	}
+	switch reflect.New(typ).Elem().Interface().(type) {
+	case sql.NullInt64, sql.NullInt32, sql.NullInt16, sql.NullByte:
+		return arrow.PrimitiveTypes.Int64
+	case sql.NullFloat64:
+		return arrow.PrimitiveTypes.Float64
+	case sql.NullBool:
+		return arrow.FixedWidthTypes.Boolean
+	case time.Time, sql.NullTime:
+		return arrow.FixedWidthTypes.Timestamp_us
+	case []byte, sql.RawBytes:
+		return arrow.BinaryTypes.Binary
+	}
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return arrow.PrimitiveTypes.Int64
+	case reflect.Float32, reflect.Float64:
+		return arrow.PrimitiveTypes.Float64
+	case reflect.Bool:
+		return arrow.FixedWidthTypes.Boolean
+	}
+	return arrow.BinaryTypes.String
+}
+
+// appendParquetValue appends a MapScan value to a column builder, converting
+// it to the column's type.
+func appendParquetValue(builder array.Builder, value interface{}) error {
+	value, err := exportParquetValue(value)
+	if nil != err {
+		return err
+	}
+	if nil == value {
+		builder.AppendNull()
+		return nil
+	}
+
+	switch builder := builder.(type) {
+	case *array.Int64Builder:
+		switch value := value.(type) {
+		case string:
+			v, err := strconv.ParseInt(value, 10, 64)
+			if nil != err {
+				return err
+			}
+			builder.Append(v)
+		default:
+			v := reflect.ValueOf(value)
+			switch {
+			case v.CanInt():
+				builder.Append(v.Int())
+			case v.CanUint():
+				builder.Append(int64(v.Uint()))
+			default:
+				return errors.Errorf("cannot convert %T to an integer", value)
+			}
+		}
+	case *array.Float64Builder:
+		switch value := value.(type) {
+		case string:
+			v, err := strconv.ParseFloat(value, 64)
+			if nil != err {
+				return err
+			}
+			builder.Append(v)
+		default:
+			v := reflect.ValueOf(value)
+			switch {
+			case v.CanFloat():
+				builder.Append(v.Float())
+			case v.CanInt():
+				builder.Append(float64(v.Int()))
+			default:
+				return errors.Errorf("cannot convert %T to a float", value)
+			}
+		}
+	case *array.BooleanBuilder:
+		switch value := value.(type) {
+		case bool:
+			builder.Append(value)
+		case int64:
+			builder.Append(0 != value)
+		case string:
+			v, err := strconv.ParseBool(value)
+			if nil != err {
+				return err
+			}
+			builder.Append(v)
+		default:
+			return errors.Errorf("cannot convert %T to a boolean", value)
+		}
+	case *array.TimestampBuilder:
+		t, ok := value.(time.Time)
+		if !ok {
+			return errors.Errorf("cannot convert %T to a timestamp", value)
+		}
+		builder.Append(arrow.Timestamp(t.UnixMicro()))
+	case *array.BinaryBuilder:
+		switch value := value.(type) {
+		case []byte:
+			builder.Append(value)
+		case string:
+			builder.AppendString(value)
+		default:
+			return errors.Errorf("cannot convert %T to bytes", value)
+		}
+	case *array.StringBuilder:
+		switch value := value.(type) {
+		case []byte:
+			builder.Append(string(value))
+		case string:
+			builder.Append(value)
+		case time.Time:
+			builder.Append(value.Format(time.RFC3339Nano))
+		default:
+			builder.Append(fmt.Sprint(value))
+		}
+	default:
+		return errors.Errorf("unsupported column builder %T", builder)
+	}
+	return nil
+}
+
+// exportParquetValue reads LOB values returned by MapScan, see
+// Config.LazyLOB.
+func exportParquetValue(value interface{}) (interface{}, error) {
+	if reader, ok := value.(io.Reader); ok {
+		data, err := io.ReadAll(reader)
+		if nil != err {
+			return nil, errors.Wrap(err, "failed to read LOB value")
+		}
+		return data, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,157 @@
+package db_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	"github.com/apache/arrow/go/v14/parquet/file"
+	"github.com/apache/arrow/go/v14/parquet/pqarrow"
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWriteParquet tests that a result set is written as a Parquet file with
+// an inferred schema and NULL values preserved.
+func TestWriteParquet(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"id", "score", "name", "active", "created"},
+			[]driver.Value{int64(1), 9.5, "alice", true, created},
+			[]driver.Value{int64(2), nil, []byte("bob"), false, nil},
+		), nil
+	}
+
+	stmt, err := database.Prepare("SELECT id, score, name, active, created FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, stmt.WriteParquet(buf))
+
+	reader, err := file.NewParquetReader(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	defer reader.Close()
+	assert.Equal(t, int64(2), reader.NumRows())
+
+	arrowReader, err := pqarrow.NewFileReader(reader, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	assert.NoError(t, err)
+	table, err := arrowReader.ReadTable(context.Background())
+	assert.NoError(t, err)
+	defer table.Release()
+
+	schema := table.Schema()
+	assert.Equal(t, []string{"id", "score", "name", "active", "created"}, []string{
+		schema.Field(0).Name, schema.Field(1).Name, schema.Field(2).Name, schema.Field(3).Name, schema.Field(4).Name,
+	})
+	assert.Equal(t, arrow.INT64, schema.Field(0).Type.ID())
+	assert.Equal(t, arrow.FLOAT64, schema.Field(1).Type.ID())
+	assert.Equal(t, arrow.STRING, schema.Field(2).Type.ID())
+	assert.Equal(t, arrow.BOOL, schema.Field(3).Type.ID())
+	assert.Equal(t, arrow.TIMESTAMP, schema.Field(4).Type.ID())
+
+	ids := table.Column(0).Data().Chunk(0).(*array.Int64)
+	assert.Equal(t, []int64{1, 2}, ids.Int64Values())
+
+	scores := table.Column(1).Data().Chunk(0).(*array.Float64)
+	assert.Equal(t, 9.5, scores.Value(0))
+	assert.True(t, scores.IsNull(1))
+
+	names := table.Column(2).Data().Chunk(0).(*array.String)
+	assert.Equal(t, "alice", names.Value(0))
+	assert.Equal(t, "bob", names.Value(1))
+
+	active := table.Column(3).Data().Chunk(0).(*array.Boolean)
+	assert.True(t, active.Value(0))
+	assert.False(t, active.Value(1))
+
+	createdAt := table.Column(4).Data().Chunk(0).(*array.Timestamp)
+	assert.Equal(t, created, createdAt.Value(0).ToTime(arrow.Microsecond))
+	assert.True(t, createdAt.IsNull(1))
+}
+
+// TestWriteParquetMaskColumns tests writing masked columns as strings.
+func TestWriteParquetMaskColumns(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.MaskColumns = []string{"ssn", "active"}
+	})
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"id", "ssn", "active"},
+			[]driver.Value{int64(1), int64(123456789), true},
+			[]driver.Value{int64(2), nil, false},
+		), nil
+	}
+
+	stmt, err := database.Prepare("SELECT id, ssn, active FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, stmt.WriteParquet(buf))
+
+	reader, err := file.NewParquetReader(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	defer reader.Close()
+	arrowReader, err := pqarrow.NewFileReader(reader, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	assert.NoError(t, err)
+	table, err := arrowReader.ReadTable(context.Background())
+	assert.NoError(t, err)
+	defer table.Release()
+
+	assert.Equal(t, arrow.INT64, table.Schema().Field(0).Type.ID())
+	for a := 1; a < 3; a++ {
+		assert.Equal(t, arrow.STRING, table.Schema().Field(a).Type.ID())
+		values := table.Column(a).Data().Chunk(0).(*array.String)
+		assert.Equal(t, "****", values.Value(0))
+		assert.Equal(t, "****", values.Value(1))
+	}
+}
+
+// TestWriteParquetError tests that the Parquet file is closed off when a
+// value can't be converted.
+func TestWriteParquetError(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"id"},
+			[]driver.Value{int64(1)},
+			[]driver.Value{"two"},
+		), nil
+	}
+
+	stmt, err := database.Prepare("SELECT id FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	assert.Error(t, stmt.WriteParquet(buf))
+
+	// the footer was written
+	reader, err := file.NewParquetReader(bytes.NewReader(buf.Bytes()))
+	if assert.NoError(t, err) {
+		assert.NoError(t, reader.Close())
+	}
+}
+
+// TestWriteParquetNoCursor tests that WriteParquet requires a query.
+func TestWriteParquetNoCursor(t *testing.T) {
+	database, _ := newStubDB(t)
+	stmt, err := database.Prepare("SELECT id FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	assert.Error(t, stmt.WriteParquet(&bytes.Buffer{}))
+}
@@ -0,0 +1,106 @@
+// Package prometheus exports Prometheus metrics for the database calls made
+// by the github.com/bdlm/db package. Use NewCollector to create a collector,
+// register it, and set it as db.Config.Tracer:
+//
+//	collector := prometheus.NewCollector()
+//	promapi.MustRegister(collector)
+//	cfg := &db.Config{
+//		...
+//		Tracer: collector,
+//	}
+//
+// Metrics are labeled by database name, driver type, and call kind only, so
+// their cardinality stays bounded; query text is never used as a label.
+package prometheus
+
+import (
+	"context"
+	"time"
+
+	"github.com/bdlm/db"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// labels are the labels of every metric exported by Collector.
+var labels = []string{"database", "driver_type", "kind"}
+
+// Collector implements db.Tracer and prometheus.Collector, counting and
+// timing prepares, execs, queries, commits, and rollbacks, and counting
+// their errors:
+//
+//   - db_client_calls_total: the number of calls.
+//   - db_client_errors_total: the number of calls that failed.
+//   - db_client_call_duration_seconds: a histogram of call latency.
+type Collector struct {
+	calls    *prom.CounterVec
+	errors   *prom.CounterVec
+	duration *prom.HistogramVec
+}
+
+// NewCollector returns a Collector with the default histogram buckets.
+// https://pkg.go.dev/github.com/prometheus/client_golang/prometheus#DefBuckets
+func NewCollector() *Collector {
+	return &Collector{
+		calls: prom.NewCounterVec(prom.CounterOpts{
+			Name: "db_client_calls_total",
+			Help: "Number of database calls by kind: prepare, exec, query, commit, or rollback.",
+		}, labels),
+		errors: prom.NewCounterVec(prom.CounterOpts{
+			Name: "db_client_errors_total",
+			Help: "Number of database calls that returned an error.",
+		}, labels),
+		duration: prom.NewHistogramVec(prom.HistogramOpts{
+			Name:    "db_client_call_duration_seconds",
+			Help:    "Latency of database calls in seconds.",
+			Buckets: prom.DefBuckets,
+		}, labels),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (collector *Collector) Describe(ch chan<- *prom.Desc) {
+	collector.calls.Describe(ch)
+	collector.errors.Describe(ch)
+	collector.duration.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (collector *Collector) Collect(ch chan<- prom.Metric) {
+	collector.calls.Collect(ch)
+	collector.errors.Collect(ch)
+	collector.duration.Collect(ch)
+}
+
+// call is the context value of a call started by StartSpan.
+type call struct {
+	labels prom.Labels
+	start  time.Time
+}
+
+// callKey is the context key of a call started by StartSpan.
+type callKey struct{}
+
+// StartSpan implements db.Tracer.
+func (collector *Collector) StartSpan(ctx context.Context, span db.Span) context.Context {
+	return context.WithValue(ctx, callKey{}, call{
+		labels: prom.Labels{
+			"database":    span.Database,
+			"driver_type": span.DriverType,
+			"kind":        span.Kind,
+		},
+		start: time.Now(),
+	})
+}
+
+// EndSpan implements db.Tracer.
+func (collector *Collector) EndSpan(ctx context.Context, err error) {
+	call, ok := ctx.Value(callKey{}).(call)
+	if !ok {
+		return
+	}
+	collector.calls.With(call.labels).Inc()
+	collector.duration.With(call.labels).Observe(time.Since(call.start).Seconds())
+	if nil != err {
+		collector.errors.With(call.labels).Inc()
+	}
+}
@@ -0,0 +1,51 @@
+package prometheus_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bdlm/db"
+	"github.com/bdlm/db/prometheus"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCollector tests counting and timing database calls.
+func TestCollector(t *testing.T) {
+	collector := prometheus.NewCollector()
+	registry := prom.NewRegistry()
+	assert.NoError(t, registry.Register(collector))
+
+	span := db.Span{
+		Database:   "app",
+		DriverType: "postgres",
+		Kind:       "exec",
+		Operation:  "update",
+		Table:      "users",
+		Query:      "UPDATE users SET active = 1",
+	}
+	collector.EndSpan(collector.StartSpan(context.Background(), span), nil)
+	collector.EndSpan(collector.StartSpan(context.Background(), span), fmt.Errorf("deadlock"))
+	span.Kind = "commit"
+	collector.EndSpan(collector.StartSpan(context.Background(), span), nil)
+
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP db_client_calls_total Number of database calls by kind: prepare, exec, query, commit, or rollback.
+# TYPE db_client_calls_total counter
+db_client_calls_total{database="app",driver_type="postgres",kind="commit"} 1
+db_client_calls_total{database="app",driver_type="postgres",kind="exec"} 2
+# HELP db_client_errors_total Number of database calls that returned an error.
+# TYPE db_client_errors_total counter
+db_client_errors_total{database="app",driver_type="postgres",kind="exec"} 1
+`), "db_client_calls_total", "db_client_errors_total"))
+
+	// latency is recorded per call kind
+	assert.Equal(t, 2, testutil.CollectAndCount(collector, "db_client_call_duration_seconds"))
+
+	// ending a context without a started call is ignored
+	collector.EndSpan(context.Background(), nil)
+	assert.Equal(t, 2, testutil.CollectAndCount(collector, "db_client_calls_total"))
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/bdlm/log/v2"
+)
+
+// BackoffConfig defines the delays between reconnect attempts made by
+// StartReconnectMonitor.
+type BackoffConfig struct {
+	// The delay before the first reconnect attempt, multiplied by Multiplier
+	// for each further attempt.
+	BaseDelay time.Duration
+
+	// Optional, the factor each delay grows by, i.e. 1.5. Defaults to 2.
+	Multiplier float64
+
+	// Optional, the maximum delay between attempts. Unlimited if zero.
+	MaxDelay time.Duration
+
+	// Optional, the fraction of each delay randomly added or subtracted so
+	// that clients don't reconnect in lockstep, i.e. 0.2 for ±20%. Defaults
+	// to Config.RetryJitter.
+	Jitter float64
+}
+
+// Delay returns the time to wait before the given reconnect attempt,
+// starting from 1, computed the same way as RetryPolicy.Delay.
+func (backoff BackoffConfig) Delay(attempt int) time.Duration {
+	return RetryPolicy{
+		BaseDelay:  backoff.BaseDelay,
+		Multiplier: backoff.Multiplier,
+		MaxDelay:   backoff.MaxDelay,
+		Jitter:     backoff.Jitter,
+	}.Delay(attempt)
+}
+
+// StartReconnectMonitor starts a goroutine that pings the database every
+// interval and, when a ping fails, reconnects with exponential backoff until
+// a connection is made. Each ping is bounded by the interval. State changes
+// are reported to Config.OnConnectionState so callers can gate traffic. The
+// monitor stops when the database context (Config.Ctx) is done, as when the
+// database is closed. Not connected databases, as with Config.LazyConnect,
+// are skipped until they connect.
+func (db *DB) StartReconnectMonitor(interval time.Duration, backoff BackoffConfig) {
+	go db.reconnectMonitor(interval, backoff)
+}
+
+// reconnectMonitor runs the reconnect monitor, see StartReconnectMonitor.
+func (db *DB) reconnectMonitor(interval time.Duration, backoff BackoffConfig) {
+	if 0 == backoff.Jitter {
+		backoff.Jitter = db.Config().RetryJitter
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.Ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := db.monitorPing(interval)
+		if nil == err {
+			continue
+		}
+		log.WithError(err).WithFields(log.Fields{
+			"database": db.Config().DatabaseName,
+		}).Warn("database connection lost, reconnecting")
+		db.connectionState(false, err)
+
+		for attempt := 1; ; attempt++ {
+			if nil != wait(db.Ctx, backoff.Delay(attempt)) {
+				return
+			}
+			if err = db.reconnect(); nil == err {
+				break
+			}
+			log.WithError(err).WithFields(log.Fields{
+				"attempt":  attempt,
+				"database": db.Config().DatabaseName,
+			}).Error("database reconnect failed")
+		}
+		log.WithFields(log.Fields{
+			"database": db.Config().DatabaseName,
+		}).Info("database connection re-established")
+		db.connectionState(true, nil)
+
+		// Don't ping again right after reconnecting.
+		ticker.Reset(interval)
+	}
+}
+
+// monitorPing pings the database, giving up after timeout. Databases that
+// are closed or not yet connected aren't pinged.
+func (db *DB) monitorPing(timeout time.Duration) error {
+	conn := db.pool()
+	if nil != db.Ctx.Err() || nil == conn {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(db.Ctx, timeout)
+	defer cancel()
+	return conn.PingContext(ctx)
+}
+
+// connectionState reports a connection state change to
+// Config.OnConnectionState.
+func (db *DB) connectionState(connected bool, err error) {
+	if fn := db.Config().OnConnectionState; nil != fn {
+		fn(connected, err)
+	}
+}
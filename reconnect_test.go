@@ -0,0 +1,74 @@
+package db_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReconnectMonitor tests reconnecting with backoff when pings fail and
+// reporting connection state changes.
+func TestReconnectMonitor(t *testing.T) {
+	type state struct {
+		connected bool
+		err       error
+	}
+	states := make(chan state, 10)
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.OnConnectionState = func(connected bool, err error) {
+			states <- state{connected, err}
+		}
+	})
+	opens := stub.Opens()
+
+	pingErr := fmt.Errorf("server closed the connection unexpectedly")
+	stub.mu.Lock()
+	stub.PingErr = pingErr
+	stub.mu.Unlock()
+	database.StartReconnectMonitor(5*time.Millisecond, db.BackoffConfig{BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond})
+
+	select {
+	case s := <-states:
+		assert.Equal(t, state{false, pingErr}, s)
+	case <-time.After(time.Second):
+		t.Fatal("no disconnected state reported")
+	}
+
+	// reconnect attempts fail until the database is back
+	deadline := time.Now().Add(time.Second)
+	for stub.Opens() < opens+2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.GreaterOrEqual(t, stub.Opens(), opens+2)
+	assert.Empty(t, states)
+
+	stub.mu.Lock()
+	stub.PingErr = nil
+	stub.mu.Unlock()
+	select {
+	case s := <-states:
+		assert.Equal(t, state{true, nil}, s)
+	case <-time.After(time.Second):
+		t.Fatal("no connected state reported")
+	}
+
+	// the pools opened by failed attempts and the replaced pool are closed
+	assert.Equal(t, 1, stub.Conns())
+
+	// the monitor stops when the database is closed
+	assert.NoError(t, database.Close())
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, states)
+}
+
+// TestBackoffDelay tests the exponential delays between reconnect attempts.
+func TestBackoffDelay(t *testing.T) {
+	backoff := db.BackoffConfig{BaseDelay: 100 * time.Millisecond, Multiplier: 3, MaxDelay: time.Second}
+	assert.Equal(t, 100*time.Millisecond, backoff.Delay(1))
+	assert.Equal(t, 300*time.Millisecond, backoff.Delay(2))
+	assert.Equal(t, 900*time.Millisecond, backoff.Delay(3))
+	assert.Equal(t, time.Second, backoff.Delay(4))
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bdlm/errors/v2"
+	"github.com/bdlm/log/v2"
+)
+
+// RecordedQuery is a query execution written to Config.RecordQueries, see
+// Replay.
+type RecordedQuery struct {
+	// The time the execution started.
+	Time time.Time `json:"time"`
+
+	// The SQL query string.
+	Query string `json:"query"`
+
+	// The arguments the query was executed with.
+	Args []RecordedArg `json:"args,omitempty"`
+}
+
+// RecordedArg is an argument of a recorded query. Positional arguments have
+// no name.
+type RecordedArg struct {
+	Name  string      `json:"name,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+// recordQuery writes a query execution to Config.RecordQueries. Argument
+// values are hashed unless Config.RecordPlaintext is set.
+func (db *DB) recordQuery(query string, args []interface{}, start time.Time) {
+	record := RecordedQuery{Time: start, Query: query}
+	for _, arg := range args {
+		recorded := RecordedArg{Value: arg}
+		if named, ok := arg.(sql.NamedArg); ok {
+			recorded = RecordedArg{Name: named.Name, Value: named.Value}
+		}
+		if _, ok := recorded.Value.(sql.Out); ok {
+			recorded.Value = nil
+		} else if nil != recorded.Value && (!db.Config().RecordPlaintext || ("" != recorded.Name && db.Config().masked(recorded.Name))) {
+			sum := sha256.Sum256([]byte(fmt.Sprint(recorded.Value)))
+			recorded.Value = "sha256:" + hex.EncodeToString(sum[:])
+		}
+		record.Args = append(record.Args, recorded)
+	}
+
+	line, err := json.Marshal(record)
+	if nil == err {
+		db.recordMu.Lock()
+		_, err = db.Config().RecordQueries.Write(append(line, '\n'))
+		db.recordMu.Unlock()
+	}
+	if nil != err {
+		log.WithError(err).WithFields(log.Fields{
+			"database": db.Config().DatabaseName,
+		}).Warn("unable to record query")
+	}
+}
+
+// Replay executes the queries recorded by Config.RecordQueries, read from r,
+// against db, preserving the time between them. Queries are executed with
+// ExecContext, so results are discarded. Failed queries are logged and
+// replay continues; an error reporting the number of failures is returned
+// once all queries have run. Replay stops when ctx is done.
+func Replay(ctx context.Context, db *DB, r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var first time.Time
+	var start time.Time
+	var total, failed int
+	for {
+		var record RecordedQuery
+		if err := decoder.Decode(&record); io.EOF == err {
+			break
+		} else if nil != err {
+			return errors.Wrap(err, "unable to read recorded query %d", total+1)
+		}
+		total++
+
+		if 1 == total {
+			first, start = record.Time, time.Now()
+		}
+		if delay := record.Time.Sub(first) - time.Since(start); 0 < delay {
+			if err := wait(ctx, delay); nil != err {
+				return err
+			}
+		}
+
+		args := make([]interface{}, len(record.Args))
+		for a, arg := range record.Args {
+			args[a] = replayValue(arg.Value)
+			if "" != arg.Name {
+				args[a] = sql.Named(arg.Name, args[a])
+			}
+		}
+		if _, err := db.ExecContext(ctx, record.Query, args...); nil != err {
+			if nil != ctx.Err() {
+				return ctx.Err()
+			}
+			failed++
+			log.WithError(err).WithFields(log.Fields{
+				"database": db.Config().DatabaseName,
+				"query":    record.Query,
+			}).Warn("replayed query failed")
+		}
+	}
+	if 0 < failed {
+		return errors.Errorf("%d of %d replayed queries failed", failed, total)
+	}
+	return nil
+}
+
+// replayValue converts a recorded argument value decoded from JSON to a
+// driver value. Numbers are converted to int64 if they're integers and to
+// float64 otherwise.
+func replayValue(value interface{}) interface{} {
+	number, ok := value.(json.Number)
+	if !ok {
+		return value
+	}
+	if v, err := number.Int64(); nil == err {
+		return v
+	}
+	v, _ := number.Float64()
+	return v
+}
@@ -0,0 +1,103 @@
+package db_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRecordQueries tests recording executed queries and replaying them.
+func TestRecordQueries(t *testing.T) {
+	var recorded bytes.Buffer
+	database, _ := newStubDB(t, func(cfg *db.Config) {
+		cfg.MaskColumns = []string{"password"}
+		cfg.RecordPlaintext = true
+		cfg.RecordQueries = &recorded
+	})
+
+	stmt, err := database.Prepare("UPDATE users SET password = :password WHERE id = :id")
+	assert.NoError(t, err)
+	_, err = stmt.Bind("password", "hunter2").Bind("id", 1).Exec()
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Commit())
+	time.Sleep(20 * time.Millisecond)
+	_, err = database.Exec("DELETE FROM sessions WHERE user_id = ?", 1)
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(recorded.String()), "\n")
+	assert.Len(t, lines, 2)
+	var record db.RecordedQuery
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, "UPDATE users SET password = :password WHERE id = :id", record.Query)
+	assert.Equal(t, "password", record.Args[0].Name)
+	assert.True(t, strings.HasPrefix(record.Args[0].Value.(string), "sha256:"))
+	assert.NotContains(t, recorded.String(), "hunter2")
+	assert.Equal(t, db.RecordedArg{Name: "id", Value: float64(1)}, record.Args[1])
+
+	// replay against another database
+	var mu sync.Mutex
+	var replayed []string
+	target, stub := newStubDB(t)
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		values := []string{}
+		for _, arg := range args {
+			values = append(values, fmt.Sprintf("%s=%v", arg.Name, arg.Value))
+		}
+		replayed = append(replayed, query+" "+strings.Join(values, ","))
+		return driver.RowsAffected(1), nil
+	}
+	start := time.Now()
+	assert.NoError(t, db.Replay(context.Background(), target, &recorded))
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	assert.Len(t, replayed, 2)
+	assert.True(t, strings.HasPrefix(replayed[0], "UPDATE users SET password = :password WHERE id = :id password=sha256:"))
+	assert.True(t, strings.HasSuffix(replayed[0], ",id=1"))
+	assert.Equal(t, "DELETE FROM sessions WHERE user_id = ? =1", replayed[1])
+
+	// failed queries are reported
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		return nil, fmt.Errorf("relation does not exist")
+	}
+	err = db.Replay(context.Background(), target, strings.NewReader(lines[1]+"\n"))
+	assert.EqualError(t, err, "1 of 1 replayed queries failed")
+}
+
+// TestRecordQueriesRedacted tests that recorded argument values are hashed
+// unless Config.RecordPlaintext is set.
+func TestRecordQueriesRedacted(t *testing.T) {
+	var recorded bytes.Buffer
+	database, _ := newStubDB(t, func(cfg *db.Config) {
+		cfg.RecordQueries = &recorded
+	})
+
+	_, err := database.Exec("UPDATE users SET api_key = ? WHERE id = ?", "s3cr3t-token", 1)
+	assert.NoError(t, err)
+	_, err = database.Exec("UPDATE users SET deleted_at = ? WHERE id = ?", nil, 1)
+	assert.NoError(t, err)
+
+	assert.NotContains(t, recorded.String(), "s3cr3t-token")
+	lines := strings.Split(strings.TrimSpace(recorded.String()), "\n")
+	assert.Len(t, lines, 2)
+	var record db.RecordedQuery
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Len(t, record.Args, 2)
+	for _, arg := range record.Args {
+		assert.True(t, strings.HasPrefix(arg.Value.(string), "sha256:"))
+	}
+
+	// NULLs don't need hashing
+	record = db.RecordedQuery{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &record))
+	assert.Nil(t, record.Args[0].Value)
+}
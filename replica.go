@@ -0,0 +1,163 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bdlm/errors/v2"
+	"github.com/bdlm/log/v2"
+)
+
+// replicaLagTTL is how long a read replica's lag is cached, see
+// Config.MaxReplicaLag.
+const replicaLagTTL = time.Second
+
+// routeHintRegex matches a `/* route: primary */` or `/* route: replica */`
+// query hint.
+var routeHintRegex = regexp.MustCompile(`(?i)/\*\s*route:\s*(primary|replica)\s*\*/\s*`)
+
+// QueryRead executes a read-only query on the read replica, see
+// QueryReadContext.
+func (db *DB) QueryRead(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.QueryReadContext(db.Ctx, query, args...)
+}
+
+// QueryReadContext executes a read-only query on Config.ReadReplica, or on
+// the database itself if no replica is configured or the replica lags behind
+// by more than Config.MaxReplicaLag.
+//
+// A `/* route: primary */` hint in the query sends it to the database itself
+// instead, i.e. to read a row just written, and a `/* route: replica */` hint
+// sends it to the replica regardless of its lag. Hints are also honored by
+// Exec, Query, QueryRow, and Prepare, which otherwise use the database
+// itself. The hint is removed from the query before it's sent.
+func (db *DB) QueryReadContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	target, query := db.route(query)
+	if nil == target {
+		target = db.reader(ctx)
+	}
+	return target.QueryContext(ctx, query, args...)
+}
+
+// route removes a route hint from the query and returns the database it
+// selects, or nil if the query has no hint. A replica hint selects the
+// database itself if no Config.ReadReplica is configured.
+func (db *DB) route(query string) (*DB, string) {
+	match := routeHintRegex.FindStringSubmatchIndex(query)
+	if nil == match {
+		return nil, query
+	}
+	stripped := strings.TrimSpace(query[:match[0]] + query[match[1]:])
+	if strings.EqualFold("replica", query[match[2]:match[3]]) && nil != db.Config().ReadReplica {
+		return db.Config().ReadReplica, stripped
+	}
+	return db, stripped
+}
+
+// reader returns the database to send read-only queries to.
+func (db *DB) reader(ctx context.Context) *DB {
+	cfg := db.Config()
+	if nil == cfg.ReadReplica {
+		return db
+	}
+	if 0 >= cfg.MaxReplicaLag {
+		return cfg.ReadReplica
+	}
+
+	db.replicaMu.Lock()
+	defer db.replicaMu.Unlock()
+	if time.Since(db.replicaChecked) >= replicaLagTTL {
+		lag, err := cfg.ReadReplica.ReplicaLag(ctx)
+		db.replicaChecked = time.Now()
+		db.replicaLagging = nil != err || lag > cfg.MaxReplicaLag
+		if db.replicaLagging {
+			entry := log.WithFields(log.Fields{
+				"database": cfg.DatabaseName,
+				"lag":      lag.String(),
+				"max_lag":  cfg.MaxReplicaLag.String(),
+			})
+			if nil != err {
+				entry = entry.WithError(err)
+			}
+			entry.Warn("read replica is lagging, reading from the primary")
+		}
+	}
+	if db.replicaLagging {
+		return db
+	}
+	return cfg.ReadReplica
+}
+
+// ReplicaLag returns how far the database, a read replica, lags behind its
+// primary, so callers can fall back to the primary when the lag exceeds a
+// threshold. The lag is read from the driver's replication status:
+//
+//   - "mysql": `Seconds_Behind_Master` from `SHOW SLAVE STATUS`.
+//   - "postgres": the time since `pg_last_xact_replay_timestamp()`.
+//
+// An error is returned if the database isn't replicating, as when it's a
+// primary or replication is stopped.
+func (db *DB) ReplicaLag(ctx context.Context) (time.Duration, error) {
+	var query string
+	switch db.Config().DriverType {
+	case "mysql":
+		query = "SHOW SLAVE STATUS"
+	case "postgres":
+		query = "SELECT EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())"
+	default:
+		return 0, errors.Errorf("replica lag is not supported for driver type '%s'", db.Config().DriverType)
+	}
+
+	if err := db.breakerAllow(); nil != err {
+		return 0, err
+	}
+	if err := db.lazyConnect(); nil != err {
+		db.breakerRecord(err)
+		return 0, err
+	}
+	db.logQuery(ctx, query)
+	start := time.Now()
+	span := db.startSpan(ctx, "query", query)
+	rows, err := db.pool().QueryContext(ctx, query)
+	db.endSpan(span, err)
+	db.observe(ctx, query, nil, start, err)
+	db.breakerRecord(err)
+	if nil != err {
+		return 0, errors.Wrap(err, "unable to read replica lag")
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); nil != err {
+			return 0, errors.Wrap(err, "unable to read replica lag")
+		}
+		return 0, errors.New("the database is not a replica")
+	}
+	columns, err := rows.Columns()
+	if nil != err {
+		return 0, errors.Wrap(err, "failed to list result columns")
+	}
+	values := make([]sql.NullFloat64, len(columns))
+	targets := make([]interface{}, len(columns))
+	lag := -1
+	for a, column := range columns {
+		targets[a] = new(interface{})
+		if 1 == len(columns) || strings.EqualFold("Seconds_Behind_Master", column) {
+			targets[a] = &values[a]
+			lag = a
+		}
+	}
+	if 0 > lag {
+		return 0, errors.New("the replication status has no Seconds_Behind_Master column")
+	}
+	if err := rows.Scan(targets...); nil != err {
+		return 0, errors.Wrap(err, "failed to scan result values")
+	}
+	if !values[lag].Valid {
+		return 0, errors.New("the database is not replicating")
+	}
+	return time.Duration(values[lag].Float64 * float64(time.Second)), nil
+}
@@ -0,0 +1,168 @@
+package db_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReplicaLag tests reading the replication lag per driver.
+func TestReplicaLag(t *testing.T) {
+	tests := []struct {
+		driverType string
+		rows       *stubRows
+		expect     time.Duration
+		query      string
+	}{
+		{
+			"postgres",
+			newStubRows([]string{"extract"}, []driver.Value{1.5}),
+			1500 * time.Millisecond,
+			"SELECT EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())",
+		},
+		{
+			"mysql",
+			newStubRows(
+				[]string{"Slave_IO_State", "Master_Host", "Seconds_Behind_Master"},
+				[]driver.Value{"Waiting for master to send event", "primary", int64(12)},
+			),
+			12 * time.Second,
+			"SHOW SLAVE STATUS",
+		},
+	}
+
+	for _, test := range tests {
+		rows := test.rows
+		database, stub := newStubDB(t, func(cfg *db.Config) {
+			cfg.DriverType = test.driverType
+		})
+		stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+			assert.Equal(t, test.query, query)
+			return rows, nil
+		}
+
+		lag, err := database.ReplicaLag(context.Background())
+		assert.NoError(t, err, test.driverType)
+		assert.Equal(t, test.expect, lag, test.driverType)
+
+		// primaries and stopped replicas aren't replicating
+		rows = newStubRows([]string{"extract"}, []driver.Value{nil})
+		if "mysql" == test.driverType {
+			rows = newStubRows([]string{"Slave_IO_State", "Seconds_Behind_Master"})
+		}
+		_, err = database.ReplicaLag(context.Background())
+		assert.Error(t, err, test.driverType)
+	}
+
+	// unsupported drivers
+	database, _ := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "oracle"
+	})
+	_, err := database.ReplicaLag(context.Background())
+	assert.EqualError(t, err, "replica lag is not supported for driver type 'oracle'")
+}
+
+// TestQueryReadContext tests routing reads to the primary when the read
+// replica lags.
+func TestQueryReadContext(t *testing.T) {
+	tests := []struct {
+		lag     interface{}
+		primary bool
+	}{
+		{0.5, false},
+		{30.0, true},
+		{nil, true},
+	}
+
+	for _, test := range tests {
+		lag := test.lag
+		replica, replicaStub := newStubDB(t, func(cfg *db.Config) {
+			cfg.DriverType = "postgres"
+		})
+		replicaStub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+			if "SELECT EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())" == query {
+				return newStubRows([]string{"extract"}, []driver.Value{lag}), nil
+			}
+			return newStubRows([]string{"id"}, []driver.Value{int64(1)}), nil
+		}
+		database, stub := newStubDB(t, func(cfg *db.Config) {
+			cfg.DriverType = "postgres"
+			cfg.MaxReplicaLag = 5 * time.Second
+			cfg.ReadReplica = replica
+		})
+
+		rows, err := database.QueryReadContext(context.Background(), "SELECT id FROM users")
+		assert.NoError(t, err)
+		assert.NoError(t, rows.Close())
+		assert.Equal(t, test.primary, containsEntry(stub.Log(), "query: SELECT id FROM users", 1), test.lag)
+		assert.Equal(t, !test.primary, containsEntry(replicaStub.Log(), "query: SELECT id FROM users", 1), test.lag)
+
+		// the lag is cached
+		rows, err = database.QueryReadContext(context.Background(), "SELECT id FROM users")
+		assert.NoError(t, err)
+		assert.NoError(t, rows.Close())
+		assert.True(t, containsEntry(replicaStub.Log(), "query: SELECT EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())", 1))
+		assert.False(t, containsEntry(replicaStub.Log(), "query: SELECT EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())", 2))
+	}
+
+	// reads go to the replica without a lag threshold
+	replica, replicaStub := newStubDB(t)
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.ReadReplica = replica
+	})
+	rows, err := database.QueryRead("SELECT id FROM users")
+	assert.NoError(t, err)
+	assert.NoError(t, rows.Close())
+	assert.True(t, containsEntry(replicaStub.Log(), "query: SELECT id FROM users", 1))
+	assert.False(t, containsEntry(stub.Log(), "query: SELECT id FROM users", 1))
+}
+
+// TestRouteHint tests overriding read/write routing with query hints.
+func TestRouteHint(t *testing.T) {
+	replica, replicaStub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "postgres"
+	})
+	replicaStub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		if "SELECT EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())" == query {
+			return newStubRows([]string{"extract"}, []driver.Value{30.0}), nil
+		}
+		return newStubRows([]string{"id"}, []driver.Value{int64(1)}), nil
+	}
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "postgres"
+		cfg.MaxReplicaLag = 5 * time.Second
+		cfg.ReadReplica = replica
+	})
+
+	// a replica hint routes a query to the replica
+	rows, err := database.QueryContext(context.Background(), "/* route: replica */ SELECT id FROM users")
+	assert.NoError(t, err)
+	assert.NoError(t, rows.Close())
+	assert.True(t, containsEntry(replicaStub.Log(), "query: SELECT id FROM users", 1))
+	assert.False(t, containsEntry(stub.Log(), "query: SELECT id FROM users", 1))
+
+	stmt, err := database.Prepare("SELECT id FROM users /* route: replica */")
+	assert.NoError(t, err)
+	rows, err = stmt.Query()
+	assert.NoError(t, err)
+	assert.NoError(t, rows.Close())
+	assert.NoError(t, stmt.Close())
+	assert.True(t, containsEntry(replicaStub.Log(), "query: SELECT id FROM users", 2))
+
+	// even when it lags
+	rows, err = database.QueryReadContext(context.Background(), "/* ROUTE: REPLICA */ SELECT id FROM users")
+	assert.NoError(t, err)
+	assert.NoError(t, rows.Close())
+	assert.True(t, containsEntry(replicaStub.Log(), "query: SELECT id FROM users", 3))
+
+	// a primary hint forces the primary
+	rows, err = database.QueryReadContext(context.Background(), "/* route: primary */ SELECT id FROM users")
+	assert.NoError(t, err)
+	assert.NoError(t, rows.Close())
+	assert.True(t, containsEntry(stub.Log(), "query: SELECT id FROM users", 1))
+	assert.False(t, containsEntry(replicaStub.Log(), "query: SELECT id FROM users", 4))
+}
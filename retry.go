@@ -0,0 +1,194 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"time"
+
+	"github.com/bdlm/errors/v2"
+	"github.com/bdlm/log/v2"
+)
+
+// RetryPolicy configures retrying statement executions that fail with a
+// transient error, see Config.RetryPolicy. The zero value disables retries.
+type RetryPolicy struct {
+	// The maximum number of times a statement is executed, including the
+	// first attempt. Retries are disabled if less than 2.
+	MaxAttempts int
+
+	// The delay before the first retry, multiplied by Multiplier for each
+	// further retry.
+	BaseDelay time.Duration
+
+	// Optional, the factor each delay grows by, i.e. 1.5. Defaults to 2.
+	Multiplier float64
+
+	// Optional, the maximum delay between retries. Unlimited if zero.
+	MaxDelay time.Duration
+
+	// Optional, the fraction of each delay randomly added or subtracted so
+	// that clients don't retry in lockstep, i.e. 0.2 for ±20%. Defaults to
+	// Config.RetryJitter.
+	Jitter float64
+
+	// Optional, reports whether a failed execution may be retried. Defaults
+	// to retrying connection failures (see ErrorClassConnection).
+	IsRetryable func(error) bool
+}
+
+// Delay returns the time to wait before the given retry, starting from 1:
+// BaseDelay*Multiplier^(retry-1), limited to MaxDelay, then randomized by
+// Jitter.
+func (policy RetryPolicy) Delay(retry int) time.Duration {
+	multiplier := policy.Multiplier
+	if 0 >= multiplier {
+		multiplier = 2
+	}
+	delay := float64(policy.BaseDelay)
+	for a := 1; a < retry && (0 >= policy.MaxDelay || delay < float64(policy.MaxDelay)); a++ {
+		delay *= multiplier
+	}
+	if 0 < policy.MaxDelay && delay > float64(policy.MaxDelay) {
+		delay = float64(policy.MaxDelay)
+	}
+	if 0 < policy.Jitter {
+		delay += (rand.Float64()*2 - 1) * policy.Jitter * delay
+	}
+	return time.Duration(delay)
+}
+
+// retryPolicy returns the RetryPolicy with its defaults applied.
+func (cfg *Config) retryPolicy() RetryPolicy {
+	policy := cfg.RetryPolicy
+	if 0 == policy.Jitter {
+		policy.Jitter = cfg.RetryJitter
+	}
+	return policy
+}
+
+// txRetryPolicy returns the delays between TransactionRetry attempts, see
+// Config.TxRetryDelay.
+func (cfg *Config) txRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		BaseDelay:  cfg.TxRetryDelay,
+		Multiplier: cfg.RetryPolicy.Multiplier,
+		MaxDelay:   cfg.RetryPolicy.MaxDelay,
+		Jitter:     cfg.RetryJitter,
+	}
+}
+
+// retryExhausted reports whether waiting delay before retrying an operation
+// that started at start would exceed Config.RetryMaxElapsed.
+func (cfg *Config) retryExhausted(start time.Time, delay time.Duration) bool {
+	return 0 < cfg.RetryMaxElapsed && time.Since(start)+delay > cfg.RetryMaxElapsed
+}
+
+// wait sleeps for delay, returning early with an error if ctx is done.
+func wait(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryable reports whether a failed execution may be retried.
+func (policy RetryPolicy) retryable(err error) bool {
+	if nil != policy.IsRetryable {
+		return policy.IsRetryable(err)
+	}
+	return ErrorClassConnection == ClassifyError(err)
+}
+
+// retry runs an execution of the statement, retrying it according to
+// Config.RetryPolicy. Before each retry the statement's transaction is
+// replaced and the statement prepared again, since the failed connection
+// can't be reused. Retries stop once Config.RetryMaxElapsed is exceeded.
+// Only statements that own their transaction and haven't done any work in
+// it before, whether through Exec, Query, the batch helpers or Tx, are
+// retried, so no earlier work in the abandoned transaction is lost.
+func (statement *Statement) retry(ctx context.Context, fn func() error) error {
+	policy := statement.db.Config().retryPolicy()
+	fresh := nil == statement.tx && nil == statement.result && nil == statement.rows && !statement.executed
+
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if nil == err || !fresh || attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			return err
+		}
+
+		delay := policy.Delay(attempt)
+		if statement.db.Config().retryExhausted(start, delay) {
+			return err
+		}
+		if ErrorClassDeadlock == ClassifyError(err) {
+			statement.db.logDeadlockRetry(err, statement.sql, attempt, delay)
+		} else {
+			log.WithError(err).WithFields(log.Fields{
+				"attempt":  attempt,
+				"database": statement.db.Config().DatabaseName,
+				"delay":    delay.String(),
+				"query":    statement.sql,
+			}).Warn("statement failed, retrying")
+		}
+
+		if err2 := wait(ctx, delay); nil != err2 {
+			return errors.WrapE(err, err2)
+		}
+
+		if err2 := statement.reprepare(); nil != err2 {
+			return errors.WrapE(err, err2)
+		}
+	}
+}
+
+// logDeadlockRetry logs a deadlock that's about to be retried at the warning
+// level, with the operation and table of the query that deadlocked if it's
+// known, so contention hotspots can be found.
+func (db *DB) logDeadlockRetry(err error, query string, attempt int, delay time.Duration) {
+	fields := log.Fields{
+		"attempt":  attempt,
+		"database": db.Config().DatabaseName,
+		"delay":    delay.String(),
+	}
+	if "" != query {
+		fields["operation"], fields["table"] = ParseStatement(query)
+		fields["query"] = query
+	}
+	log.WithError(err).WithFields(fields).Warn("deadlock detected, retrying")
+}
+
+// reprepare replaces the statement's transaction and prepares the statement
+// again in the new transaction.
+func (statement *Statement) reprepare() error {
+	if nil != statement.stmt {
+		_ = statement.stmt.Close()
+	}
+	_ = statement.txn.Rollback()
+	statement.db.untrackTx(statement.txn)
+	statement.db.endWrites(statement.txn, false)
+
+	txn, err := statement.db.BeginTx(statement.ctx, statement.opts)
+	if nil != err {
+		return errors.Wrap(err, "unable to initialize database transaction")
+	}
+
+	var stmt *sql.Stmt
+	if statement.db.Config().serverPrepare() {
+		if stmt, err = statement.db.prepare(statement.ctx, txn, statement.sql); nil != err {
+			_ = txn.Rollback()
+			return errors.Wrap(err, "error preparing statement")
+		}
+	}
+	statement.db.trackTx(statement.ctx, txn)
+
+	statement.done = false
+	statement.stmt = stmt
+	statement.txn = txn
+	return nil
+}
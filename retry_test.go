@@ -0,0 +1,315 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bdlm/db"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRetryPolicy tests retrying statements that fail with transient errors.
+func TestRetryPolicy(t *testing.T) {
+	failures := 0
+	var execErr error
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.RetryPolicy = db.RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Millisecond,
+			MaxDelay:    2 * time.Millisecond,
+			Jitter:      0.5,
+		}
+	})
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		if 0 < failures {
+			failures--
+			return nil, execErr
+		}
+		return driver.RowsAffected(1), nil
+	}
+
+	// transient failures are retried in a new transaction
+	failures = 2
+	execErr = fmt.Errorf("read tcp: connection reset by peer")
+	stmt, err := database.Prepare("UPDATE users SET active = :active")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Bind("active", 1).Exec()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"begin",
+		"prepare: UPDATE users SET active = :active",
+		"exec: UPDATE users SET active = :active",
+		"rollback",
+		"begin",
+		"prepare: UPDATE users SET active = :active",
+		"exec: UPDATE users SET active = :active",
+		"rollback",
+		"begin",
+		"prepare: UPDATE users SET active = :active",
+		"exec: UPDATE users SET active = :active",
+	}, stub.Log())
+
+	// attempts are limited
+	failures = 5
+	stmt, err = database.Prepare("DELETE FROM sessions")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Exec()
+	assert.Error(t, err)
+	assert.Equal(t, 2, failures)
+
+	// other errors fail fast
+	failures = 5
+	execErr = fmt.Errorf("syntax error")
+	stmt, err = database.Prepare("DELETE FROM sessions")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Exec()
+	assert.Error(t, err)
+	assert.Equal(t, 4, failures)
+}
+
+// TestRetryPolicyAfterBatch tests that a statement isn't retried once a
+// batch has been executed in its transaction.
+func TestRetryPolicyAfterBatch(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "postgres"
+		cfg.RetryPolicy = db.RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Millisecond,
+		}
+	})
+	execs := 0
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		execs++
+		if 1 < execs {
+			return nil, fmt.Errorf("read tcp: connection reset by peer")
+		}
+		return driver.RowsAffected(1), nil
+	}
+
+	stmt, err := database.Prepare("INSERT INTO users (id, name) VALUES (:id, :name);")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.BindBatch([][]interface{}{{int64(1), "alice"}}).ExecBatch(context.Background())
+	assert.NoError(t, err)
+
+	_, err = stmt.Bind("id", int64(2)).Bind("name", "bob").Exec()
+	assert.Error(t, err)
+	assert.Equal(t, 2, execs)
+	begins := 0
+	for _, entry := range stub.Log() {
+		if "begin" == entry {
+			begins++
+		}
+	}
+	assert.Equal(t, 1, begins)
+}
+
+// TestRetryPolicyDefault tests that statements aren't retried by default.
+func TestRetryPolicyDefault(t *testing.T) {
+	attempts := 0
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		attempts++
+		return nil, fmt.Errorf("connection refused")
+	}
+
+	stmt, err := database.Prepare("SELECT 1")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.QueryContext(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+// TestRetryPolicyDelay tests that retry delays grow by the multiplier and
+// stay within the jittered range.
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := db.RetryPolicy{
+		BaseDelay:  100 * time.Millisecond,
+		Multiplier: 3,
+		MaxDelay:   time.Second,
+	}
+	assert.Equal(t, 100*time.Millisecond, policy.Delay(1))
+	assert.Equal(t, 300*time.Millisecond, policy.Delay(2))
+	assert.Equal(t, 900*time.Millisecond, policy.Delay(3))
+	assert.Equal(t, time.Second, policy.Delay(4))
+
+	// the multiplier defaults to 2
+	policy.Multiplier = 0
+	assert.Equal(t, 400*time.Millisecond, policy.Delay(3))
+
+	policy.Jitter = 0.25
+	for a := 0; a < 100; a++ {
+		delay := policy.Delay(2)
+		assert.GreaterOrEqual(t, delay, 150*time.Millisecond)
+		assert.LessOrEqual(t, delay, 250*time.Millisecond)
+	}
+}
+
+// TestRetryJitter tests that Config.RetryJitter randomizes retry delays
+// within the jittered range.
+func TestRetryJitter(t *testing.T) {
+	logs := captureLogs(t)
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.RetryJitter = 0.5
+		cfg.RetryPolicy = db.RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   10 * time.Millisecond,
+		}
+	})
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		return nil, fmt.Errorf("read tcp: connection reset by peer")
+	}
+
+	stmt, err := database.Prepare("DELETE FROM sessions")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Exec()
+	assert.Error(t, err)
+
+	entries := logs.Entries("statement failed, retrying")
+	if !assert.Len(t, entries, 2) {
+		return
+	}
+	for a, expect := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond} {
+		delay, err := time.ParseDuration(entries[a].Data["delay"].(string))
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, delay, expect/2)
+		assert.LessOrEqual(t, delay, expect*3/2)
+	}
+}
+
+// TestRetryMaxElapsed tests that retries stop once their total time would
+// exceed Config.RetryMaxElapsed.
+func TestRetryMaxElapsed(t *testing.T) {
+	attempts := 0
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.RetryMaxElapsed = 50 * time.Millisecond
+		cfg.RetryPolicy = db.RetryPolicy{
+			MaxAttempts: 10,
+			BaseDelay:   20 * time.Millisecond,
+		}
+		cfg.TxRetryDelay = 20 * time.Millisecond
+	})
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		attempts++
+		return nil, fmt.Errorf("read tcp: connection reset by peer")
+	}
+
+	stmt, err := database.Prepare("DELETE FROM sessions")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	start := time.Now()
+	_, err = stmt.Exec()
+	assert.Error(t, err)
+
+	// delays of 20ms then 40ms exceed the budget after the second attempt
+	assert.Equal(t, 2, attempts)
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	// transaction retries share the budget
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		attempts++
+		return nil, &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+	}
+	attempts = 0
+	err = database.TransactionRetry(context.Background(), 10, func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE accounts SET balance = balance - 1")
+		return err
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.Contains(t, fmt.Sprintf("%+v", err), "transaction failed after 2 attempts, exceeding 50ms")
+}
+
+// TestTxRetryDelay tests that TransactionRetry retries immediately unless
+// Config.TxRetryDelay is set.
+func TestTxRetryDelay(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.RetryPolicy = db.RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Second,
+		}
+	})
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		return nil, &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+	}
+	fn := func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE accounts SET balance = balance - 1")
+		return err
+	}
+
+	// RetryPolicy delays don't apply
+	start := time.Now()
+	assert.Error(t, database.TransactionRetry(context.Background(), 3, fn))
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	database.Config().TxRetryDelay = 50 * time.Millisecond
+	start = time.Now()
+	assert.Error(t, database.TransactionRetry(context.Background(), 3, fn))
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
+
+// TestDeadlockRetryLog tests logging each deadlock that's retried.
+func TestDeadlockRetryLog(t *testing.T) {
+	logs := captureLogs(t)
+	deadlocks := 0
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.RetryPolicy = db.RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Millisecond,
+			IsRetryable: func(err error) bool {
+				return db.ClassifyError(err).Retryable()
+			},
+		}
+		cfg.TxRetryDelay = 2 * time.Millisecond
+	})
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		if 0 < deadlocks {
+			deadlocks--
+			return nil, &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+		}
+		return driver.RowsAffected(1), nil
+	}
+
+	// statements
+	deadlocks = 1
+	stmt, err := database.Prepare("UPDATE accounts SET balance = balance - 1 WHERE id = :id")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Bind("id", 1).Exec()
+	assert.NoError(t, err)
+
+	entries := logs.Entries("deadlock detected, retrying")
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, 1, entries[0].Data["attempt"])
+		assert.Equal(t, "update", entries[0].Data["operation"])
+		assert.Equal(t, "accounts", entries[0].Data["table"])
+	}
+	assert.Empty(t, logs.Entries("statement failed, retrying"))
+
+	// transactions are delayed by TxRetryDelay
+	logs = captureLogs(t)
+	deadlocks = 2
+	err = database.TransactionRetry(context.Background(), 3, func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE accounts SET balance = balance - 1")
+		return err
+	})
+	assert.NoError(t, err)
+
+	entries = logs.Entries("deadlock detected, retrying")
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, 1, entries[0].Data["attempt"])
+		assert.Equal(t, 2, entries[1].Data["attempt"])
+		assert.Equal(t, "2ms", entries[0].Data["delay"])
+		assert.NotContains(t, entries[0].Data, "operation")
+	}
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/bdlm/errors/v2"
+)
+
+// savepointNameRegex matches valid savepoint names. Names are emitted
+// unquoted, so they're limited to plain identifiers.
+var savepointNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Savepoint marks a savepoint with the given name in the statement's
+// transaction, so work done after it can be undone with RollbackTo without
+// rolling back the whole transaction. Names must be plain identifiers.
+// Requires a "mysql", "oracle", "postgres", "sqlite", or "sqlserver"
+// DriverType.
+func (statement *Statement) Savepoint(name string) error {
+	return statement.savepoint(name, map[string]string{
+		"mysql":     "SAVEPOINT %s",
+		"oracle":    "SAVEPOINT %s",
+		"postgres":  "SAVEPOINT %s",
+		"sqlite":    "SAVEPOINT %s",
+		"sqlserver": "SAVE TRANSACTION %s",
+	})
+}
+
+// RollbackTo rolls back the statement's transaction to the named savepoint,
+// see Savepoint. The savepoint remains valid and can be rolled back to
+// again.
+func (statement *Statement) RollbackTo(name string) error {
+	return statement.savepoint(name, map[string]string{
+		"mysql":     "ROLLBACK TO SAVEPOINT %s",
+		"oracle":    "ROLLBACK TO SAVEPOINT %s",
+		"postgres":  "ROLLBACK TO SAVEPOINT %s",
+		"sqlite":    "ROLLBACK TO SAVEPOINT %s",
+		"sqlserver": "ROLLBACK TRANSACTION %s",
+	})
+}
+
+// ReleaseSavepoint releases the named savepoint, see Savepoint, keeping the
+// work done since it. Oracle and SQL Server have no release statement and
+// release savepoints when the transaction ends, so this is a no-op for them.
+func (statement *Statement) ReleaseSavepoint(name string) error {
+	return statement.savepoint(name, map[string]string{
+		"mysql":     "RELEASE SAVEPOINT %s",
+		"oracle":    "",
+		"postgres":  "RELEASE SAVEPOINT %s",
+		"sqlite":    "RELEASE SAVEPOINT %s",
+		"sqlserver": "",
+	})
+}
+
+// savepoint executes the savepoint statement for the configured DriverType
+// in the statement's transaction.
+func (statement *Statement) savepoint(name string, queries map[string]string) error {
+	driverType := statement.db.Config().DriverType
+	query, ok := queries[driverType]
+	if !ok {
+		statement.lastErr = errors.Errorf("savepoints are not supported for driver type '%s'", driverType)
+		return statement.lastErr
+	}
+	if !savepointNameRegex.MatchString(name) {
+		statement.lastErr = errors.Errorf("invalid savepoint name '%s'", name)
+		return statement.lastErr
+	}
+	if "" == query {
+		return nil
+	}
+
+	_, err := statement.execTxn(statement.ctx, fmt.Sprintf(query, name))
+	return err
+}
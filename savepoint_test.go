@@ -0,0 +1,53 @@
+package db_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSavepoint tests issuing savepoint statements in a statement's
+// transaction.
+func TestSavepoint(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "postgres"
+	})
+
+	stmt, err := database.Prepare("INSERT INTO users (name) VALUES ($1)")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	assert.NoError(t, stmt.Savepoint("batch_1"))
+	_, err = stmt.Exec("alice")
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.RollbackTo("batch_1"))
+	assert.NoError(t, stmt.ReleaseSavepoint("batch_1"))
+	assert.NoError(t, stmt.Commit())
+
+	assert.True(t, containsEntry(stub.Log(), "exec: SAVEPOINT batch_1", 1))
+	assert.True(t, containsEntry(stub.Log(), "exec: ROLLBACK TO SAVEPOINT batch_1", 1))
+	assert.True(t, containsEntry(stub.Log(), "exec: RELEASE SAVEPOINT batch_1", 1))
+
+	err = stmt.Savepoint("batch; DROP TABLE users")
+	assert.Error(t, err)
+	assert.Contains(t, fmt.Sprintf("%+v", err), "invalid savepoint name")
+}
+
+// TestSavepointUnsupported tests that savepoints fail for driver types
+// without savepoint support.
+func TestSavepointUnsupported(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "snowflake"
+	})
+
+	stmt, err := database.Prepare("SELECT 1")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	err = stmt.Savepoint("batch_1")
+	assert.Error(t, err)
+	assert.Contains(t, fmt.Sprintf("%+v", err), "savepoints are not supported for driver type 'snowflake'")
+	assert.False(t, containsEntry(stub.Log(), "exec: SAVEPOINT batch_1", 1))
+}
@@ -0,0 +1,372 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bdlm/errors/v2"
+)
+
+// scanTarget returns the value to pass to sql.Rows.Scan for a destination,
+// wrapping destination types that the database/sql package can't populate
+// directly.
+func scanTarget(dest interface{}) interface{} {
+	switch dest := dest.(type) {
+	case *big.Int:
+		return &bigIntScanner{dest}
+	case *big.Rat:
+		return &bigRatScanner{dest}
+	}
+	return dest
+}
+
+// scanTargets wraps a list of scan destinations with scanTarget. If raw is
+// set, []byte destinations are populated with the driver's memory rather than
+// a copy of it, see Config.UseRawBytes.
+func scanTargets(dest []interface{}, raw bool) []interface{} {
+	targets := make([]interface{}, len(dest))
+	for a, d := range dest {
+		if b, ok := d.(*[]byte); ok && raw {
+			targets[a] = &rawBytesScanner{b}
+			continue
+		}
+		targets[a] = scanTarget(d)
+	}
+	return targets
+}
+
+// scanTargets wraps a list of scan destinations for the current row of the
+// statement's cursor, see scanTargets. *time.Duration destinations are
+// populated from interval columns, see parseInterval. Destinations with a
+// type registered in Config.Converters are populated by the converter, and
+// GeometryScanner destinations for geometry columns are wrapped to receive
+// the raw WKB value.
+func (statement *Statement) scanTargets(dest []interface{}) ([]interface{}, error) {
+	targets := scanTargets(dest, statement.db.Config().UseRawBytes)
+
+	for a, d := range dest {
+		if duration, ok := d.(*time.Duration); ok {
+			targets[a] = &durationScanner{duration, statement.db.Config().DriverType}
+		}
+	}
+
+	if converters := statement.db.Config().Converters; 0 < len(converters) {
+		for a, d := range dest {
+			typ := reflect.TypeOf(d)
+			if nil == typ || reflect.Ptr != typ.Kind() {
+				continue
+			}
+			if convert, ok := converters[typ.Elem()]; ok {
+				targets[a] = &converterScanner{d, convert}
+			}
+		}
+	}
+
+	var types []*sql.ColumnType
+	for a, d := range dest {
+		geometry, ok := d.(GeometryScanner)
+		if !ok {
+			continue
+		}
+		if nil == types {
+			var err error
+			if types, err = statement.rows.ColumnTypes(); nil != err {
+				return nil, errors.Wrap(err, "failed to list result column types")
+			}
+		}
+		if a < len(types) && isGeometryType(types[a].DatabaseTypeName()) {
+			targets[a] = &geometryScanner{geometry}
+		}
+	}
+	return targets, nil
+}
+
+// rawBytesScanner populates a []byte with the driver's memory for the column
+// without copying it. The value is only valid until the next call to Next,
+// Scan, or Close.
+type rawBytesScanner struct {
+	dest *[]byte
+}
+
+// Scan implements sql.Scanner.
+func (scanner *rawBytesScanner) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*scanner.dest = nil
+	case []byte:
+		*scanner.dest = src
+	case string:
+		*scanner.dest = []byte(src)
+	default:
+		return errors.Errorf("cannot scan %T into *[]byte", src)
+	}
+	return nil
+}
+
+// rawValueScanner stores the driver's value for a column without copying
+// []byte values. The value is only valid until the next call to Next, Scan,
+// or Close.
+type rawValueScanner struct {
+	dest *interface{}
+}
+
+// Scan implements sql.Scanner.
+func (scanner *rawValueScanner) Scan(src interface{}) error {
+	*scanner.dest = src
+	return nil
+}
+
+// transformScanner passes column values through Config.ScanTransform before
+// storing them in the destination.
+type transformScanner struct {
+	column    string
+	dest      interface{}
+	transform func(column string, value interface{}) (interface{}, error)
+}
+
+// Scan implements sql.Scanner.
+func (scanner *transformScanner) Scan(src interface{}) error {
+	value, err := scanner.transform(scanner.column, src)
+	if nil != err {
+		return errors.Wrap(err, "failed to transform column '%s'", scanner.column)
+	}
+	if dest, ok := scanner.dest.(sql.Scanner); ok {
+		return dest.Scan(value)
+	}
+	return assign(scanner.dest, value)
+}
+
+// converterScanner populates a destination using a function registered in
+// Config.Converters.
+type converterScanner struct {
+	dest    interface{}
+	convert func(src interface{}) (interface{}, error)
+}
+
+// Scan implements sql.Scanner.
+func (scanner *converterScanner) Scan(src interface{}) error {
+	value, err := scanner.convert(src)
+	if nil != err {
+		return errors.Wrap(err, "failed to convert %T to %T", src, scanner.dest)
+	}
+	return assign(scanner.dest, value)
+}
+
+// assign stores a value in the destination pointed at by dest following the
+// conversion rules of sql.Rows.Scan: values are stored directly if
+// assignable, numbers, bools, and times are formatted into string and byte
+// slice destinations, and text and numbers are parsed into numeric and bool
+// destinations, failing if the value doesn't fit or would lose precision.
+// Byte slices are copied, since the driver may reuse their memory. NULL can
+// only be stored in pointer, interface, slice, and map destinations.
+func assign(dest, value interface{}) error {
+	if scanner, ok := dest.(sql.Scanner); ok {
+		return scanner.Scan(value)
+	}
+	ptr := reflect.ValueOf(dest)
+	if reflect.Ptr != ptr.Kind() || ptr.IsNil() {
+		return errors.Errorf("cannot scan into %T", dest)
+	}
+	elem := ptr.Elem()
+	if b, ok := value.([]byte); ok && nil != b {
+		value = append([]byte{}, b...)
+	}
+
+	if nil == value {
+		switch elem.Kind() {
+		case reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+			elem.Set(reflect.Zero(elem.Type()))
+			return nil
+		}
+		return errors.Errorf("cannot scan NULL into %T", dest)
+	}
+	val := reflect.ValueOf(value)
+	if val.Type().AssignableTo(elem.Type()) {
+		elem.Set(val)
+		return nil
+	}
+	if reflect.Ptr == elem.Kind() {
+		target := reflect.New(elem.Type().Elem())
+		if err := assign(target.Interface(), value); nil != err {
+			return err
+		}
+		elem.Set(target)
+		return nil
+	}
+	if val.Kind() == elem.Kind() && val.Type().ConvertibleTo(elem.Type()) {
+		elem.Set(val.Convert(elem.Type()))
+		return nil
+	}
+
+	text, ok := asString(val)
+	var err error
+	switch elem.Kind() {
+	case reflect.String:
+		if ok {
+			elem.SetString(text)
+			return nil
+		}
+	case reflect.Slice:
+		if ok && reflect.Uint8 == elem.Type().Elem().Kind() {
+			elem.SetBytes([]byte(text))
+			return nil
+		}
+	case reflect.Bool:
+		var b driver.Value
+		if b, err = driver.Bool.ConvertValue(value); nil == err {
+			elem.SetBool(b.(bool))
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !ok {
+			break
+		}
+		var n int64
+		if n, err = strconv.ParseInt(text, 10, elem.Type().Bits()); nil == err {
+			elem.SetInt(n)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if !ok {
+			break
+		}
+		var n uint64
+		if n, err = strconv.ParseUint(text, 10, elem.Type().Bits()); nil == err {
+			elem.SetUint(n)
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if !ok {
+			break
+		}
+		var f float64
+		if f, err = strconv.ParseFloat(text, elem.Type().Bits()); nil == err {
+			elem.SetFloat(f)
+			return nil
+		}
+	}
+	if nil != err {
+		return errors.Wrap(err, "cannot scan %T into %T", value, dest)
+	}
+	return errors.Errorf("cannot scan %T into %T", value, dest)
+}
+
+// asString formats a scanned value as text for assign. Floats are formatted
+// without an exponent or trailing zeros, so integral values parse as
+// integers and fractional ones fail to. Reports false for values that have
+// no text form.
+func asString(val reflect.Value) (string, bool) {
+	if t, ok := val.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339Nano), true
+	}
+	switch val.Kind() {
+	case reflect.String:
+		return val.String(), true
+	case reflect.Slice:
+		if reflect.Uint8 == val.Type().Elem().Kind() {
+			return string(val.Bytes()), true
+		}
+	case reflect.Bool:
+		return strconv.FormatBool(val.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(val.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(val.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(val.Float(), 'f', -1, val.Type().Bits()), true
+	}
+	return "", false
+}
+
+// bigIntScanner populates a big.Int from the driver's numeric
+// representation. NULL values leave the destination unchanged.
+type bigIntScanner struct {
+	dest *big.Int
+}
+
+// Scan implements sql.Scanner.
+func (scanner *bigIntScanner) Scan(src interface{}) error {
+	if nil == src {
+		return nil
+	}
+	rat := new(big.Rat)
+	if err := (&bigRatScanner{rat}).Scan(src); nil != err {
+		return err
+	}
+	if !rat.IsInt() {
+		return errors.Errorf("cannot scan non-integer value %s into *big.Int", rat.FloatString(10))
+	}
+	scanner.dest.Set(rat.Num())
+	return nil
+}
+
+// bigRatScanner populates a big.Rat from the driver's numeric
+// representation. NULL values leave the destination unchanged.
+type bigRatScanner struct {
+	dest *big.Rat
+}
+
+// Scan implements sql.Scanner.
+func (scanner *bigRatScanner) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return scanner.Scan(string(src))
+	case string:
+		if _, ok := scanner.dest.SetString(src); !ok {
+			return errors.Errorf("cannot parse %q as a number", src)
+		}
+	case int64:
+		scanner.dest.SetInt64(src)
+	case float64:
+		if nil == scanner.dest.SetFloat64(src) {
+			return errors.Errorf("cannot scan non-finite value %v into a big number", src)
+		}
+	default:
+		return errors.Errorf("cannot scan %T into a big number", src)
+	}
+	return nil
+}
+
+// lobColumns reports which result columns hold large object values, based on
+// the database type names reported by the driver.
+func lobColumns(rows *sql.Rows) ([]bool, error) {
+	types, err := rows.ColumnTypes()
+	if nil != err {
+		return nil, err
+	}
+	lobs := make([]bool, len(types))
+	for a, typ := range types {
+		name := strings.ToUpper(typ.DatabaseTypeName())
+		lobs[a] = strings.Contains(name, "LOB") || "BFILE" == name || "BYTEA" == name
+	}
+	return lobs, nil
+}
+
+// lobReader returns a reader for a LOB value scanned by MapScan, see
+// Config.LazyLOB. Readers returned by the driver are LOB locators and are
+// returned as is. []byte values have already been copied from the driver's
+// memory by Scan. NULL is returned as nil.
+func lobReader(value interface{}) interface{} {
+	switch value := value.(type) {
+	case nil:
+		return nil
+	case io.ReadCloser:
+		return value
+	case io.Reader:
+		return io.NopCloser(value)
+	case []byte:
+		return io.NopCloser(bytes.NewReader(value))
+	case string:
+		return io.NopCloser(strings.NewReader(value))
+	}
+	return value
+}
@@ -0,0 +1,502 @@
+package db_test
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestScanBigNumbers tests scanning numeric columns into arbitrary precision
+// destinations.
+func TestScanBigNumbers(t *testing.T) {
+	const amount = "123456789012345678901234567890.123456789012345678901"
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"amount", "total"},
+			[]driver.Value{[]byte(amount), "98765432109876543210987654321"},
+			[]driver.Value{nil, nil},
+		), nil
+	}
+
+	stmt, err := database.Prepare("SELECT amount, total FROM ledger")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+
+	expectRat, _ := new(big.Rat).SetString(amount)
+	expectInt, _ := new(big.Int).SetString("98765432109876543210987654321", 10)
+
+	rat, num := new(big.Rat), new(big.Int)
+	assert.True(t, stmt.Next(rat, num))
+	assert.Equal(t, 0, expectRat.Cmp(rat), rat.FloatString(21))
+	assert.Equal(t, 0, expectInt.Cmp(num), num.String())
+
+	// NULL values leave the destination unchanged
+	assert.True(t, stmt.Next(rat, num))
+	assert.Equal(t, 0, expectRat.Cmp(rat))
+	assert.Equal(t, 0, expectInt.Cmp(num))
+	assert.NoError(t, stmt.LastErr())
+
+	// struct fields
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows([]string{"amount"}, []driver.Value{amount}), nil
+	}
+	stmt, err = database.Prepare("SELECT amount FROM ledger")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+
+	var row struct {
+		Amount big.Rat `db:"amount"`
+	}
+	assert.True(t, stmt.Rows().Next())
+	assert.NoError(t, stmt.StructScan(&row))
+	assert.Equal(t, 0, expectRat.Cmp(&row.Amount))
+}
+
+// TestMapScanLazyLOB tests streaming LOB columns from MapScan results.
+func TestMapScanLazyLOB(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.LazyLOB = true
+	})
+	body := []byte("large document contents")
+	locator := strings.NewReader("streamed document contents")
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"id", "body", "thumbnail"},
+			[]driver.Value{int64(1), body, nil},
+			[]driver.Value{int64(2), locator, nil},
+		).WithTypes("NUMBER", "CLOB", "BLOB"), nil
+	}
+
+	stmt, err := database.Prepare("SELECT id, body, thumbnail FROM documents")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+
+	row := map[string]interface{}{}
+	assert.True(t, stmt.MapNext(row))
+	assert.Equal(t, int64(1), row["id"])
+	assert.Nil(t, row["thumbnail"])
+
+	// values read by the driver are copied
+	reader, ok := row["body"].(io.ReadCloser)
+	assert.True(t, ok)
+	copy(body, "LARGE")
+	contents, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.NoError(t, reader.Close())
+	assert.Equal(t, "large document contents", string(contents))
+
+	// LOB locators are streamed
+	assert.True(t, stmt.MapNext(row))
+	reader, ok = row["body"].(io.ReadCloser)
+	assert.True(t, ok)
+	contents, err = io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "streamed document contents", string(contents))
+	assert.Equal(t, 0, locator.Len())
+}
+
+// TestScanRawBytes tests scanning []byte columns without copying.
+func TestScanRawBytes(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.UseRawBytes = true
+	})
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"id", "payload"},
+			[]driver.Value{int64(1), []byte("first")},
+			[]driver.Value{int64(2), []byte("second")},
+			[]driver.Value{int64(3), nil},
+		), nil
+	}
+
+	stmt, err := database.Prepare("SELECT id, payload FROM events")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+
+	var id int64
+	var payload []byte
+	assert.True(t, stmt.Next(&id, &payload))
+	assert.Equal(t, "first", string(payload))
+
+	var row struct {
+		ID      int64  `db:"id"`
+		Payload []byte `db:"payload"`
+	}
+	assert.True(t, stmt.Rows().Next())
+	assert.NoError(t, stmt.StructScan(&row))
+	assert.Equal(t, "second", string(row.Payload))
+
+	values := map[string]interface{}{}
+	assert.True(t, stmt.MapNext(values))
+	assert.Nil(t, values["payload"])
+	assert.NoError(t, stmt.LastErr())
+}
+
+// BenchmarkScanBytes compares scanning []byte columns with and without
+// Config.UseRawBytes.
+func BenchmarkScanBytes(b *testing.B) {
+	payload := make([]byte, 4096)
+	for _, raw := range []bool{false, true} {
+		b.Run(fmt.Sprintf("UseRawBytes=%t", raw), func(b *testing.B) {
+			database, stub := newStubDB(b, func(cfg *db.Config) {
+				cfg.UseRawBytes = raw
+			})
+			stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+				rows := make([][]driver.Value, b.N)
+				for a := range rows {
+					rows[a] = []driver.Value{payload, payload, payload}
+				}
+				return newStubRows([]string{"a", "b", "c"}, rows...), nil
+			}
+
+			stmt, err := database.Prepare("SELECT a, b, c FROM blobs")
+			if nil != err {
+				b.Fatal(err)
+			}
+			defer stmt.Close()
+			if _, err = stmt.Query(); nil != err {
+				b.Fatal(err)
+			}
+
+			var c1, c2, c3 []byte
+			b.ReportAllocs()
+			b.ResetTimer()
+			for stmt.Next(&c1, &c2, &c3) {
+			}
+			if err = stmt.LastErr(); nil != err {
+				b.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestScanTransform tests transforming column values read by MapScan and
+// StructScan.
+func TestScanTransform(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.ScanTransform = func(column string, value interface{}) (interface{}, error) {
+			if "secret" != column {
+				return value, nil
+			}
+			b, ok := value.([]byte)
+			if !ok {
+				return nil, fmt.Errorf("unexpected %T", value)
+			}
+			return strings.ToUpper(string(b)), nil
+		}
+	})
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"id", "name", "secret"},
+			[]driver.Value{int64(1), "alice", []byte("first")},
+			[]driver.Value{int64(2), "bob", []byte("second")},
+			[]driver.Value{int64(3), "carol", int64(3)},
+		), nil
+	}
+
+	stmt, err := database.Prepare("SELECT id, name, secret FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+
+	values := map[string]interface{}{}
+	assert.True(t, stmt.MapNext(values))
+	assert.Equal(t, map[string]interface{}{"id": int64(1), "name": "alice", "secret": "FIRST"}, values)
+
+	var row struct {
+		ID     int    `db:"id"`
+		Name   string `db:"name"`
+		Secret string `db:"secret"`
+	}
+	assert.True(t, stmt.StructNext(&row))
+	assert.Equal(t, 2, row.ID)
+	assert.Equal(t, "bob", row.Name)
+	assert.Equal(t, "SECOND", row.Secret)
+
+	// transform errors abort the scan
+	assert.False(t, stmt.StructNext(&row))
+	assert.Error(t, stmt.LastErr())
+	assert.Contains(t, fmt.Sprintf("%+v", stmt.LastErr()), "failed to transform column 'secret'")
+}
+
+// TestScanTransformConversions tests that transformed values are stored
+// with the conversion rules of sql.Rows.Scan.
+func TestScanTransformConversions(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.ScanTransform = func(column string, value interface{}) (interface{}, error) {
+			return value, nil
+		}
+	})
+	payload := []byte("payload")
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"count", "label", "data"},
+			[]driver.Value{[]byte("42"), int64(7), payload},
+			[]driver.Value{42.5, "x", nil},
+		), nil
+	}
+
+	stmt, err := database.Prepare("SELECT count, label, data FROM items")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+
+	var row struct {
+		Count int    `db:"count"`
+		Label string `db:"label"`
+		Data  []byte `db:"data"`
+	}
+	assert.True(t, stmt.StructNext(&row))
+	assert.Equal(t, 42, row.Count)
+	assert.Equal(t, "7", row.Label)
+	assert.Equal(t, []byte("payload"), row.Data)
+	payload[0] = 'P'
+	assert.Equal(t, []byte("payload"), row.Data)
+
+	// lossy conversions fail
+	assert.False(t, stmt.StructNext(&row))
+	assert.Contains(t, fmt.Sprintf("%+v", stmt.LastErr()), "cannot scan float64 into *int")
+
+	// NULL can't be stored in a string
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows([]string{"count", "label", "data"}, []driver.Value{int64(1), nil, nil}), nil
+	}
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+	assert.False(t, stmt.StructNext(&row))
+	assert.Contains(t, fmt.Sprintf("%+v", stmt.LastErr()), "cannot scan NULL into *string")
+}
+
+// TestScanBytesAsString tests that MapScan stores []byte values as strings
+// only when Config.ScanBytesAsString is set.
+func TestScanBytesAsString(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		database, stub := newStubDB(t, func(cfg *db.Config) {
+			cfg.ScanBytesAsString = enabled
+		})
+		stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+			return newStubRows(
+				[]string{"id", "name", "note"},
+				[]driver.Value{int64(1), []byte("alice"), nil},
+			), nil
+		}
+
+		stmt, err := database.Prepare("SELECT id, name, note FROM users")
+		assert.NoError(t, err)
+		_, err = stmt.Query()
+		assert.NoError(t, err)
+
+		values := map[string]interface{}{}
+		assert.True(t, stmt.MapNext(values))
+		if enabled {
+			assert.Equal(t, map[string]interface{}{"id": int64(1), "name": "alice", "note": nil}, values)
+		} else {
+			assert.Equal(t, map[string]interface{}{"id": int64(1), "name": []byte("alice"), "note": nil}, values)
+		}
+		stmt.Close()
+	}
+}
+
+// TestScanDuplicateColumns tests storing columns that share a name under
+// distinct keys.
+func TestScanDuplicateColumns(t *testing.T) {
+	tests := []struct {
+		renamer func(idx int, name string) string
+		expect  map[string]interface{}
+	}{
+		{
+			nil,
+			map[string]interface{}{"id": int64(1), "name": "alice", "id_2": int64(10), "name_2": "admins"},
+		},
+		{
+			func(idx int, name string) string {
+				return []string{"u", "u", "g", "g"}[idx] + "." + name
+			},
+			map[string]interface{}{"u.id": int64(1), "u.name": "alice", "g.id": int64(10), "g.name": "admins"},
+		},
+	}
+
+	for _, test := range tests {
+		database, stub := newStubDB(t, func(cfg *db.Config) {
+			cfg.ColumnRenamer = test.renamer
+		})
+		stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+			return newStubRows(
+				[]string{"id", "name", "id", "name"},
+				[]driver.Value{int64(1), "alice", int64(10), "admins"},
+			), nil
+		}
+
+		stmt, err := database.Prepare("SELECT u.id, u.name, g.id, g.name FROM users u JOIN groups g ON g.id = u.group_id")
+		assert.NoError(t, err)
+		_, err = stmt.Query()
+		assert.NoError(t, err)
+
+		values := map[string]interface{}{}
+		assert.True(t, stmt.MapNext(values))
+		assert.Equal(t, test.expect, values)
+		stmt.Close()
+	}
+
+	// renamed keys must be unique
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.ColumnRenamer = func(idx int, name string) string { return name }
+	})
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows([]string{"id", "id"}, []driver.Value{int64(1), int64(10)}), nil
+	}
+	stmt, err := database.Prepare("SELECT u.id, g.id FROM users u, groups g")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+	assert.False(t, stmt.MapNext(map[string]interface{}{}))
+	assert.Error(t, stmt.LastErr())
+}
+
+// Money is an amount in cents, scanned from decimal columns with a
+// Config.Converters function.
+type Money int64
+
+// TestScanConverters tests scanning into destination types registered in
+// Config.Converters.
+func TestScanConverters(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.Converters = map[reflect.Type]func(src interface{}) (interface{}, error){
+			reflect.TypeOf(Money(0)): func(src interface{}) (interface{}, error) {
+				switch src := src.(type) {
+				case nil:
+					return Money(0), nil
+				case float64:
+					return Money(math.Round(src * 100)), nil
+				case string:
+					f, err := strconv.ParseFloat(src, 64)
+					if nil != err {
+						return nil, err
+					}
+					return Money(math.Round(f * 100)), nil
+				}
+				return nil, fmt.Errorf("unexpected %T", src)
+			},
+		}
+	})
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"id", "balance"},
+			[]driver.Value{int64(1), 12.34},
+			[]driver.Value{int64(2), "0.99"},
+			[]driver.Value{int64(3), nil},
+			[]driver.Value{int64(4), true},
+		), nil
+	}
+
+	stmt, err := database.Prepare("SELECT id, balance FROM accounts")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+
+	var id int64
+	var balance Money
+	assert.True(t, stmt.Next(&id, &balance))
+	assert.Equal(t, Money(1234), balance)
+
+	var row struct {
+		ID      int64 `db:"id"`
+		Balance Money `db:"balance"`
+	}
+	assert.True(t, stmt.StructNext(&row))
+	assert.Equal(t, Money(99), row.Balance)
+
+	row.Balance = 1
+	assert.True(t, stmt.StructNext(&row))
+	assert.Equal(t, Money(0), row.Balance)
+
+	assert.False(t, stmt.StructNext(&row))
+	assert.Contains(t, fmt.Sprintf("%+v", stmt.LastErr()), "failed to scan result values")
+}
+
+// TestScanInterval tests scanning interval columns into time.Duration
+// destinations.
+func TestScanInterval(t *testing.T) {
+	tests := []struct {
+		driverType string
+		interval   driver.Value
+		expect     time.Duration
+	}{
+		{"postgres", []byte("1 day 02:03:04"), 26*time.Hour + 3*time.Minute + 4*time.Second},
+		{"postgres", "-3 days +00:00:01.5", -72*time.Hour + 1500*time.Millisecond},
+		{"postgres", "00:00:00.000001", time.Microsecond},
+		{"oracle", "+01 02:03:04.000000", 26*time.Hour + 3*time.Minute + 4*time.Second},
+		{"oracle", "-000000001 02:03:04.250000000", -(26*time.Hour + 3*time.Minute + 4250*time.Millisecond)},
+		{"oracle", 26 * time.Hour, 26 * time.Hour},
+		{"mysql", "838:59:59", 838*time.Hour + 59*time.Minute + 59*time.Second},
+		{"mysql", int64(time.Second), time.Second},
+	}
+	type job struct {
+		Name    string        `db:"name"`
+		Runtime time.Duration `db:"runtime"`
+	}
+
+	for _, test := range tests {
+		database, stub := newStubDB(t, func(cfg *db.Config) {
+			cfg.DriverType = test.driverType
+		})
+		stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+			return newStubRows([]string{"name", "runtime"}, []driver.Value{"nightly", test.interval}), nil
+		}
+		stmt, err := database.Prepare("SELECT name, runtime FROM jobs")
+		assert.NoError(t, err)
+
+		_, err = stmt.Query()
+		assert.NoError(t, err)
+		var name string
+		var runtime time.Duration
+		assert.True(t, stmt.Next(&name, &runtime), test.interval)
+		assert.Equal(t, test.expect, runtime, test.interval)
+
+		_, err = stmt.Query()
+		assert.NoError(t, err)
+		var row job
+		assert.True(t, stmt.StructNext(&row), test.interval)
+		assert.Equal(t, job{"nightly", test.expect}, row, test.interval)
+		stmt.Close()
+	}
+
+	// intervals without a fixed length
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "postgres"
+	})
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows([]string{"runtime"}, []driver.Value{"1 mon 2 days"}), nil
+	}
+	stmt, err := database.Prepare("SELECT runtime FROM jobs")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+	var runtime time.Duration
+	assert.False(t, stmt.Next(&runtime))
+	assert.Error(t, stmt.LastErr())
+}
@@ -0,0 +1,278 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/bdlm/errors/v2"
+)
+
+// GetOne executes the prepared statement and scans the first result row into
+// a new T using StructScan. If the query returns no rows, GetOne returns a nil
+// pointer and a nil error rather than sql.ErrNoRows. Any remaining rows are
+// discarded.
+func GetOne[T any](ctx context.Context, stmt *Statement, args ...interface{}) (*T, error) {
+	rows, err := stmt.QueryContext(ctx, args...)
+	if nil != err {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, stmt.Err()
+	}
+
+	dest := new(T)
+	if err := stmt.StructScan(dest); nil != err {
+		return nil, err
+	}
+
+	return dest, nil
+}
+
+// ScanScalar executes the prepared statement and scans the single value
+// returned into a T, for queries like `SELECT count(*)`. When the query
+// returns no rows the zero value is returned along with an error wrapping
+// sql.ErrNoRows.
+func ScanScalar[T any](ctx context.Context, stmt *Statement, args ...interface{}) (T, error) {
+	var dest T
+	err := stmt.QueryRowContext(ctx, args...).Scan(scanTarget(&dest))
+	if errors.Is(err, sql.ErrNoRows) {
+		return dest, errors.Wrap(err, "scalar query returned no rows")
+	}
+	if nil != err {
+		stmt.lastErr = err
+		var zero T
+		return zero, err
+	}
+	return dest, nil
+}
+
+// SelectChan executes the prepared statement and streams each result row,
+// scanned into a T using StructScan, on the returned channel. The row channel
+// is closed when the rows are exhausted, an error occurs, ctx is cancelled,
+// or the stream is stopped. At most one error is sent on the error channel, which is
+// closed after the row channel; callers should drain the rows and then check
+// for an error:
+//
+//	users, errs, stop := db.SelectChan[User](ctx, stmt)
+//	defer stop()
+//	for user := range users {
+//		...
+//	}
+//	if err := <-errs; nil != err {
+//		...
+//	}
+//
+// The returned stop function ends the stream and releases the statement's
+// cursor and connection, so callers that stop reading rows early don't leave
+// the goroutine streaming them blocked. It's safe to call more than once and
+// after the stream has ended.
+func SelectChan[T any](ctx context.Context, stmt *Statement, args ...interface{}) (<-chan T, <-chan error, func()) {
+	out := make(chan T)
+	errc := make(chan error, 1)
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		defer close(errc)
+		defer close(out)
+		defer cancel()
+
+		rows, err := stmt.QueryContext(ctx, args...)
+		if nil != err {
+			errc <- err
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var dest T
+			if err := stmt.StructScan(&dest); nil != err {
+				errc <- err
+				return
+			}
+			select {
+			case out <- dest:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+		if err := stmt.Err(); nil != err {
+			errc <- err
+		}
+	}()
+
+	return out, errc, cancel
+}
+
+// DuplicateKeys selects how SelectMap handles result rows sharing a key.
+type DuplicateKeys int
+
+const (
+	// DuplicateKeysError returns an error wrapping ErrDuplicateKey.
+	DuplicateKeysError DuplicateKeys = iota
+
+	// DuplicateKeysOverwrite keeps the last of the rows sharing a key.
+	DuplicateKeysOverwrite
+)
+
+// SelectMap executes the prepared statement and scans each result row into a
+// V using StructScan, keyed by the value of the keyCol column scanned into a
+// K, i.e. to build an id to row lookup. The key is scanned like the struct
+// fields, so Config.ScanTransform and Config.Converters apply to it. Rows
+// sharing a key are handled according to duplicates. An empty result returns
+// an empty map.
+func SelectMap[K comparable, V any](ctx context.Context, stmt *Statement, keyCol string, duplicates DuplicateKeys, args ...interface{}) (map[K]V, error) {
+	rows, err := stmt.QueryContext(ctx, args...)
+	if nil != err {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if nil != err {
+		stmt.lastErr = errors.Wrap(err, "failed to list result columns")
+		return nil, stmt.lastErr
+	}
+	keyIdx := -1
+	for a, column := range columns {
+		if keyCol == column {
+			keyIdx = a
+			break
+		}
+	}
+	if -1 == keyIdx {
+		stmt.lastErr = errors.Errorf("key column '%s' not found in result columns", keyCol)
+		return nil, stmt.lastErr
+	}
+
+	values := map[K]V{}
+	for rows.Next() {
+		var key K
+		row := make([]interface{}, len(columns))
+		for a := range row {
+			row[a] = new(interface{})
+		}
+		row[keyIdx] = &key
+		targets, err := stmt.scanTargets(row)
+		if nil != err {
+			stmt.lastErr = err
+			return nil, stmt.lastErr
+		}
+		if transform := stmt.db.Config().ScanTransform; nil != transform {
+			targets[keyIdx] = &transformScanner{keyCol, targets[keyIdx], transform}
+		}
+		if err := rows.Scan(targets...); nil != err {
+			stmt.lastErr = errors.Wrap(err, "failed to scan key column '%s'", keyCol)
+			return nil, stmt.lastErr
+		}
+		if _, ok := values[key]; ok && DuplicateKeysOverwrite != duplicates {
+			stmt.lastErr = errors.Wrap(ErrDuplicateKey, "duplicate key '%v' in column '%s'", key, keyCol)
+			return nil, stmt.lastErr
+		}
+
+		var dest V
+		if err := stmt.StructScan(&dest); nil != err {
+			return nil, err
+		}
+		values[key] = dest
+	}
+	if err := stmt.Err(); nil != err {
+		return nil, err
+	}
+	return values, nil
+}
+
+// SelectScalar executes the prepared statement and scans the single column of
+// each result row into a T, for queries returning a set of scalars such as
+// `SELECT name FROM users` or a set-returning function. An error is returned
+// if the query returns more than one column. An empty result returns an
+// empty slice.
+func SelectScalar[T any](ctx context.Context, stmt *Statement, args ...interface{}) ([]T, error) {
+	rows, err := stmt.QueryContext(ctx, args...)
+	if nil != err {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if nil != err {
+		stmt.lastErr = errors.Wrap(err, "failed to list result columns")
+		return nil, stmt.lastErr
+	}
+	if 1 != len(columns) {
+		stmt.lastErr = errors.Errorf("scalar queries must return exactly one column, %d returned", len(columns))
+		return nil, stmt.lastErr
+	}
+
+	values := []T{}
+	for rows.Next() {
+		var dest T
+		if err := rows.Scan(scanTarget(&dest)); nil != err {
+			stmt.lastErr = errors.Wrap(err, "failed to scan result value")
+			return nil, stmt.lastErr
+		}
+		values = append(values, dest)
+	}
+	if err := stmt.Err(); nil != err {
+		return nil, err
+	}
+	return values, nil
+}
+
+// GetStruct executes the prepared statement with any arguments that have
+// been added using Bind() calls, in the statement's context, and scans the
+// single result row into dest using StructScan. sql.ErrNoRows is returned if
+// the query returns no rows, and ErrTooManyRows if it returns more than one.
+// The rows are closed before returning. See GetOne to fetch the first of any
+// number of rows.
+func (statement *Statement) GetStruct(dest interface{}, args ...interface{}) error {
+	rows, err := statement.QueryContext(statement.ctx, args...)
+	if nil != err {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := statement.Err(); nil != err {
+			return err
+		}
+		statement.lastErr = sql.ErrNoRows
+		return statement.lastErr
+	}
+	if err := statement.StructScan(dest); nil != err {
+		return err
+	}
+	if rows.Next() {
+		statement.lastErr = ErrTooManyRows
+		return statement.lastErr
+	}
+	return statement.Err()
+}
+
+// QueryAllMaps executes the prepared statement with any arguments that have
+// been added using Bind() calls, in the statement's context, and returns
+// every result row scanned with MapScan. The rows are closed before
+// returning. An empty result returns an empty slice.
+func (statement *Statement) QueryAllMaps(args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := statement.QueryContext(statement.ctx, args...)
+	if nil != err {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []map[string]interface{}{}
+	for rows.Next() {
+		dest := map[string]interface{}{}
+		if err := statement.MapScan(dest); nil != err {
+			statement.lastErr = err
+			return nil, statement.lastErr
+		}
+		results = append(results, dest)
+	}
+	if err := statement.Err(); nil != err {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,320 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bdlm/db"
+	"github.com/bdlm/errors/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type user struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+// TestGetOne tests fetching a single optional row into a struct pointer.
+func TestGetOne(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		if "SELECT id, name FROM users WHERE id = 1" == query {
+			return newStubRows([]string{"id", "name"}, []driver.Value{int64(1), "alice"}), nil
+		}
+		return newStubRows([]string{"id", "name"}), nil
+	}
+
+	// found row
+	stmt, err := database.Prepare("SELECT id, name FROM users WHERE id = 1")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	found, err := db.GetOne[user](context.Background(), stmt)
+	assert.NoError(t, err)
+	assert.Equal(t, &user{ID: 1, Name: "alice"}, found)
+
+	// empty result
+	stmt, err = database.Prepare("SELECT id, name FROM users WHERE id = 2")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	missing, err := db.GetOne[user](context.Background(), stmt)
+	assert.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+// TestScanScalar tests scanning single value results.
+func TestScanScalar(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		if "SELECT count(*) FROM users" == query {
+			return newStubRows([]string{"count(*)"}, []driver.Value{int64(3)}), nil
+		}
+		return newStubRows([]string{"name"}), nil
+	}
+
+	stmt, err := database.Prepare("SELECT count(*) FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	count, err := db.ScanScalar[int64](context.Background(), stmt)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	// no rows
+	stmt, err = database.Prepare("SELECT name FROM users WHERE id = :id")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	name, err := db.ScanScalar[string](context.Background(), stmt.Bind("id", 4))
+	assert.True(t, errors.Is(err, sql.ErrNoRows))
+	assert.Equal(t, "", name)
+}
+
+// TestSelectChan tests streaming typed rows over a channel.
+func TestSelectChan(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"id", "name"},
+			[]driver.Value{int64(1), "alice"},
+			[]driver.Value{int64(2), "bob"},
+			[]driver.Value{int64(3), "carol"},
+		), nil
+	}
+
+	stmt, err := database.Prepare("SELECT id, name FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	users := []user{}
+	rows, errs, stop := db.SelectChan[user](context.Background(), stmt)
+	defer stop()
+	for row := range rows {
+		users = append(users, row)
+	}
+	assert.NoError(t, <-errs)
+	assert.Equal(t, []user{{1, "alice"}, {2, "bob"}, {3, "carol"}}, users)
+
+	// cancellation stops the stream
+	ctx, cancel := context.WithCancel(context.Background())
+	rows, errs, stop = db.SelectChan[user](ctx, stmt)
+	defer stop()
+	assert.Equal(t, user{1, "alice"}, <-rows)
+	cancel()
+	assert.True(t, errors.Is(<-errs, context.Canceled))
+	_, open := <-rows
+	assert.False(t, open)
+
+	// abandoned streams are released by stop
+	rows, errs, stop = db.SelectChan[user](context.Background(), stmt)
+	assert.Equal(t, user{1, "alice"}, <-rows)
+	stop()
+	select {
+	case err := <-errs:
+		assert.True(t, errors.Is(err, context.Canceled))
+	case <-time.After(time.Second):
+		t.Fatal("abandoned stream not stopped")
+	}
+	_, open = <-errs
+	assert.False(t, open)
+	stop()
+
+	// the statement's cursor was released
+	users = []user{}
+	rows, errs, stop = db.SelectChan[user](context.Background(), stmt)
+	defer stop()
+	for row := range rows {
+		users = append(users, row)
+	}
+	assert.NoError(t, <-errs)
+	assert.Len(t, users, 3)
+}
+
+// TestSelectScalar tests scanning single column results into a slice.
+func TestSelectScalar(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		if "SELECT name FROM users" == query {
+			return newStubRows(
+				[]string{"name"},
+				[]driver.Value{"alice"},
+				[]driver.Value{[]byte("bob")},
+			), nil
+		}
+		return newStubRows([]string{"id", "name"}, []driver.Value{int64(1), "alice"}), nil
+	}
+
+	stmt, err := database.Prepare("SELECT name FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	names, err := db.SelectScalar[string](context.Background(), stmt)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, names)
+
+	// multiple columns
+	stmt, err = database.Prepare("SELECT id, name FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	names, err = db.SelectScalar[string](context.Background(), stmt)
+	assert.Error(t, err)
+	assert.Nil(t, names)
+	assert.Equal(t, err, stmt.LastErr())
+}
+
+// TestSelectMap tests scanning result rows into a map keyed by a column.
+func TestSelectMap(t *testing.T) {
+	rows := func() *stubRows {
+		return newStubRows(
+			[]string{"id", "name"},
+			[]driver.Value{int64(1), "alice"},
+			[]driver.Value{int64(2), "bob"},
+			[]driver.Value{int64(1), "carol"},
+		)
+	}
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		if "SELECT id, name FROM users WHERE id < 3" == query {
+			return newStubRows(
+				[]string{"id", "name"},
+				[]driver.Value{int64(1), "alice"},
+				[]driver.Value{int64(2), "bob"},
+			), nil
+		}
+		return rows(), nil
+	}
+
+	stmt, err := database.Prepare("SELECT id, name FROM users WHERE id < 3")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	users, err := db.SelectMap[int64, user](context.Background(), stmt, "id", db.DuplicateKeysError)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]user{1: {ID: 1, Name: "alice"}, 2: {ID: 2, Name: "bob"}}, users)
+
+	// unknown key column
+	users, err = db.SelectMap[int64, user](context.Background(), stmt, "user_id", db.DuplicateKeysError)
+	assert.EqualError(t, err, "key column 'user_id' not found in result columns")
+	assert.Nil(t, users)
+
+	// duplicate keys
+	stmt, err = database.Prepare("SELECT id, name FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	users, err = db.SelectMap[int64, user](context.Background(), stmt, "id", db.DuplicateKeysError)
+	assert.True(t, errors.Is(err, db.ErrDuplicateKey))
+	assert.Nil(t, users)
+	assert.Equal(t, err, stmt.LastErr())
+
+	// overwritten duplicate keys
+	stmt, err = database.Prepare("SELECT id, name FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	users, err = db.SelectMap[int64, user](context.Background(), stmt, "id", db.DuplicateKeysOverwrite)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]user{1: {ID: 1, Name: "carol"}, 2: {ID: 2, Name: "bob"}}, users)
+
+	// keys are transformed like struct fields
+	database, stub = newStubDB(t, func(cfg *db.Config) {
+		cfg.ScanTransform = func(column string, value interface{}) (interface{}, error) {
+			if id, ok := value.(string); ok && "id" == column {
+				return strconv.ParseInt(strings.TrimPrefix(id, "enc:"), 10, 64)
+			}
+			return value, nil
+		}
+	})
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"id", "name"},
+			[]driver.Value{"enc:1", "alice"},
+			[]driver.Value{"enc:2", "bob"},
+		), nil
+	}
+	stmt, err = database.Prepare("SELECT id, name FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	users, err = db.SelectMap[int64, user](context.Background(), stmt, "id", db.DuplicateKeysError)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]user{1: {ID: 1, Name: "alice"}, 2: {ID: 2, Name: "bob"}}, users)
+}
+
+// TestQueryAllMaps tests scanning every result row into a slice of maps.
+func TestQueryAllMaps(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		if 0 == len(args) || "inactive" == args[0].Value {
+			return newStubRows([]string{"id", "name"}), nil
+		}
+		return newStubRows(
+			[]string{"id", "name"},
+			[]driver.Value{int64(1), "alice"},
+			[]driver.Value{int64(2), nil},
+		), nil
+	}
+
+	stmt, err := database.Prepare("SELECT id, name FROM users WHERE status = :status")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	rows, err := stmt.Bind("status", "active").QueryAllMaps()
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{"id": int64(1), "name": "alice"},
+		{"id": int64(2), "name": nil},
+	}, rows)
+	assert.False(t, stmt.Rows().Next())
+
+	// empty results
+	rows, err = stmt.QueryAllMaps(sql.Named("status", "inactive"))
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{}, rows)
+}
+
+// TestGetStruct tests fetching exactly one row into a struct.
+func TestGetStruct(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		switch query {
+		case "SELECT id, name FROM users WHERE id = 1":
+			return newStubRows([]string{"id", "name"}, []driver.Value{int64(1), "alice"}), nil
+		case "SELECT id, name FROM users":
+			return newStubRows(
+				[]string{"id", "name"},
+				[]driver.Value{int64(1), "alice"},
+				[]driver.Value{int64(2), "bob"},
+			), nil
+		}
+		return newStubRows([]string{"id", "name"}), nil
+	}
+
+	// exactly one row
+	stmt, err := database.Prepare("SELECT id, name FROM users WHERE id = 1")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	var found user
+	assert.NoError(t, stmt.GetStruct(&found))
+	assert.Equal(t, user{ID: 1, Name: "alice"}, found)
+
+	// no rows
+	stmt, err = database.Prepare("SELECT id, name FROM users WHERE id = 2")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	var missing user
+	assert.Equal(t, sql.ErrNoRows, stmt.GetStruct(&missing))
+	assert.Equal(t, user{}, missing)
+
+	// more than one row
+	stmt, err = database.Prepare("SELECT id, name FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	var many user
+	assert.Equal(t, db.ErrTooManyRows, stmt.GetStruct(&many))
+}
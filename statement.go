@@ -3,28 +3,67 @@ package db
 import (
 	"context"
 	"database/sql"
+	"net/url"
+	"sort"
+	"time"
 
 	"github.com/bdlm/errors/v2"
 	"github.com/bdlm/log/v2"
 	nr "github.com/newrelic/go-agent/v3/newrelic"
 )
 
+var (
+	// ErrDuplicateKey is returned by SelectMap when more than one row has
+	// the same key, unless DuplicateKeysOverwrite is given.
+	ErrDuplicateKey = errors.New("query returned more than one row with the same key")
+
+	// ErrTxExpired is returned by statement operations after the statement's
+	// transaction has been rolled back for exceeding Config.MaxTxLifetime.
+	ErrTxExpired = errors.New("transaction exceeded its maximum lifetime and was rolled back")
+
+	// ErrTooManyRows is returned by GetStruct when the query returns more
+	// than one row.
+	ErrTooManyRows = errors.New("query returned more than one row")
+)
+
 // Statement defines the prepared statement structure and API.
 type Statement struct {
+	// Rows bound for a batch insert, see BindBatch
+	batch [][]interface{}
+
+	// A bind error to return from the next execution, see BindStructInsert
+	bindErr error
+
 	// Bind params
 	binds []sql.NamedArg
 
+	// Releases the transaction lifetime context, see Config.MaxTxLifetime.
+	cancel context.CancelFunc
+
 	ctx context.Context
 
 	// Reference to the database instance that spawned this statement
 	db *DB
 
+	// Whether the transaction has been committed or rolled back, so Close
+	// doesn't roll it back again
+	done bool
+
+	// Whether work has been done in the statement's transaction outside of
+	// Exec and Query, see execTxn and Tx, so a failure isn't retried in a
+	// new transaction
+	executed bool
+
 	// Keeps track of the last error that occurred
 	lastErr error
 
 	// The NewRelic transaction agent
 	nrtxn *nr.Transaction
 
+	// The options the statement's transaction was begun with, see
+	// DB.PrepareTx.
+	opts *sql.TxOptions
+
 	// Reference to the result object for inspection
 	// https://golang.org/pkg/database/sql/#Result
 	result sql.Result
@@ -36,10 +75,21 @@ type Statement struct {
 	// The SQL query string
 	sql string
 
-	// The Stmt struct from the database/sql package
+	// The Stmt struct from the database/sql package, nil if the statement
+	// isn't prepared on the server, see Config.DisableServerPrepare.
 	// https://golang.org/pkg/database/sql/#Stmt
 	stmt *sql.Stmt
 
+	// The time each execution is limited to, see WithTimeout.
+	timeout time.Duration
+
+	// Releases the timeout context of the last query, see WithTimeout.
+	timeoutCancel context.CancelFunc
+
+	// The shared transaction that prepared this statement, if any. Statements
+	// prepared by a Tx don't roll back the transaction on Close.
+	tx *Tx
+
 	// The transaction instance used to manage this statement
 	// https://golang.org/pkg/database/sql/#Tx
 	txn *sql.Tx
@@ -51,7 +101,52 @@ func (statement *Statement) Bind(key string, value interface{}) *Statement {
 	return statement
 }
 
-// Close closes the current prepared statement and all related items.
+// BindValues binds form or query string values to named arguments. The first
+// value of each key is bound, or all values as a []string for keys that are
+// repeated. Keys that don't match a `:name` or `@name` placeholder in the
+// query are skipped, so arbitrary request parameters can be passed without
+// producing unknown bind errors.
+func (statement *Statement) BindValues(values url.Values) *Statement {
+	params := map[string]bool{}
+	for _, name := range namedParams(statement.sql) {
+		params[name] = true
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !params[key] || 0 == len(values[key]) {
+			continue
+		}
+		if 1 == len(values[key]) {
+			statement.Bind(key, values[key][0])
+		} else {
+			statement.Bind(key, values[key])
+		}
+	}
+	return statement
+}
+
+// Clone prepares the statement's SQL again in a new transaction, copying any
+// accumulated binds. Transactions can't be shared between goroutines, so each
+// clone has its own transaction and may be executed independently of the
+// original.
+func (statement *Statement) Clone(ctx context.Context) (*Statement, error) {
+	clone, err := statement.db.PrepareContext(ctx, statement.sql)
+	if nil != err {
+		return nil, errors.Wrap(err, "unable to clone statement")
+	}
+	clone.binds = append(clone.binds, statement.binds...)
+	return clone, nil
+}
+
+// Close closes the current prepared statement and all related items. The
+// statement's transaction is rolled back unless it has already been committed
+// or rolled back.
 func (statement *Statement) Close() error {
 	var err error
 	var errList []error
@@ -62,12 +157,17 @@ func (statement *Statement) Close() error {
 		}
 	}
 
-	if err = statement.txn.Rollback(); nil != err {
-		errList = append(errList, errors.Wrap(err, "error rolling back transaction"))
+	if nil == statement.tx && !statement.done {
+		statement.done = true
+		if err = statement.db.endTx(statement.ctx, "rollback", statement.txn); nil != err {
+			errList = append(errList, errors.Wrap(err, "error rolling back transaction"))
+		}
 	}
 
-	if err = statement.stmt.Close(); nil != err {
-		errList = append(errList, errors.Wrap(err, "error closing statement"))
+	if nil != statement.stmt {
+		if err = statement.stmt.Close(); nil != err {
+			errList = append(errList, errors.Wrap(err, "error closing statement"))
+		}
 	}
 
 	if 0 < len(errList) {
@@ -82,16 +182,41 @@ func (statement *Statement) Close() error {
 		statement.nrtxn.End()
 	}
 
+	statement.releaseTimeout()
+	if nil != statement.cancel {
+		statement.cancel()
+	}
+
 	return err
 }
 
-// Commit commits the current transaction to the database.
+// Commit commits the current transaction to the database and ends the
+// NewRelic transaction, if any.
 func (statement *Statement) Commit() error {
-	_ = statement.txn.Commit()
-	return nil
+	err := statement.db.endTx(statement.ctx, "commit", statement.txn)
+	statement.done = true
+	if nil != statement.nrtxn {
+		statement.nrtxn.End()
+		statement.nrtxn = nil
+	}
+	if nil != err {
+		err = statement.expired(err)
+		statement.lastErr = err
+	}
+	return err
 }
 
-// Err returns the error, if any, that was encountered during iteration.
+// expired replaces errors caused by the statement's transaction exceeding
+// Config.MaxTxLifetime with ErrTxExpired.
+func (statement *Statement) expired(err error) error {
+	if nil != statement.cancel && ErrTxExpired == context.Cause(statement.ctx) {
+		return errors.WrapE(err, ErrTxExpired)
+	}
+	return err
+}
+
+// Err returns the error, if any, that was encountered during iteration,
+// including advancing to the next result set with NextResultSet.
 // Err may be called after an explicit or implicit Close.
 // https://golang.org/pkg/database/sql/#Rows.Err
 func (statement *Statement) Err() error {
@@ -112,22 +237,73 @@ func (statement *Statement) Exec(args ...interface{}) (sql.Result, error) {
 }
 
 // ExecContext executes the prepared statement with any arguments that have been
-// added using Bind() calls.
+// added using Bind() calls. Transient failures are retried according to
+// Config.RetryPolicy.
 func (statement *Statement) ExecContext(ctx context.Context, args ...interface{}) (sql.Result, error) {
-	var err error
-	var binds []interface{}
-	for _, bind := range statement.binds {
-		binds = append(binds, bind)
+	ctx, cancel := statement.timeoutCtx(ctx)
+	defer cancel()
+	statement.db.logQuery(ctx, statement.sql)
+	binds, err := statement.bindArgs(args)
+	if nil != err {
+		statement.binds = []sql.NamedArg{}
+		statement.lastErr = err
+		return nil, err
 	}
-	binds = append(binds, args...)
-	statement.result, err = statement.stmt.ExecContext(ctx, binds...)
+	err = statement.retry(ctx, func() error {
+		start := time.Now()
+		span := statement.db.startSpan(ctx, "exec", statement.sql)
+		var err error
+		statement.result, err = statement.exec(ctx, binds)
+		statement.db.endSpan(span, err)
+		statement.db.observe(ctx, statement.sql, binds, start, err)
+		statement.db.breakerRecord(err)
+		return err
+	})
 	if nil != err {
+		err = statement.expired(err)
 		statement.lastErr = err
+	} else {
+		statement.db.onWrite(statement.txn, statement.sql, statement.result)
+		if nil != statement.tx {
+			statement.tx.addRowsAffected(statement.result)
+		}
 	}
 	statement.binds = []sql.NamedArg{}
 	return statement.result, err
 }
 
+// exec executes the statement, in the transaction directly if it isn't
+// prepared on the server.
+func (statement *Statement) exec(ctx context.Context, binds []interface{}) (sql.Result, error) {
+	if nil == statement.stmt {
+		return statement.txn.ExecContext(ctx, statement.db.Config().driverSQL(statement.sql), binds...)
+	}
+	return statement.stmt.ExecContext(ctx, binds...)
+}
+
+// IsolationLevel returns the isolation level in effect for the statement's
+// transaction as reported by the database, i.e. "read committed" for postgres
+// or "REPEATABLE-READ" for mysql, to verify that requested sql.TxOptions took
+// effect. Requires a "mysql" or "postgres" DriverType.
+func (statement *Statement) IsolationLevel(ctx context.Context) (string, error) {
+	var query string
+	switch statement.db.Config().DriverType {
+	case "mysql":
+		query = "SELECT @@transaction_isolation"
+	case "postgres":
+		query = "SELECT current_setting('transaction_isolation')"
+	default:
+		return "", errors.Errorf("isolation level lookup is not supported for driver type '%s'", statement.db.Config().DriverType)
+	}
+
+	var level string
+	if err := statement.txn.QueryRowContext(ctx, query).Scan(&level); nil != err {
+		statement.lastErr = errors.Wrap(err, "unable to read transaction isolation level")
+		return "", statement.lastErr
+	}
+	return level, nil
+}
+
 // LastErr returns the last error encountered by this statement.
 func (statement *Statement) LastErr() error {
 	return statement.lastErr
@@ -169,25 +345,83 @@ func (statement *Statement) MapNext(dest map[string]interface{}) bool {
 // MapScan copies the columns in the current row into the values pointed at by
 // dest. The number of values in dest must be the same as the number of
 // columns in Rows.
+//
+// If Config.LazyLOB is set, LOB columns (BLOB, CLOB, etc., as reported by the
+// driver's column type names) are stored as an io.ReadCloser rather than a
+// []byte. LOB locators returned by the driver, i.e. by godror with
+// godror.LobAsReader, are stored as is so large values are streamed on
+// demand while the row is open. Drivers that return the value itself have
+// already read it into memory; it's copied and the reader reads the copy.
+// NULL LOB values are stored as nil.
+//
+// If Config.UseRawBytes is set, []byte values refer to memory owned by the
+// driver and are only valid until the next call to Next, Scan, or Close.
+//
+// If Config.ScanBytesAsString is set, []byte values are stored as strings.
+//
+// Columns sharing a name, as in joins, are stored under distinct keys: the
+// first keeps its name and the others are suffixed with their occurrence,
+// i.e. "id" and "id_2". Set Config.ColumnRenamer for another scheme.
+//
+// Values in columns listed in Config.MaskColumns are replaced with "****".
 // https://golang.org/pkg/database/sql/#Rows.Scan
 func (statement *Statement) MapScan(dest map[string]interface{}) error {
 	columns, err := statement.rows.Columns()
 	if err != nil {
 		return errors.Wrap(err, "failed to list result columns")
 	}
+	keys, err := statement.db.Config().columnKeys(columns)
+	if nil != err {
+		return err
+	}
+
+	var lobs []bool
+	if statement.db.Config().LazyLOB {
+		if lobs, err = lobColumns(statement.rows); nil != err {
+			return errors.Wrap(err, "failed to list result column types")
+		}
+	}
 
 	values := make([]interface{}, len(columns))
+	targets := make([]interface{}, len(columns))
 	for i := range values {
-		values[i] = new(interface{})
+		if statement.db.Config().UseRawBytes && (nil == lobs || !lobs[i]) {
+			values[i] = new(interface{})
+			targets[i] = &rawValueScanner{values[i].(*interface{})}
+		} else {
+			values[i] = new(interface{})
+			targets[i] = values[i]
+		}
 	}
 
-	err = statement.rows.Scan(values...)
+	err = statement.rows.Scan(targets...)
 	if err != nil {
 		return errors.Wrap(err, "failed to scan result values")
 	}
 
+	for a, key := range keys {
+		dest[key] = *(values[a].(*interface{}))
+		if nil != lobs && lobs[a] {
+			dest[key] = lobReader(dest[key])
+			continue
+		}
+		if data, ok := dest[key].([]byte); ok && statement.db.Config().ScanBytesAsString {
+			dest[key] = string(data)
+		}
+	}
+
+	if transform := statement.db.Config().ScanTransform; nil != transform {
+		for a, column := range columns {
+			if dest[keys[a]], err = transform(column, dest[keys[a]]); nil != err {
+				return errors.Wrap(err, "failed to transform column '%s'", column)
+			}
+		}
+	}
+
 	for a, column := range columns {
-		dest[column] = *(values[a].(*interface{}))
+		if statement.db.Config().masked(column) {
+			dest[keys[a]] = MaskedValue
+		}
 	}
 
 	return statement.rows.Err()
@@ -226,6 +460,27 @@ func (statement *Statement) Next(dest ...interface{}) bool {
 	return true
 }
 
+// NextResultSet advances the cursor to the next result set of a query that
+// returns several, i.e. an Oracle procedure returning multiple ref cursors or
+// a SQL Server procedure with multiple selects. Callers drain the rows of
+// one set with Next, MapNext, or StructNext, call NextResultSet, then
+// continue reading the next set. Columns are read from the current set, so
+// the sets may differ. It returns false once there are no further result
+// sets or an error occurs, see Err.
+// https://golang.org/pkg/database/sql/#Rows.NextResultSet
+func (statement *Statement) NextResultSet() bool {
+	if nil == statement.rows {
+		statement.lastErr = errors.Errorf("no cursor found. did you remember to run `statement.Query()`?")
+		log.WithError(statement.lastErr).Error("cursor not found")
+		return false
+	}
+	if !statement.rows.NextResultSet() {
+		_ = statement.Err()
+		return false
+	}
+	return true
+}
+
 // Query executes the prepared statement with any arguments that have been
 // added using Bind() calls. Query stores a cursor to the result of the SQL
 // query.
@@ -235,22 +490,43 @@ func (statement *Statement) Query(args ...interface{}) (*sql.Rows, error) {
 
 // QueryContext executes the prepared statement with any arguments that have been
 // added using Bind() calls. Query stores a cursor to the result of the SQL
-// query.
+// query. Transient failures are retried according to Config.RetryPolicy.
 func (statement *Statement) QueryContext(ctx context.Context, args ...interface{}) (*sql.Rows, error) {
-	var err error
-	var binds []interface{}
-	for _, bind := range statement.binds {
-		binds = append(binds, bind)
+	ctx = statement.queryTimeoutCtx(ctx)
+	statement.db.logQuery(ctx, statement.sql)
+	binds, err := statement.bindArgs(args)
+	if nil != err {
+		statement.binds = []sql.NamedArg{}
+		statement.lastErr = err
+		return nil, err
 	}
-	binds = append(binds, args...)
-	statement.rows, err = statement.stmt.QueryContext(ctx, binds...)
+	err = statement.retry(ctx, func() error {
+		start := time.Now()
+		span := statement.db.startSpan(ctx, "query", statement.sql)
+		var err error
+		statement.rows, err = statement.query(ctx, binds)
+		statement.db.endSpan(span, err)
+		statement.db.observe(ctx, statement.sql, binds, start, err)
+		statement.db.breakerRecord(err)
+		return err
+	})
 	if nil != err {
+		err = statement.expired(err)
 		statement.lastErr = err
 	}
 	statement.binds = []sql.NamedArg{}
 	return statement.rows, err
 }
 
+// query executes the statement, in the transaction directly if it isn't
+// prepared on the server.
+func (statement *Statement) query(ctx context.Context, binds []interface{}) (*sql.Rows, error) {
+	if nil == statement.stmt {
+		return statement.txn.QueryContext(ctx, statement.db.Config().driverSQL(statement.sql), binds...)
+	}
+	return statement.stmt.QueryContext(ctx, binds...)
+}
+
 // QueryRow executes the prepared statement with any arguments that have been
 // added using Bind() calls. Query stores a cursor to the result of the SQL
 // query.
@@ -262,11 +538,31 @@ func (statement *Statement) QueryRow(args ...interface{}) *sql.Row {
 // added using Bind() calls. Query stores a cursor to the result of the SQL
 // query.
 func (statement *Statement) QueryRowContext(ctx context.Context, args ...interface{}) *sql.Row {
-	var binds []interface{}
-	for _, bind := range statement.binds {
-		binds = append(binds, bind)
+	ctx = statement.queryTimeoutCtx(ctx)
+	statement.db.logQuery(ctx, statement.sql)
+	binds, err := statement.bindArgs(args)
+	if nil != err {
+		// A sql.Row can't carry the error, so the query is run with a
+		// cancelled context and the cause is reported by LastErr.
+		statement.lastErr = err
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		ctx = cancelled
+	}
+	start := time.Now()
+	span := statement.db.startSpan(ctx, "query", statement.sql)
+	row := statement.queryRow(ctx, binds)
+	statement.db.endSpan(span, row.Err())
+	statement.db.observe(ctx, statement.sql, binds, start, row.Err())
+	return row
+}
+
+// queryRow executes the statement, in the transaction directly if it isn't
+// prepared on the server.
+func (statement *Statement) queryRow(ctx context.Context, binds []interface{}) *sql.Row {
+	if nil == statement.stmt {
+		return statement.txn.QueryRowContext(ctx, statement.db.Config().driverSQL(statement.sql), binds...)
 	}
-	binds = append(binds, args...)
 	return statement.stmt.QueryRowContext(ctx, binds...)
 }
 
@@ -277,8 +573,10 @@ func (statement *Statement) Result() sql.Result {
 
 // Rollback aborts the current transaction.
 func (statement *Statement) Rollback() error {
-	err := statement.txn.Rollback()
+	err := statement.db.endTx(statement.ctx, "rollback", statement.txn)
+	statement.done = true
 	if nil != err {
+		err = statement.expired(err)
 		statement.lastErr = err
 	}
 	return err
@@ -292,11 +590,75 @@ func (statement *Statement) Rows() *sql.Rows {
 // Scan copies the columns in the current row into the values pointed at by
 // dest. The number of values in dest must be the same as the number of
 // columns in Rows.
+//
+// In addition to the types supported by database/sql, *big.Int and *big.Rat
+// destinations are populated from the driver's numeric representation without
+// loss of precision. NULL values leave big number destinations unchanged.
+//
+// If Config.UseRawBytes is set, *[]byte destinations are populated with
+// memory owned by the driver rather than a copy, avoiding an allocation per
+// column. The bytes are only valid until the next call to Next, Scan, or
+// Close; copy them to keep them longer.
+//
+// Destinations implementing GeometryScanner receive the WKB value of
+// geometry columns.
 // https://golang.org/pkg/database/sql/#Rows.Scan
 func (statement *Statement) Scan(dest ...interface{}) error {
-	err := statement.rows.Scan(dest...)
+	targets, err := statement.scanTargets(dest)
+	if nil == err {
+		err = statement.rows.Scan(targets...)
+	}
 	if nil != err {
 		statement.lastErr = err
 	}
 	return err
 }
+
+// Tx returns the internal sql.Tx pointer, so the statement's transaction
+// can be used directly with database/sql APIs this package doesn't wrap.
+// Don't commit or roll it back; use the statement's Commit, Rollback, or
+// Close methods to finish the transaction. Once the transaction has been
+// handed out, failed executions of the statement are no longer retried, see
+// Config.RetryPolicy.
+// https://golang.org/pkg/database/sql/#Tx
+func (statement *Statement) Tx() *sql.Tx {
+	statement.executed = true
+	return statement.txn
+}
+
+// WithTimeout limits each subsequent execution of the statement with Exec,
+// Query, or QueryRow and their Context variants to d, without a context
+// being passed to each call. The timeout is derived from the execution's
+// context and cancellation propagates to the driver, so drivers that honor
+// contexts, i.e. godror, interrupt long-running queries. For queries the
+// timeout also bounds reading the rows with Next or Scan; each query starts
+// a new timeout and releases the previous one. Zero removes the limit.
+func (statement *Statement) WithTimeout(d time.Duration) *Statement {
+	statement.timeout = d
+	return statement
+}
+
+// timeoutCtx derives the context of an execution from ctx, see WithTimeout.
+// The returned function releases it.
+func (statement *Statement) timeoutCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	if 0 >= statement.timeout {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, statement.timeout)
+}
+
+// queryTimeoutCtx derives the context of a query from ctx, see WithTimeout.
+// It's released by the next query or Close, since the rows are read with it.
+func (statement *Statement) queryTimeoutCtx(ctx context.Context) context.Context {
+	statement.releaseTimeout()
+	ctx, statement.timeoutCancel = statement.timeoutCtx(ctx)
+	return ctx
+}
+
+// releaseTimeout releases the timeout context of the last query, if any.
+func (statement *Statement) releaseTimeout() {
+	if nil != statement.timeoutCancel {
+		statement.timeoutCancel()
+		statement.timeoutCancel = nil
+	}
+}
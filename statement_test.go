@@ -0,0 +1,667 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bdlm/db"
+	"github.com/bdlm/errors/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestClone tests that cloned statements execute independently of the
+// original.
+func TestClone(t *testing.T) {
+	var mu sync.Mutex
+	var binds [][]string
+	database, stub := newStubDB(t)
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		names := []string{}
+		for _, arg := range args {
+			names = append(names, fmt.Sprintf("%s=%v", arg.Name, arg.Value))
+		}
+		binds = append(binds, names)
+		return driver.RowsAffected(1), nil
+	}
+
+	stmt, err := database.Prepare("UPDATE users SET name = :name WHERE id = :id")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	stmt.Bind("name", "alice")
+
+	clone, err := stmt.Clone(context.Background())
+	assert.NoError(t, err)
+	defer clone.Close()
+
+	stmt.Bind("id", 1)
+	clone.Bind("id", 2)
+
+	_, err = clone.Exec()
+	assert.NoError(t, err)
+	assert.NoError(t, clone.Commit())
+	_, err = stmt.Exec()
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Rollback())
+
+	assert.Equal(t, [][]string{{"name=alice", "id=2"}, {"name=alice", "id=1"}}, binds)
+	assert.Equal(t, 2, stub.Opens())
+
+	log := stub.Log()
+	assert.Contains(t, log, "commit")
+	assert.Contains(t, log, "rollback")
+}
+
+// TestOnWrite tests the write hook fired after insert, update, and delete
+// statements.
+func TestOnWrite(t *testing.T) {
+	type write struct {
+		table string
+		op    string
+		rows  int64
+	}
+	var writes []write
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.OnWrite = func(table, op string, rowsAffected int64) {
+			writes = append(writes, write{table, op, rowsAffected})
+		}
+	})
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		return driver.RowsAffected(3), nil
+	}
+
+	stmt, err := database.Prepare("UPDATE users SET active = 0 WHERE last_login < :cutoff")
+	assert.NoError(t, err)
+	_, err = stmt.Bind("cutoff", "2020-01-01").Exec()
+	assert.NoError(t, err)
+
+	// writes in a transaction are reported once it commits
+	assert.Empty(t, writes)
+	assert.NoError(t, stmt.Commit())
+	assert.Equal(t, []write{{"users", "update", 3}}, writes)
+
+	// rolled back writes aren't reported
+	stmt, err = database.Prepare("DELETE FROM users WHERE id = :id")
+	assert.NoError(t, err)
+	_, err = stmt.Bind("id", 1).Exec()
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Close())
+	assert.Equal(t, []write{{"users", "update", 3}}, writes)
+
+	// writes outside a transaction are reported immediately
+	_, err = database.Exec("INSERT INTO audit (id) VALUES (1)")
+	assert.NoError(t, err)
+
+	// non-write statements don't fire the hook
+	_, err = database.Exec("SET search_path = app")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []write{{"users", "update", 3}, {"audit", "insert", 3}}, writes)
+}
+
+// TestBindValues tests binding url.Values to named arguments.
+func TestBindValues(t *testing.T) {
+	var binds []string
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		for _, arg := range args {
+			binds = append(binds, fmt.Sprintf("%s=%v", arg.Name, arg.Value))
+		}
+		return newStubRows(nil), nil
+	}
+
+	stmt, err := database.Prepare("SELECT * FROM users WHERE name = :name AND status IN (:status) AND created::date > '12:00'")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	_, err = stmt.BindValues(url.Values{
+		"name":   {"alice"},
+		"status": {"active", "pending"},
+		"page":   {"2"},
+	}).Query()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"name=alice", "status=[active pending]"}, binds)
+}
+
+// TestBindTransform tests transforming bound values before they reach the
+// driver.
+func TestBindTransform(t *testing.T) {
+	var binds []string
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.BindTransform = func(name string, value interface{}) (interface{}, error) {
+			if "ssn" != name {
+				return value, nil
+			}
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected %T", value)
+			}
+			return base64.StdEncoding.EncodeToString([]byte(s)), nil
+		}
+	})
+	record := func(args []driver.NamedValue) {
+		for _, arg := range args {
+			binds = append(binds, fmt.Sprintf("%s=%v", arg.Name, arg.Value))
+		}
+	}
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		record(args)
+		return driver.RowsAffected(1), nil
+	}
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		record(args)
+		return newStubRows(nil), nil
+	}
+
+	stmt, err := database.Prepare("UPDATE users SET ssn = :ssn WHERE name = :name")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Bind("ssn", "123-45-6789").Exec(sql.Named("name", "alice"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ssn=MTIzLTQ1LTY3ODk=", "name=alice"}, binds)
+
+	// queries are transformed too
+	binds = nil
+	_, err = stmt.Bind("name", "bob").Bind("ssn", "000-00-0000").Query()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"name=bob", "ssn=MDAwLTAwLTAwMDA="}, binds)
+
+	// transform errors abort the execution
+	binds = nil
+	_, err = stmt.Bind("ssn", 123).Exec()
+	assert.Error(t, err)
+	assert.Equal(t, err, stmt.LastErr())
+	assert.Nil(t, binds)
+}
+
+// TestRebindNamed tests rewriting named placeholders to positional ones and
+// ordering bound values to match.
+func TestRebindNamed(t *testing.T) {
+	tests := []struct {
+		driverType string
+		query      string
+		binds      []string
+	}{
+		{
+			"snowflake",
+			"SELECT * FROM users WHERE name = ? OR alias = ? AND created < ?",
+			[]string{"1=alice", "2=alice", "3=2024-01-01"},
+		},
+		{
+			"postgres",
+			"SELECT * FROM users WHERE name = $1 OR alias = $1 AND created < $2",
+			[]string{"1=alice", "2=2024-01-01"},
+		},
+	}
+
+	for _, test := range tests {
+		for _, serverPrepare := range []bool{true, false} {
+			var query string
+			var binds []string
+			database, stub := newStubDB(t, func(cfg *db.Config) {
+				cfg.DriverType = test.driverType
+				cfg.RebindNamed = true
+				cfg.DisableServerPrepare = !serverPrepare
+			})
+			stub.Query = func(q string, args []driver.NamedValue) (*stubRows, error) {
+				query = q
+				for _, arg := range args {
+					binds = append(binds, fmt.Sprintf("%d=%v", arg.Ordinal, arg.Value))
+				}
+				return newStubRows(nil), nil
+			}
+
+			stmt, err := database.Prepare("SELECT * FROM users WHERE name = :name OR alias = :name AND created < :created")
+			assert.NoError(t, err)
+			_, err = stmt.Bind("created", "2024-01-01").Bind("name", "alice").Query()
+			assert.NoError(t, err, test.driverType)
+			assert.Equal(t, test.query, query, test.driverType)
+			assert.Equal(t, test.binds, binds, test.driverType)
+
+			// every placeholder must be bound
+			_, err = stmt.Bind("name", "alice").Query()
+			assert.EqualError(t, err, "no value bound to named parameter 'created'", test.driverType)
+			stmt.Close()
+		}
+	}
+}
+
+// TestWithTimeout tests limiting statement executions with a timeout.
+func TestWithTimeout(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows([]string{"id"}, []driver.Value{int64(1)}, []driver.Value{int64(2)}), nil
+	}
+
+	stmt, err := database.Prepare("SELECT id FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	stmt.WithTimeout(50 * time.Millisecond)
+
+	// slow executions are cancelled
+	stub.mu.Lock()
+	stub.ExecDelay = time.Second
+	stub.mu.Unlock()
+	start := time.Now()
+	_, err = stmt.Exec()
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	_, err = stmt.Query()
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, time.Since(start), time.Second)
+
+	// each query gets a new timeout, which also bounds reading its rows
+	stub.mu.Lock()
+	stub.ExecDelay = 0
+	stub.mu.Unlock()
+	for a := 0; a < 2; a++ {
+		_, err = stmt.Query()
+		assert.NoError(t, err)
+		var id int64
+		assert.True(t, stmt.Next(&id))
+		assert.Equal(t, int64(1), id)
+		time.Sleep(100 * time.Millisecond)
+		assert.False(t, stmt.Next(&id))
+		assert.True(t, errors.Is(stmt.Err(), context.DeadlineExceeded))
+	}
+
+	// no limit
+	stmt.WithTimeout(0)
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	var id int64
+	assert.True(t, stmt.Next(&id))
+}
+
+// TestIsolationLevel tests reading the isolation level of a statement's
+// transaction.
+func TestIsolationLevel(t *testing.T) {
+	tests := []struct {
+		driverType string
+		query      string
+		level      string
+	}{
+		{"mysql", "SELECT @@transaction_isolation", "REPEATABLE-READ"},
+		{"postgres", "SELECT current_setting('transaction_isolation')", "serializable"},
+	}
+
+	for _, test := range tests {
+		database, stub := newStubDB(t, func(cfg *db.Config) {
+			cfg.DriverType = test.driverType
+		})
+		stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+			if test.query == query {
+				return newStubRows([]string{"level"}, []driver.Value{test.level}), nil
+			}
+			return nil, fmt.Errorf("unexpected query: %s", query)
+		}
+
+		stmt, err := database.Prepare("SELECT 1")
+		assert.NoError(t, err)
+		level, err := stmt.IsolationLevel(context.Background())
+		assert.NoError(t, err, test.driverType)
+		assert.Equal(t, test.level, level, test.driverType)
+		assert.NoError(t, stmt.Close())
+	}
+
+	// unsupported drivers
+	database, _ := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "oracle"
+	})
+	stmt, err := database.Prepare("SELECT 1")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.IsolationLevel(context.Background())
+	assert.Error(t, err)
+}
+
+// TestPrepareTx tests beginning statement transactions with the default and
+// per-statement transaction options.
+func TestPrepareTx(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.TxOptions = &sql.TxOptions{Isolation: sql.LevelReadCommitted}
+	})
+
+	stmt, err := database.Prepare("SELECT 1")
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Close())
+
+	stmt, err = database.PrepareTx(context.Background(), "SELECT 1", &sql.TxOptions{
+		Isolation: sql.LevelSerializable,
+		ReadOnly:  true,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Close())
+
+	assert.Equal(t, []driver.TxOptions{
+		{Isolation: driver.IsolationLevel(sql.LevelReadCommitted)},
+		{Isolation: driver.IsolationLevel(sql.LevelSerializable), ReadOnly: true},
+	}, stub.TxOptions())
+
+	// no options by default
+	database, stub = newStubDB(t)
+	stmt, err = database.Prepare("SELECT 1")
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Close())
+	assert.Equal(t, []driver.TxOptions{{}}, stub.TxOptions())
+}
+
+// TestPrepareReadOnly tests that read-only statements reject writes.
+func TestPrepareReadOnly(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.TxOptions = &sql.TxOptions{Isolation: sql.LevelRepeatableRead}
+	})
+
+	stmt, err := database.PrepareReadOnly(context.Background(), "INSERT INTO users (name) VALUES (:name)")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Bind("name", "alice").Exec()
+	assert.Error(t, err)
+	assert.Contains(t, fmt.Sprintf("%+v", err), "read-only transaction")
+
+	assert.Equal(t, []driver.TxOptions{
+		{Isolation: driver.IsolationLevel(sql.LevelRepeatableRead), ReadOnly: true},
+	}, stub.TxOptions())
+
+	// reads are allowed
+	stmt, err = database.PrepareReadOnly(context.Background(), "SELECT name FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+}
+
+// TestCloseAfterCommit tests that Close doesn't roll back transactions that
+// are already finished.
+func TestCloseAfterCommit(t *testing.T) {
+	database, stub := newStubDB(t)
+
+	// commit then close
+	stmt, err := database.Prepare("UPDATE users SET active = 1")
+	assert.NoError(t, err)
+	_, err = stmt.Exec()
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Commit())
+	assert.NoError(t, stmt.Close())
+	assert.NoError(t, stmt.LastErr())
+
+	// rollback then close
+	stmt, err = database.Prepare("UPDATE users SET active = 0")
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Rollback())
+	assert.NoError(t, stmt.Close())
+
+	// query then close rolls back
+	stmt, err = database.Prepare("SELECT id FROM users")
+	assert.NoError(t, err)
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Close())
+	assert.NoError(t, stmt.LastErr())
+
+	assert.Equal(t, []string{
+		"begin",
+		"prepare: UPDATE users SET active = 1",
+		"exec: UPDATE users SET active = 1",
+		"commit",
+		"begin",
+		"prepare: UPDATE users SET active = 0",
+		"rollback",
+		"begin",
+		"prepare: SELECT id FROM users",
+		"query: SELECT id FROM users",
+		"rollback",
+	}, stub.Log())
+}
+
+// TestMaxTxLifetime tests rolling back statement transactions that exceed
+// their maximum lifetime.
+func TestMaxTxLifetime(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.MaxTxLifetime = 20 * time.Millisecond
+	})
+
+	// completed in time
+	stmt, err := database.Prepare("UPDATE users SET active = 1")
+	assert.NoError(t, err)
+	_, err = stmt.Exec()
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Close())
+
+	// expired
+	stmt, err = database.Prepare("UPDATE users SET active = 0")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	deadline := time.Now().Add(time.Second)
+	for !containsEntry(stub.Log(), "rollback", 2) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err = stmt.Exec()
+	assert.True(t, errors.Is(err, db.ErrTxExpired), fmt.Sprintf("%v", err))
+	_, err = stmt.Query()
+	assert.True(t, errors.Is(err, db.ErrTxExpired), fmt.Sprintf("%v", err))
+	assert.Equal(t, []string{
+		"begin",
+		"prepare: UPDATE users SET active = 1",
+		"exec: UPDATE users SET active = 1",
+		"rollback",
+		"begin",
+		"prepare: UPDATE users SET active = 0",
+		"rollback",
+	}, stub.Log())
+}
+
+// TestPrepareContext tests that statements created by Prepare are cancelled
+// with the database context.
+func TestPrepareContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.Ctx = ctx
+	})
+
+	stmt, err := database.Prepare("UPDATE users SET active = 1")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for !containsEntry(stub.Log(), "rollback", 1) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the transaction was rolled back with the statement
+	_, err = stmt.Exec()
+	assert.Error(t, err)
+	assert.Equal(t, []string{
+		"begin",
+		"prepare: UPDATE users SET active = 1",
+		"rollback",
+	}, stub.Log())
+}
+
+// containsEntry reports whether the log contains at least count copies of
+// entry.
+func containsEntry(log []string, entry string, count int) bool {
+	for _, e := range log {
+		if entry == e {
+			count--
+		}
+	}
+	return 0 >= count
+}
+
+// TestStatementTx tests running raw database/sql queries in the statement's
+// transaction.
+func TestStatementTx(t *testing.T) {
+	database, stub := newStubDB(t)
+
+	stmt, err := database.Prepare("UPDATE users SET active = 1")
+	assert.NoError(t, err)
+	defer stmt.Close()
+
+	_, err = stmt.Tx().Exec("UPDATE sessions SET active = 1")
+	assert.NoError(t, err)
+	_, err = stmt.Exec()
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Commit())
+
+	assert.Equal(t, []string{
+		"begin",
+		"prepare: UPDATE users SET active = 1",
+		"exec: UPDATE sessions SET active = 1",
+		"exec: UPDATE users SET active = 1",
+		"commit",
+	}, stub.Log())
+	assert.Equal(t, 1, stub.Opens())
+}
+
+// TestDisableServerPrepare tests executing statements without preparing them
+// on the server.
+func TestDisableServerPrepare(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DisableServerPrepare = true
+		cfg.DriverType = "postgres"
+	})
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows([]string{"id"}, []driver.Value{int64(1)}), nil
+	}
+
+	stmt, err := database.Prepare("UPDATE users SET active = 1")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Exec()
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Commit())
+
+	tx, err := database.Begin(context.Background(), nil)
+	assert.NoError(t, err)
+	stmt, err = tx.Prepare("SELECT id FROM users")
+	assert.NoError(t, err)
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+	var id int64
+	assert.True(t, stmt.Next(&id))
+	assert.Equal(t, int64(1), id)
+	assert.NoError(t, stmt.Close())
+	assert.NoError(t, tx.Commit())
+
+	assert.Equal(t, []string{
+		"begin",
+		"exec: UPDATE users SET active = 1",
+		"commit",
+		"begin",
+		"query: SELECT id FROM users",
+		"commit",
+	}, stub.Log())
+
+	// cockroach speaks the postgres protocol
+	database, stub = newStubDB(t, func(cfg *db.Config) {
+		cfg.DisableServerPrepare = true
+		cfg.DriverType = "cockroach"
+	})
+	stmt, err = database.Prepare("SELECT 1")
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Close())
+	assert.NotContains(t, stub.Log(), "prepare: SELECT 1")
+
+	// other driver types still prepare statements
+	database, stub = newStubDB(t, func(cfg *db.Config) {
+		cfg.DisableServerPrepare = true
+		cfg.DriverType = "oracle"
+	})
+	stmt, err = database.Prepare("SELECT 1")
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Close())
+	assert.Contains(t, stub.Log(), "prepare: SELECT 1")
+}
+
+// TestCommitError tests returning transaction commit errors.
+func TestCommitError(t *testing.T) {
+	database, stub := newStubDB(t)
+
+	// committing a rolled back transaction
+	stmt, err := database.Prepare("UPDATE users SET active = 1")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	assert.NoError(t, stmt.Rollback())
+	err = stmt.Commit()
+	assert.Error(t, err)
+	assert.Equal(t, err, stmt.LastErr())
+
+	// a commit failure reported by the database
+	stub.mu.Lock()
+	stub.CommitErr = fmt.Errorf("deadlock detected")
+	stub.mu.Unlock()
+	stmt, err = database.Prepare("UPDATE users SET active = 1")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Exec()
+	assert.NoError(t, err)
+	err = stmt.Commit()
+	assert.True(t, errors.Is(err, stub.CommitErr))
+	assert.Equal(t, err, stmt.LastErr())
+}
+
+// TestNextResultSet tests reading queries that return several result sets.
+func TestNextResultSet(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		if "CALL broken_report()" == query {
+			return newStubRows([]string{"id"}, []driver.Value{int64(1)}).
+				WithNextErr(fmt.Errorf("ORA-01001: invalid cursor")), nil
+		}
+		return newStubRows(
+			[]string{"id", "name"},
+			[]driver.Value{int64(1), "alice"},
+			[]driver.Value{int64(2), "bob"},
+		).WithNext(newStubRows(
+			[]string{"total"},
+			[]driver.Value{int64(2)},
+		)), nil
+	}
+
+	stmt, err := database.Prepare("CALL user_report()")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+
+	names := []string{}
+	var id int64
+	var name string
+	for stmt.Next(&id, &name) {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"alice", "bob"}, names)
+
+	assert.True(t, stmt.NextResultSet())
+	row := map[string]interface{}{}
+	assert.True(t, stmt.MapNext(row))
+	assert.Equal(t, map[string]interface{}{"total": int64(2)}, row)
+	assert.False(t, stmt.MapNext(row))
+
+	assert.False(t, stmt.NextResultSet())
+	assert.NoError(t, stmt.Err())
+
+	// errors advancing to the next set
+	stmt, err = database.Prepare("CALL broken_report()")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+	for stmt.Next(&id) {
+	}
+	assert.False(t, stmt.NextResultSet())
+	assert.EqualError(t, stmt.Err(), "ORA-01001: invalid cursor")
+	assert.Equal(t, stmt.Err(), stmt.LastErr())
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PoolStats is a serializable summary of the connection pool statistics, see
+// DB.StatsSnapshot.
+type PoolStats struct {
+	// The number of established connections, both in use and idle.
+	Open int `json:"open"`
+
+	// The number of connections currently in use.
+	InUse int `json:"in_use"`
+
+	// The number of idle connections.
+	Idle int `json:"idle"`
+
+	// The total number of times a connection was waited for.
+	WaitCount int64 `json:"wait_count"`
+
+	// The total time spent waiting for a connection.
+	WaitDuration time.Duration `json:"wait_duration"`
+}
+
+// Stats returns the current connection pool statistics, or a zero value if
+// the database isn't connected.
+// https://golang.org/pkg/database/sql/#DB.Stats
+func (db *DB) Stats() sql.DBStats {
+	if nil == db || nil == db.pool() {
+		return sql.DBStats{}
+	}
+	return db.pool().Stats()
+}
+
+// StatsSnapshot returns a summary of the current connection pool statistics
+// for reporting to a metrics pipeline, or a zero value if the database isn't
+// connected.
+func (db *DB) StatsSnapshot() PoolStats {
+	stats := db.Stats()
+	return PoolStats{
+		Open:         stats.OpenConnections,
+		InUse:        stats.InUse,
+		Idle:         stats.Idle,
+		WaitCount:    stats.WaitCount,
+		WaitDuration: stats.WaitDuration,
+	}
+}
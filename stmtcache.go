@@ -0,0 +1,144 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// stmtCacheEntry is a prepared statement cache entry, see
+// Config.StmtCacheSize.
+type stmtCacheEntry struct {
+	key  string
+	stmt *sql.Stmt
+}
+
+// cachedStmt returns the statement prepared on the connection pool for a
+// query, preparing it on first use, see Config.StmtCacheSize. The query is
+// prepared without holding the cache lock, so a slow prepare doesn't block
+// other queries; if another caller cached the same query meanwhile, the
+// duplicate is closed. Once the cache is full, the least recently used
+// statement is evicted and closed.
+func (db *DB) cachedStmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	key := query
+	if db.Config().StatementCacheNormalize {
+		key = normalizeSQL(query, "mysql" == db.Config().DriverType)
+	}
+
+	db.stmtCacheMu.Lock()
+	if elem, ok := db.stmtCache[key]; ok {
+		db.stmtCacheLRU.MoveToFront(elem)
+		db.stmtCacheMu.Unlock()
+		return elem.Value.(*stmtCacheEntry).stmt, nil
+	}
+	db.stmtCacheMu.Unlock()
+
+	span := db.startSpan(ctx, "prepare", query)
+	start := time.Now()
+	stmt, err := db.pool().PrepareContext(ctx, db.Config().driverSQL(query))
+	db.endSpan(span, err)
+	if nil != db.metrics {
+		db.metrics.recordPrepare(ctx, query, time.Since(start))
+	}
+	if nil != err {
+		return nil, err
+	}
+
+	db.stmtCacheMu.Lock()
+	defer db.stmtCacheMu.Unlock()
+	if elem, ok := db.stmtCache[key]; ok {
+		_ = stmt.Close()
+		db.stmtCacheLRU.MoveToFront(elem)
+		return elem.Value.(*stmtCacheEntry).stmt, nil
+	}
+	if nil == db.stmtCache {
+		db.stmtCache = map[string]*list.Element{}
+		db.stmtCacheLRU = list.New()
+	}
+	db.stmtCache[key] = db.stmtCacheLRU.PushFront(&stmtCacheEntry{key, stmt})
+	for db.Config().StmtCacheSize < db.stmtCacheLRU.Len() {
+		entry := db.stmtCacheLRU.Remove(db.stmtCacheLRU.Back()).(*stmtCacheEntry)
+		delete(db.stmtCache, entry.key)
+		_ = entry.stmt.Close()
+	}
+	return stmt, nil
+}
+
+// ClearStmtCache closes and forgets the statements in the prepared statement
+// cache, see Config.StmtCacheSize. It's called when the connection pool they
+// were prepared on is replaced or closed. Statements already in use by open
+// transactions remain usable until the transaction ends.
+func (db *DB) ClearStmtCache() {
+	db.stmtCacheMu.Lock()
+	defer db.stmtCacheMu.Unlock()
+	for _, elem := range db.stmtCache {
+		_ = elem.Value.(*stmtCacheEntry).stmt.Close()
+	}
+	db.stmtCache = nil
+	db.stmtCacheLRU = nil
+}
+
+// normalizeSQL strips the comments from a query and collapses runs of
+// whitespace into a single space, so queries that differ only in formatting
+// produce the same string. Quoted strings and identifiers, and `/*+ ... */`
+// optimizer hints, are left unchanged. If backslash is set, backslashes
+// escape the next character in quoted strings, as in MySQL, so `'it\'s'` is
+// a single string.
+func normalizeSQL(query string, backslash bool) string {
+	var out strings.Builder
+	out.Grow(len(query))
+	space := false
+	for a := 0; a < len(query); a++ {
+		c := query[a]
+		switch {
+		case '\'' == c || '"' == c || '`' == c:
+			end := a + 1
+			for end < len(query) && query[end] != c {
+				if backslash && '`' != c && '\\' == query[end] {
+					end++
+				}
+				end++
+			}
+			end = min(end, len(query))
+			if end < len(query) {
+				end++
+			}
+			if space && 0 < out.Len() {
+				out.WriteByte(' ')
+			}
+			space = false
+			out.WriteString(query[a:end])
+			a = end - 1
+		case '-' == c && a+1 < len(query) && '-' == query[a+1]:
+			for a < len(query) && query[a] != '\n' {
+				a++
+			}
+			space = true
+		case '/' == c && a+1 < len(query) && '*' == query[a+1]:
+			start := a
+			for a += 2; a+1 < len(query) && !('*' == query[a] && '/' == query[a+1]); a++ {
+			}
+			a++
+			if start+2 < len(query) && '+' == query[start+2] {
+				if space && 0 < out.Len() {
+					out.WriteByte(' ')
+				}
+				space = false
+				out.WriteString(query[start:min(a+1, len(query))])
+				continue
+			}
+			space = true
+		case ' ' == c || '\t' == c || '\n' == c || '\r' == c:
+			space = true
+		default:
+			if space && 0 < out.Len() {
+				out.WriteByte(' ')
+			}
+			space = false
+			out.WriteByte(c)
+		}
+	}
+	return strings.TrimRight(out.String(), "; ")
+}
@@ -0,0 +1,177 @@
+package db_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStatementCache tests reusing prepared statements across transactions.
+func TestStatementCache(t *testing.T) {
+	queries := []string{
+		"SELECT id FROM users WHERE name = :name",
+		"SELECT id\n  FROM users -- by name\n WHERE name = :name;",
+		"SELECT id FROM users WHERE name = 'a  b'",
+		"SELECT id FROM users WHERE name = 'a b'",
+		"SELECT /*+ INDEX(users users_name_idx) */ id FROM users WHERE name = :name",
+		"SELECT /*+ FULL(users) */ id FROM users WHERE name = :name",
+		"SELECT  /*+ FULL(users) */\n  id FROM users WHERE name = :name",
+	}
+	tests := []struct {
+		normalize bool
+		prepares  int
+	}{
+		{false, 7},
+		{true, 5},
+	}
+
+	for _, test := range tests {
+		database, stub := newStubDB(t, func(cfg *db.Config) {
+			cfg.StmtCacheSize = 100
+			cfg.StatementCacheNormalize = test.normalize
+		})
+		for _, query := range append(queries, queries...) {
+			stmt, err := database.Prepare(query)
+			assert.NoError(t, err)
+			_, err = stmt.Query()
+			assert.NoError(t, err)
+			assert.NoError(t, stmt.Close())
+		}
+
+		assert.Len(t, prepares(stub), test.prepares, test.normalize)
+	}
+}
+
+// TestStatementCacheNormalizeEscapes tests that whitespace in MySQL string
+// literals with backslash escapes isn't collapsed.
+func TestStatementCacheNormalizeEscapes(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "mysql"
+		cfg.StmtCacheSize = 100
+		cfg.StatementCacheNormalize = true
+	})
+	for _, query := range []string{
+		`SELECT id FROM notes WHERE body = 'it\'s  a'`,
+		`SELECT id FROM notes WHERE body = 'it\'s a'`,
+		`SELECT id  FROM notes WHERE body = 'it\'s a'`,
+		`SELECT id FROM notes WHERE body = "say \"hi  there\""`,
+		`SELECT id FROM notes WHERE body = "say \"hi there\""`,
+	} {
+		stmt, err := database.Prepare(query)
+		assert.NoError(t, err)
+		_, err = stmt.Query()
+		assert.NoError(t, err)
+		assert.NoError(t, stmt.Close())
+	}
+	assert.Equal(t, []string{
+		`SELECT id FROM notes WHERE body = 'it\'s  a'`,
+		`SELECT id FROM notes WHERE body = 'it\'s a'`,
+		`SELECT id FROM notes WHERE body = "say \"hi  there\""`,
+		`SELECT id FROM notes WHERE body = "say \"hi there\""`,
+	}, prepares(stub))
+}
+
+// TestStmtCacheSize tests evicting the least recently used statements and
+// clearing the statement cache.
+func TestStmtCacheSize(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.StmtCacheSize = 2
+	})
+	query := func(query string) {
+		stmt, err := database.Prepare(query)
+		assert.NoError(t, err)
+		_, err = stmt.Query()
+		assert.NoError(t, err)
+		assert.NoError(t, stmt.Close())
+	}
+
+	for _, q := range []string{"SELECT 1", "SELECT 2", "SELECT 1", "SELECT 3", "SELECT 2", "SELECT 1"} {
+		query(q)
+	}
+	assert.Equal(t, []string{"SELECT 1", "SELECT 2", "SELECT 3", "SELECT 2", "SELECT 1"}, prepares(stub))
+
+	// cleared statements are prepared again
+	database.ClearStmtCache()
+	query("SELECT 1")
+	assert.Equal(t, []string{"SELECT 1", "SELECT 2", "SELECT 3", "SELECT 2", "SELECT 1", "SELECT 1"}, prepares(stub))
+
+	// statements in use remain usable once evicted
+	inUse, err := database.Prepare("SELECT 4")
+	assert.NoError(t, err)
+	defer inUse.Close()
+	query("SELECT 5")
+	query("SELECT 6")
+	_, err = inUse.Query()
+	assert.NoError(t, err)
+}
+
+// TestStmtCacheConcurrentPrepare tests that queries are prepared for the
+// statement cache without blocking each other.
+func TestStmtCacheConcurrentPrepare(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.StmtCacheSize = 10
+	})
+	stub.PrepareDelay = 100 * time.Millisecond
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, query := range []string{"SELECT 1", "SELECT 2", "SELECT 3", "SELECT 4"} {
+		wg.Add(1)
+		go func(query string) {
+			defer wg.Done()
+			stmt, err := database.Prepare(query)
+			assert.NoError(t, err)
+			_, err = stmt.Query()
+			assert.NoError(t, err)
+			assert.NoError(t, stmt.Close())
+		}(query)
+	}
+	wg.Wait()
+	// each statement is also prepared on its transaction's connection
+	assert.Less(t, time.Since(start), 300*time.Millisecond)
+}
+
+// BenchmarkStatementCache compares preparing the same query in a loop with
+// and without the statement cache, reporting driver prepares per operation.
+func BenchmarkStatementCache(b *testing.B) {
+	for _, size := range []int{0, 100} {
+		b.Run(fmt.Sprintf("StmtCacheSize=%d", size), func(b *testing.B) {
+			database, stub := newStubDB(b, func(cfg *db.Config) {
+				cfg.StmtCacheSize = size
+			})
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for a := 0; a < b.N; a++ {
+				stmt, err := database.Prepare("SELECT id, name FROM users WHERE id = :id")
+				if nil != err {
+					b.Fatal(err)
+				}
+				if _, err = stmt.Bind("id", a).Query(); nil != err {
+					b.Fatal(err)
+				}
+				if err = stmt.Close(); nil != err {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(len(prepares(stub)))/float64(b.N), "prepares/op")
+		})
+	}
+}
+
+// prepares returns the queries prepared by the stub driver, in order.
+func prepares(stub *stubDriver) []string {
+	queries := []string{}
+	for _, entry := range stub.Log() {
+		if query, ok := strings.CutPrefix(entry, "prepare: "); ok {
+			queries = append(queries, query)
+		}
+	}
+	return queries
+}
@@ -0,0 +1,396 @@
+package db
+
+import (
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/bdlm/errors/v2"
+	"github.com/bdlm/log/v2"
+)
+
+// DefaultFieldMapper defines the acronyms recognized when converting
+// snake_case column names to CamelCase field names. See Config.FieldMapper.
+var DefaultFieldMapper = map[string]string{
+	"api":  "API",
+	"html": "HTML",
+	"http": "HTTP",
+	"id":   "ID",
+	"ip":   "IP",
+	"json": "JSON",
+	"sql":  "SQL",
+	"uri":  "URI",
+	"url":  "URL",
+	"uuid": "UUID",
+	"xml":  "XML",
+}
+
+// StructNext prepares the next result row for reading and copies its columns
+// into the fields of the struct pointed at by dest, see StructScan. It returns
+// true on success, or false if there is no next result row or an error
+// happened while preparing or scanning it. Statement.LastErr should be
+// consulted to distinguish between the two cases.
+// https://golang.org/pkg/database/sql/#Rows.Next
+func (statement *Statement) StructNext(dest interface{}) bool {
+	if nil == statement.rows {
+		statement.lastErr = errors.Errorf("no cursor found. did you remember to run `statement.Query()`?")
+		log.WithError(statement.lastErr).Error("cursor not found")
+		return false
+	}
+	if !statement.rows.Next() {
+		err := statement.Err()
+		if nil != err {
+			statement.lastErr = err
+		}
+		return false
+	}
+
+	return nil == statement.StructScan(dest)
+}
+
+// StructScan copies the columns in the current row into the fields of the
+// struct pointed at by dest. Columns are mapped to fields using `db` struct
+// tags, falling back to the field name when no tag is present. Untagged
+// fields match the column name exactly, then its CamelCase form (created_at
+// matches CreatedAt, user_id matches UserID, see Config.FieldMapper), then
+// case-insensitively. Fields tagged `db:"-"` are ignored, and columns without
+// a matching field are discarded, or return an error if
+// Config.StrictStructScan is set.
+//
+// When column names are unreliable, such as unnamed expressions in
+// `SELECT count(*), max(x)`, fields may instead be mapped by column position
+// using an index tag:
+//
+//	type Summary struct {
+//		Count int64 `db:",idx=0"`
+//		Max   int64 `db:",idx=1"`
+//	}
+//
+// Index tags take precedence over name matching for the column at that
+// position.
+// https://golang.org/pkg/database/sql/#Rows.Scan
+func (statement *Statement) StructScan(dest interface{}) error {
+	if nil == statement.rows {
+		statement.lastErr = errors.Errorf("no cursor found. did you remember to run `statement.Query()`?")
+		return statement.lastErr
+	}
+
+	val := reflect.ValueOf(dest)
+	if reflect.Ptr != val.Kind() || val.IsNil() || reflect.Struct != val.Elem().Kind() {
+		statement.lastErr = errors.Errorf("destination must be a non-nil pointer to a struct, %T given", dest)
+		return statement.lastErr
+	}
+
+	columns, err := statement.rows.Columns()
+	if nil != err {
+		statement.lastErr = errors.Wrap(err, "failed to list result columns")
+		return statement.lastErr
+	}
+
+	fields := structFields(val.Elem().Type())
+	fields.mapper = statement.db.Cfg.FieldMapper
+	values := make([]interface{}, len(columns))
+	for a, column := range columns {
+		if index, ok := fields.position(a, column); ok {
+			values[a] = val.Elem().FieldByIndex(index).Addr().Interface()
+		} else if statement.db.Config().StrictStructScan {
+			statement.lastErr = errors.Errorf("result column '%s' has no matching field in %T", column, dest)
+			return statement.lastErr
+		} else {
+			values[a] = new(interface{})
+		}
+	}
+
+	targets, err := statement.scanTargets(values)
+	if nil != err {
+		statement.lastErr = err
+		return statement.lastErr
+	}
+	if transform := statement.db.Config().ScanTransform; nil != transform {
+		for a, column := range columns {
+			targets[a] = &transformScanner{column, targets[a], transform}
+		}
+	}
+	err = statement.rows.Scan(targets...)
+	if nil != err {
+		statement.lastErr = errors.Wrap(err, "failed to scan result values")
+		return statement.lastErr
+	}
+
+	return nil
+}
+
+// fieldMap maps column names to struct field indexes.
+type fieldMap struct {
+	// Fields keyed by tag or field name.
+	names map[string][]int
+
+	// Fields keyed by lowercase field name, for case-insensitive matching.
+	folded map[string][]int
+
+	// Fields keyed by column position, from `idx` tag options.
+	positions map[int][]int
+
+	// Word overrides for snake_case to CamelCase conversion.
+	mapper map[string]string
+}
+
+// position returns the field index mapped to the column at the given
+// position, preferring index tags over name matching.
+func (fields fieldMap) position(pos int, column string) ([]int, bool) {
+	if index, ok := fields.positions[pos]; ok {
+		return index, true
+	}
+	return fields.lookup(column)
+}
+
+// lookup returns the field index mapped to a column name.
+func (fields fieldMap) lookup(column string) ([]int, bool) {
+	if index, ok := fields.names[column]; ok {
+		return index, true
+	}
+	if index, ok := fields.names[fields.camelCase(column)]; ok {
+		return index, true
+	}
+	if index, ok := fields.folded[strings.ToLower(column)]; ok {
+		return index, true
+	}
+	index, ok := fields.folded[strings.ToLower(strings.ReplaceAll(column, "_", ""))]
+	return index, ok
+}
+
+// camelCase converts a snake_case column name to a CamelCase field name,
+// i.e. created_at to CreatedAt.
+func (fields fieldMap) camelCase(column string) string {
+	var name strings.Builder
+	for _, word := range strings.Split(column, "_") {
+		if "" == word {
+			continue
+		}
+		lower := strings.ToLower(word)
+		if mapped, ok := fields.mapper[lower]; ok {
+			name.WriteString(mapped)
+		} else if mapped, ok := DefaultFieldMapper[lower]; ok {
+			name.WriteString(mapped)
+		} else {
+			name.WriteString(strings.ToUpper(lower[:1]) + lower[1:])
+		}
+	}
+	return name.String()
+}
+
+// structFields returns the column mapping for the exported fields of a
+// struct type, including the fields of embedded structs.
+func structFields(typ reflect.Type) fieldMap {
+	fields := fieldMap{
+		names:     map[string][]int{},
+		folded:    map[string][]int{},
+		positions: map[int][]int{},
+	}
+	addStructFields(fields, typ, nil)
+	return fields
+}
+
+func addStructFields(fields fieldMap, typ reflect.Type, parent []int) {
+	for a := 0; a < typ.NumField(); a++ {
+		field := typ.Field(a)
+		index := append(append([]int{}, parent...), a)
+
+		tag, tagged := field.Tag.Lookup("db")
+		if "-" == tag {
+			continue
+		}
+		if field.Anonymous && reflect.Struct == field.Type.Kind() && !tagged {
+			addStructFields(fields, field.Type, index)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		opts := strings.Split(tag, ",")
+		for _, opt := range opts[1:] {
+			if pos, ok := strings.CutPrefix(opt, "idx="); ok {
+				if pos, err := strconv.Atoi(pos); nil == err {
+					fields.positions[pos] = index
+				}
+			}
+		}
+
+		if name := opts[0]; "" != name {
+			fields.names[name] = index
+			continue
+		}
+		if _, ok := fields.names[field.Name]; !ok {
+			fields.names[field.Name] = index
+		}
+		if _, ok := fields.folded[strings.ToLower(field.Name)]; !ok {
+			fields.folded[strings.ToLower(field.Name)] = index
+		}
+	}
+}
+
+// BindStructInsert binds the fields of the struct src, or the struct pointed
+// at by src, to named arguments for an INSERT statement. The fields mapped to
+// the given columns are bound in column order, using the same field matching
+// as StructScan. If no columns are given, all fields with a `db` tag name are
+// bound in declaration order. Each value is bound under its column name, to
+// match a `:column` placeholder:
+//
+//	columns, values, err := database.InsertLists(user)
+//	stmt, err := database.Prepare("INSERT INTO users (" + columns + ") VALUES (" + values + ")")
+//	_, err = stmt.BindStructInsert(user).Exec()
+//
+// If src isn't a struct or a column has no matching field, nothing is bound
+// and the error is available from LastErr. The error is also returned by the
+// next Exec or Query, rather than executing the statement with the values
+// bound so far.
+func (statement *Statement) BindStructInsert(src interface{}, columns ...string) *Statement {
+	columns, values, err := structInsertValues(src, columns)
+	if nil != err {
+		statement.bindErr = err
+		statement.lastErr = err
+		return statement
+	}
+	for a, column := range columns {
+		statement.Bind(column, values[a])
+	}
+	return statement
+}
+
+// InsertLists returns the comma-separated column and placeholder lists for an
+// INSERT of the struct src, for the fields BindStructInsert binds with the
+// same columns. Column names are folded according to Config.FoldIdentifiers;
+// placeholders keep the bind names.
+func (db *DB) InsertLists(src interface{}, columns ...string) (string, string, error) {
+	columns, _, err := structInsertValues(src, columns)
+	if nil != err {
+		return "", "", err
+	}
+	names := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+	for a, column := range columns {
+		names[a] = db.Config().FoldIdentifier(column)
+		placeholders[a] = ":" + column
+	}
+	return strings.Join(names, ", "), strings.Join(placeholders, ", "), nil
+}
+
+// structInsertValues returns the columns and field values to insert for a
+// struct.
+func structInsertValues(src interface{}, columns []string) ([]string, []interface{}, error) {
+	val := reflect.ValueOf(src)
+	if reflect.Ptr == val.Kind() && !val.IsNil() {
+		val = val.Elem()
+	}
+	if reflect.Struct != val.Kind() {
+		return nil, nil, errors.Errorf("source must be a struct or a non-nil pointer to a struct, %T given", src)
+	}
+
+	fields := structFields(val.Type())
+	if 0 == len(columns) {
+		columns = taggedColumns(val.Type())
+	}
+	values := make([]interface{}, len(columns))
+	for a, column := range columns {
+		index, ok := fields.lookup(column)
+		if !ok {
+			return nil, nil, errors.Errorf("no field found for column '%s' in %T", column, src)
+		}
+		values[a] = val.FieldByIndex(index).Interface()
+	}
+	return columns, values, nil
+}
+
+// taggedColumns returns the `db` tag names of the exported fields of a struct
+// type in declaration order, including the fields of embedded structs.
+func taggedColumns(typ reflect.Type) []string {
+	columns := []string{}
+	for a := 0; a < typ.NumField(); a++ {
+		field := typ.Field(a)
+		tag, tagged := field.Tag.Lookup("db")
+		if "-" == tag {
+			continue
+		}
+		if field.Anonymous && reflect.Struct == field.Type.Kind() && !tagged {
+			columns = append(columns, taggedColumns(field.Type)...)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name := strings.Split(tag, ",")[0]; "" != name {
+			columns = append(columns, name)
+		}
+	}
+	return columns
+}
+
+// NextStruct scans the current row into a new struct whose type is built
+// from the result column types, and returns a pointer to it. Each column maps
+// to an exported field of the driver's scan type for the column, with a `db`
+// tag holding the column name, so generic tooling can work with typed values
+// without defining a struct for each query:
+//
+//	for stmt.Rows().Next() {
+//		row, err := stmt.NextStruct()
+//		...
+//		json.NewEncoder(w).Encode(row)
+//	}
+//
+// Field names are derived from the column names, i.e. `created_at` becomes
+// CreatedAt; columns whose names don't produce a valid, unique field name are
+// named by position, i.e. Col1. Drivers that report a non-nullable scan type
+// for a nullable column fail to scan NULL values.
+// https://golang.org/pkg/reflect/#StructOf
+func (statement *Statement) NextStruct() (interface{}, error) {
+	if nil == statement.rows {
+		statement.lastErr = errors.Errorf("no cursor found. did you remember to run `statement.Query()`?")
+		return nil, statement.lastErr
+	}
+
+	types, err := statement.rows.ColumnTypes()
+	if nil != err {
+		statement.lastErr = errors.Wrap(err, "failed to list result column types")
+		return nil, statement.lastErr
+	}
+
+	mapper := fieldMap{mapper: statement.db.Cfg.FieldMapper}
+	names := map[string]bool{}
+	fields := make([]reflect.StructField, len(types))
+	for a, typ := range types {
+		name := mapper.camelCase(typ.Name())
+		if !token.IsIdentifier(name) || !token.IsExported(name) || names[name] {
+			name = "Col" + strconv.Itoa(a+1)
+		}
+		names[name] = true
+
+		scanType := typ.ScanType()
+		if nil == scanType {
+			scanType = reflect.TypeOf((*interface{})(nil)).Elem()
+		}
+		fields[a] = reflect.StructField{
+			Name: name,
+			Type: scanType,
+			Tag:  reflect.StructTag(`db:"` + strings.ReplaceAll(typ.Name(), `"`, "") + `"`),
+		}
+	}
+
+	dest := reflect.New(reflect.StructOf(fields))
+	values := make([]interface{}, len(fields))
+	for a := range fields {
+		values[a] = dest.Elem().Field(a).Addr().Interface()
+	}
+	targets, err := statement.scanTargets(values)
+	if nil != err {
+		statement.lastErr = err
+		return nil, statement.lastErr
+	}
+	if err = statement.rows.Scan(targets...); nil != err {
+		statement.lastErr = errors.Wrap(err, "failed to scan result values")
+		return nil, statement.lastErr
+	}
+
+	return dest.Interface(), nil
+}
@@ -0,0 +1,221 @@
+package db_test
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStructScanIndexTags tests mapping unnamed result columns to struct
+// fields by position.
+func TestStructScanIndexTags(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"count(*)", "max(x)", "label"},
+			[]driver.Value{int64(42), int64(7), "total"},
+		), nil
+	}
+
+	stmt, err := database.Prepare("SELECT count(*), max(x), label FROM t")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+
+	var summary struct {
+		Count int64  `db:",idx=0"`
+		Max   int64  `db:",idx=1"`
+		Label string `db:"label"`
+	}
+	assert.True(t, stmt.Rows().Next())
+	assert.NoError(t, stmt.StructScan(&summary))
+	assert.Equal(t, int64(42), summary.Count)
+	assert.Equal(t, int64(7), summary.Max)
+	assert.Equal(t, "total", summary.Label)
+}
+
+// TestStructScanSnakeCase tests mapping snake_case columns to CamelCase fields.
+func TestStructScanSnakeCase(t *testing.T) {
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.FieldMapper = map[string]string{"sku": "SKU"}
+	})
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"user_id", "created_at", "avatar_url", "item_sku", "display_name"},
+			[]driver.Value{int64(42), "2024-01-02", "https://example.com/a.png", "ABC-1", "Alice"},
+		), nil
+	}
+
+	stmt, err := database.Prepare("SELECT user_id, created_at, avatar_url, item_sku, display_name FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+
+	var row struct {
+		UserID    int64
+		UserId    int64
+		CreatedAt string
+		AvatarURL string
+		ItemSKU   string
+		Name      string `db:"display_name"`
+		Display   string
+	}
+	assert.True(t, stmt.Rows().Next())
+	assert.NoError(t, stmt.StructScan(&row))
+	assert.Equal(t, int64(42), row.UserID)
+	assert.Equal(t, int64(0), row.UserId)
+	assert.Equal(t, "2024-01-02", row.CreatedAt)
+	assert.Equal(t, "https://example.com/a.png", row.AvatarURL)
+	assert.Equal(t, "ABC-1", row.ItemSKU)
+	assert.Equal(t, "Alice", row.Name)
+	assert.Equal(t, "", row.Display)
+}
+
+// TestBindStructInsert tests binding struct fields to INSERT placeholders in
+// column order.
+func TestBindStructInsert(t *testing.T) {
+	var binds []string
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.FoldIdentifiers = db.FoldUpper
+	})
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		binds = []string{}
+		for _, arg := range args {
+			binds = append(binds, fmt.Sprintf("%s=%v", arg.Name, arg.Value))
+		}
+		return driver.RowsAffected(1), nil
+	}
+
+	type audit struct {
+		CreatedBy string `db:"created_by"`
+	}
+	type account struct {
+		ID    int64  `db:"id"`
+		Email string `db:"email"`
+		Notes string
+		audit
+		Secret string `db:"-"`
+	}
+	row := account{ID: 7, Email: "alice@example.com", Notes: "vip", audit: audit{"admin"}, Secret: "hunter2"}
+
+	// all tagged fields
+	columns, values, err := database.InsertLists(row)
+	assert.NoError(t, err)
+	assert.Equal(t, "ID, EMAIL, CREATED_BY", columns)
+	assert.Equal(t, ":id, :email, :created_by", values)
+
+	stmt, err := database.Prepare("INSERT INTO accounts (" + columns + ") VALUES (" + values + ")")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.BindStructInsert(&row).Exec()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id=7", "email=alice@example.com", "created_by=admin"}, binds)
+
+	// named columns
+	columns, values, err = database.InsertLists(row, "email", "notes", "id")
+	assert.NoError(t, err)
+	assert.Equal(t, "EMAIL, NOTES, ID", columns)
+	assert.Equal(t, ":email, :notes, :id", values)
+
+	_, err = stmt.BindStructInsert(row, "email", "notes", "id").Exec()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"email=alice@example.com", "notes=vip", "id=7"}, binds)
+
+	// unknown columns
+	binds = nil
+	_, err = stmt.Bind("id", 8).BindStructInsert(row, "secret").Exec()
+	assert.EqualError(t, err, "no field found for column 'secret' in db_test.account")
+	assert.Error(t, stmt.LastErr())
+	assert.Nil(t, binds)
+
+	// the error is only returned once
+	_, err = stmt.BindStructInsert(row).Exec()
+	assert.NoError(t, err)
+	_, _, err = database.InsertLists(42)
+	assert.Error(t, err)
+}
+
+// TestNextStruct tests scanning rows into a struct type built from the result
+// column types.
+func TestNextStruct(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"user_id", "display_name"},
+			[]driver.Value{int64(42), "alice"},
+		), nil
+	}
+
+	stmt, err := database.Prepare("SELECT user_id, display_name FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+
+	assert.True(t, stmt.Rows().Next())
+	row, err := stmt.NextStruct()
+	assert.NoError(t, err)
+
+	val := reflect.ValueOf(row)
+	assert.Equal(t, reflect.Ptr, val.Kind())
+	typ := val.Elem().Type()
+	if assert.Equal(t, 2, typ.NumField()) {
+		assert.Equal(t, "UserID", typ.Field(0).Name)
+		assert.Equal(t, reflect.TypeOf(int64(0)), typ.Field(0).Type)
+		assert.Equal(t, "user_id", typ.Field(0).Tag.Get("db"))
+		assert.Equal(t, "DisplayName", typ.Field(1).Name)
+		assert.Equal(t, reflect.TypeOf(""), typ.Field(1).Type)
+		assert.Equal(t, "display_name", typ.Field(1).Tag.Get("db"))
+	}
+	assert.Equal(t, int64(42), val.Elem().Field(0).Interface())
+	assert.Equal(t, "alice", val.Elem().Field(1).Interface())
+}
+
+// TestStructNext tests iterating result rows into structs.
+func TestStructNext(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Query = func(query string, args []driver.NamedValue) (*stubRows, error) {
+		return newStubRows(
+			[]string{"ID", "name", "email"},
+			[]driver.Value{int64(1), "alice", "alice@example.com"},
+			[]driver.Value{int64(2), "bob", "bob@example.com"},
+		), nil
+	}
+
+	stmt, err := database.Prepare("SELECT id, name, email FROM users")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+
+	type user struct {
+		ID   int64
+		Name string `db:"name"`
+	}
+	users := []user{}
+	var row user
+	for stmt.StructNext(&row) {
+		users = append(users, row)
+	}
+	assert.NoError(t, stmt.LastErr())
+	assert.Equal(t, []user{{1, "alice"}, {2, "bob"}}, users)
+
+	// destinations must be struct pointers
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+	assert.False(t, stmt.StructNext(row))
+	assert.Error(t, stmt.LastErr())
+
+	// strict mode rejects unmatched columns
+	database.Config().StrictStructScan = true
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+	assert.False(t, stmt.StructNext(&row))
+	assert.Contains(t, stmt.LastErr().Error(), "email")
+}
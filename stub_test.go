@@ -0,0 +1,522 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bdlm/db"
+	"github.com/bdlm/log/v2"
+	"github.com/bdlm/std/v2/logger"
+)
+
+// stubDriver is a minimal database/sql/driver implementation used to exercise
+// the package without a live database. Query and Exec handlers define the
+// results returned for each statement, and all activity is recorded in the
+// driver log for inspection.
+type stubDriver struct {
+	mu sync.Mutex
+
+	// Exec handles non-query statements. The default reports one affected
+	// row.
+	Exec func(query string, args []driver.NamedValue) (driver.Result, error)
+
+	// Query handles queries. The default returns an empty result set.
+	Query func(query string, args []driver.NamedValue) (*stubRows, error)
+
+	// CommitErr is returned by all transaction commits.
+	CommitErr error
+
+	// ExecDelay delays all executions and queries, returning early with the
+	// context's error if it's done first.
+	ExecDelay time.Duration
+
+	// OpenBlock, if set, blocks connection attempts until it's closed.
+	OpenBlock chan struct{}
+
+	// OpenErr is returned by all connection attempts.
+	OpenErr error
+
+	// PingBlock, if set, blocks connection pings until it's closed or the
+	// ping is cancelled.
+	PingBlock chan struct{}
+
+	// PingErr is returned by all connection pings.
+	PingErr error
+
+	// PingFails, if set, limits PingErr to that many pings, after which it's
+	// cleared.
+	PingFails int
+
+	// PrepareDelay delays all statement preparation.
+	PrepareDelay time.Duration
+
+	conns  int
+	dsns   []string
+	log    []string
+	opens  int
+	txOpts []driver.TxOptions
+}
+
+// Open implements driver.Driver.
+func (d *stubDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	block := d.OpenBlock
+	d.mu.Unlock()
+	if nil != block {
+		<-block
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if nil != d.OpenErr {
+		return nil, d.OpenErr
+	}
+	d.conns++
+	d.opens++
+	d.dsns = append(d.dsns, name)
+	return &stubConn{driver: d}, nil
+}
+
+// DSNs returns the DSN strings of the connections opened by the driver.
+func (d *stubDriver) DSNs() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string{}, d.dsns...)
+}
+
+// Log returns a copy of the recorded driver activity.
+func (d *stubDriver) Log() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string{}, d.log...)
+}
+
+// TxOptions returns the options of the transactions begun by the driver.
+func (d *stubDriver) TxOptions() []driver.TxOptions {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]driver.TxOptions{}, d.txOpts...)
+}
+
+// Conns returns the number of connections opened by the driver that haven't
+// been closed.
+func (d *stubDriver) Conns() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.conns
+}
+
+// Opens returns the number of connections opened by the driver.
+func (d *stubDriver) Opens() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.opens
+}
+
+// stubContextDriver is a stubDriver implementing driver.DriverContext.
+type stubContextDriver struct {
+	*stubDriver
+	connectors []string
+}
+
+// OpenConnector implements driver.DriverContext.
+func (d *stubContextDriver) OpenConnector(name string) (driver.Connector, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.connectors = append(d.connectors, name)
+	return &stubConnector{driver: d, dsn: name}, nil
+}
+
+// Connectors returns the DSN strings of the connectors opened by the driver.
+func (d *stubContextDriver) Connectors() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string{}, d.connectors...)
+}
+
+// stubConnector is the driver.Connector returned by stubContextDriver.
+type stubConnector struct {
+	driver *stubContextDriver
+	dsn    string
+}
+
+// Connect implements driver.Connector.
+func (c *stubConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+// Driver implements driver.Connector.
+func (c *stubConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+func (d *stubDriver) record(format string, args ...interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.log = append(d.log, fmt.Sprintf(format, args...))
+}
+
+// delay waits for ExecDelay, see stubDriver.ExecDelay.
+func (d *stubDriver) delay(ctx context.Context) error {
+	d.mu.Lock()
+	delay := d.ExecDelay
+	d.mu.Unlock()
+	if 0 >= delay {
+		return nil
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *stubDriver) exec(query string, args []driver.NamedValue) (driver.Result, error) {
+	d.record("exec: %s", query)
+	if nil != d.Exec {
+		return d.Exec(query, args)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (d *stubDriver) query(query string, args []driver.NamedValue) (driver.Rows, error) {
+	d.record("query: %s", query)
+	if nil != d.Query {
+		rows, err := d.Query(query, args)
+		if nil != err {
+			return nil, err
+		}
+		return rows, nil
+	}
+	return newStubRows(nil), nil
+}
+
+type stubConn struct {
+	driver *stubDriver
+	tx     *stubTx
+}
+
+func (c *stubConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *stubConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.driver.record("begin")
+	c.driver.mu.Lock()
+	c.driver.txOpts = append(c.driver.txOpts, opts)
+	c.driver.mu.Unlock()
+	c.tx = &stubTx{conn: c, readOnly: opts.ReadOnly}
+	return c.tx, nil
+}
+
+func (c *stubConn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+func (c *stubConn) Close() error {
+	c.driver.mu.Lock()
+	defer c.driver.mu.Unlock()
+	c.driver.conns--
+	return nil
+}
+
+func (c *stubConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.checkReadOnly(query); nil != err {
+		return nil, err
+	}
+	if err := c.driver.delay(ctx); nil != err {
+		return nil, err
+	}
+	return c.driver.exec(query, args)
+}
+
+func (c *stubConn) Ping(ctx context.Context) error {
+	c.driver.mu.Lock()
+	block := c.driver.PingBlock
+	c.driver.mu.Unlock()
+	if nil != block {
+		select {
+		case <-block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	c.driver.mu.Lock()
+	defer c.driver.mu.Unlock()
+	err := c.driver.PingErr
+	if nil != err && 0 < c.driver.PingFails {
+		if c.driver.PingFails--; 0 == c.driver.PingFails {
+			c.driver.PingErr = nil
+		}
+	}
+	return err
+}
+
+func (c *stubConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *stubConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	c.driver.record("prepare: %s", query)
+	c.driver.mu.Lock()
+	delay := c.driver.PrepareDelay
+	c.driver.mu.Unlock()
+	time.Sleep(delay)
+	return &stubStmt{conn: c, query: query}, nil
+}
+
+func (c *stubConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.driver.delay(ctx); nil != err {
+		return nil, err
+	}
+	return c.driver.query(query, args)
+}
+
+func (c *stubConn) checkReadOnly(query string) error {
+	if nil == c.tx || !c.tx.readOnly {
+		return nil
+	}
+	switch strings.ToLower(strings.Fields(query + " ")[0]) {
+	case "insert", "update", "delete":
+		return fmt.Errorf("cannot execute %s in a read-only transaction", strings.Fields(query)[0])
+	}
+	return nil
+}
+
+type stubStmt struct {
+	conn  *stubConn
+	query string
+}
+
+func (s *stubStmt) Close() error {
+	return nil
+}
+
+func (s *stubStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, driver.ErrSkip
+}
+
+func (s *stubStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *stubStmt) NumInput() int {
+	return -1
+}
+
+func (s *stubStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, driver.ErrSkip
+}
+
+func (s *stubStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+type stubTx struct {
+	conn     *stubConn
+	readOnly bool
+}
+
+func (tx *stubTx) Commit() error {
+	tx.conn.driver.record("commit")
+	tx.conn.tx = nil
+	tx.conn.driver.mu.Lock()
+	defer tx.conn.driver.mu.Unlock()
+	return tx.conn.driver.CommitErr
+}
+
+func (tx *stubTx) Rollback() error {
+	tx.conn.driver.record("rollback")
+	tx.conn.tx = nil
+	return nil
+}
+
+// stubRows is a static result set. Additional result sets may be chained via
+// next.
+type stubRows struct {
+	columns []string
+	types   []string
+	values  [][]driver.Value
+	next    *stubRows
+	nextErr error
+	pos     int
+}
+
+// newStubRows returns a result set with the given column names and rows.
+func newStubRows(columns []string, values ...[]driver.Value) *stubRows {
+	return &stubRows{columns: columns, values: values}
+}
+
+// WithTypes sets the database type names reported for each column.
+func (r *stubRows) WithTypes(types ...string) *stubRows {
+	r.types = types
+	return r
+}
+
+// WithNext chains an additional result set.
+func (r *stubRows) WithNext(next *stubRows) *stubRows {
+	r.next = next
+	return r
+}
+
+// WithNextErr sets the error returned when advancing past this result set.
+func (r *stubRows) WithNextErr(err error) *stubRows {
+	r.nextErr = err
+	return r
+}
+
+func (r *stubRows) Close() error {
+	return nil
+}
+
+func (r *stubRows) ColumnTypeDatabaseTypeName(index int) string {
+	if index < len(r.types) {
+		return r.types[index]
+	}
+	return ""
+}
+
+func (r *stubRows) ColumnTypeScanType(index int) reflect.Type {
+	for _, row := range r.values {
+		if index < len(row) && nil != row[index] {
+			return reflect.TypeOf(row[index])
+		}
+	}
+	return reflect.TypeOf((*interface{})(nil)).Elem()
+}
+
+func (r *stubRows) Columns() []string {
+	return r.columns
+}
+
+func (r *stubRows) HasNextResultSet() bool {
+	return nil != r.next || nil != r.nextErr
+}
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.pos])
+	r.pos++
+	return nil
+}
+
+func (r *stubRows) NextResultSet() error {
+	if nil != r.nextErr {
+		return r.nextErr
+	}
+	if nil == r.next {
+		return io.EOF
+	}
+	*r = *r.next
+	return nil
+}
+
+var stubCount int64
+
+// newStubDB registers a new stub driver and returns a connected database
+// instance using it. Config values may be adjusted with the opts functions
+// before the connection is made.
+func newStubDB(t testing.TB, opts ...func(*db.Config)) (*db.DB, *stubDriver) {
+	t.Helper()
+
+	stub := &stubDriver{}
+	name := fmt.Sprintf("stub-%d", atomic.AddInt64(&stubCount, 1))
+	sql.Register(name, stub)
+
+	cfg := &db.Config{
+		Ctx:          context.Background(),
+		DatabaseName: name,
+		Driver:       stub,
+		DriverName:   name,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	database, err := db.New(cfg)
+	if nil != err {
+		t.Fatalf("unable to create stub database: %s", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+
+	return database, stub
+}
+
+// logHook captures log entries for inspection.
+type logHook struct {
+	mu      sync.Mutex
+	entries []*log.Entry
+}
+
+func (hook *logHook) Levels() []logger.Level {
+	return []logger.Level{
+		logger.Panic,
+		logger.Fatal,
+		logger.Error,
+		logger.Warn,
+		logger.Info,
+		logger.Debug,
+	}
+}
+
+func (hook *logHook) Fire(entry *log.Entry) error {
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	hook.entries = append(hook.entries, entry)
+	return nil
+}
+
+// Entries returns the captured entries with the given message.
+func (hook *logHook) Entries(msg string) []*log.Entry {
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	entries := []*log.Entry{}
+	for _, entry := range hook.entries {
+		if msg == entry.Message {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+var (
+	logs     = &logHook{}
+	logsOnce sync.Once
+)
+
+// captureLogs starts capturing log entries, discarding any captured by
+// previous tests.
+func captureLogs(t testing.TB) *logHook {
+	logsOnce.Do(func() { log.AddHook(logs) })
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+	logs.entries = nil
+	return logs
+}
+
+// stubResult is an Exec result reporting a generated id.
+type stubResult struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func (r stubResult) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+func (r stubResult) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	nr "github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// Span describes a traced database call, see Tracer.
+type Span struct {
+	// Database name, see Config.DatabaseName.
+	Database string
+
+	// Database driver name and type, see Config.DriverName and
+	// Config.DriverType.
+	DriverName string
+	DriverType string
+
+	// The call being traced: "prepare", "exec", "query", or the end of a
+	// transaction, "commit" or "rollback".
+	Kind string
+
+	// The lowercase operation and target table of the query, see
+	// ParseStatement. Empty for commits and rollbacks.
+	Operation string
+	Table     string
+
+	// The SQL query string without comments, normalized if
+	// Config.NormalizeMetrics is set. Empty for commits and rollbacks.
+	Query string
+}
+
+// Tracer traces the database calls made by the package: preparing,
+// executing, and querying statements, and committing and rolling back
+// transactions. See Config.Tracer.
+type Tracer interface {
+	// StartSpan starts a span for a call and returns a context carrying it.
+	StartSpan(ctx context.Context, span Span) context.Context
+
+	// EndSpan ends the span carried by a context returned by StartSpan,
+	// recording err if the call failed.
+	EndSpan(ctx context.Context, err error)
+}
+
+// NewRelicTracer records statement preparation as "prepare" datastore
+// segments of the New Relic transaction carried by the context. It's used
+// automatically when Config.NewRelic is set. Exec and query segments are
+// recorded by the instrumented driver instead, see InstrumentSQLDriver.
+type NewRelicTracer struct{}
+
+// StartSpan implements Tracer.
+func (NewRelicTracer) StartSpan(ctx context.Context, span Span) context.Context {
+	nrtxn := nr.FromContext(ctx)
+	if nil == nrtxn || "prepare" != span.Kind {
+		return ctx
+	}
+	return context.WithValue(ctx, newRelicSegmentKey{}, &nr.DatastoreSegment{
+		StartTime:          nrtxn.StartSegmentNow(),
+		Product:            nr.DatastoreProduct(span.DriverName),
+		Collection:         span.Table,
+		Operation:          span.Kind,
+		DatabaseName:       span.Database,
+		ParameterizedQuery: span.Query,
+	})
+}
+
+// EndSpan implements Tracer.
+func (NewRelicTracer) EndSpan(ctx context.Context, err error) {
+	if segment, ok := ctx.Value(newRelicSegmentKey{}).(*nr.DatastoreSegment); ok {
+		segment.End()
+	}
+}
+
+// newRelicSegmentKey is the context key of the segment started by
+// NewRelicTracer.
+type newRelicSegmentKey struct{}
+
+// startSpan starts a span for a call with each configured tracer: the
+// NewRelicTracer if Config.NewRelic is set, and Config.Tracer. The returned
+// context must be passed to endSpan.
+func (db *DB) startSpan(ctx context.Context, kind, query string) context.Context {
+	cfg := db.Config()
+	if cfg.DisableInstrumentation || (nil == cfg.NewRelic && nil == cfg.Tracer) {
+		return ctx
+	}
+
+	span := Span{
+		Database:   cfg.DatabaseName,
+		DriverName: cfg.DriverName,
+		DriverType: cfg.DriverType,
+		Kind:       kind,
+		Query:      cleanQuery(query),
+	}
+	if cfg.NormalizeMetrics {
+		span.Query = NormalizeQuery(span.Query)
+	}
+	span.Operation, span.Table = ParseStatement(query)
+
+	if nil != cfg.NewRelic {
+		ctx = NewRelicTracer{}.StartSpan(ctx, span)
+	}
+	if nil != cfg.Tracer {
+		ctx = cfg.Tracer.StartSpan(ctx, span)
+	}
+	return ctx
+}
+
+// endSpan ends the spans started by startSpan.
+func (db *DB) endSpan(ctx context.Context, err error) {
+	cfg := db.Config()
+	if cfg.DisableInstrumentation {
+		return
+	}
+	if nil != cfg.Tracer {
+		cfg.Tracer.EndSpan(ctx, err)
+	}
+	if nil != cfg.NewRelic {
+		NewRelicTracer{}.EndSpan(ctx, err)
+	}
+}
+
+// endTx commits or rolls back a transaction according to kind, "commit" or
+// "rollback", traced as a span of that kind. Writes held for the OnWrite hook
+// are reported if the transaction committed.
+func (db *DB) endTx(ctx context.Context, kind string, txn *sql.Tx) error {
+	fn := txn.Rollback
+	if "commit" == kind {
+		fn = txn.Commit
+	}
+	span := db.startSpan(ctx, kind, "")
+	err := fn()
+	db.endSpan(span, err)
+	db.untrackTx(txn)
+	db.endWrites(txn, "commit" == kind && nil == err)
+	return err
+}
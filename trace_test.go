@@ -0,0 +1,74 @@
+package db_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/bdlm/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// spanRecorder is a db.Tracer that records the spans it ends.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []string
+}
+
+type spanKey struct{}
+
+// StartSpan implements db.Tracer.
+func (recorder *spanRecorder) StartSpan(ctx context.Context, span db.Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// EndSpan implements db.Tracer.
+func (recorder *spanRecorder) EndSpan(ctx context.Context, err error) {
+	span := ctx.Value(spanKey{}).(db.Span)
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.spans = append(recorder.spans, fmt.Sprintf("%s %s %s: %s (%v)", span.Kind, span.Operation, span.Table, span.Query, err))
+}
+
+// TestTracer tests tracing statement preparation, execution, queries,
+// commits, and rollbacks.
+func TestTracer(t *testing.T) {
+	recorder := &spanRecorder{}
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.Tracer = recorder
+	})
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		if "DELETE FROM users" == query {
+			return nil, fmt.Errorf("permission denied")
+		}
+		return driver.RowsAffected(1), nil
+	}
+
+	stmt, err := database.Prepare("UPDATE users SET active = 1 /* bulk */")
+	assert.NoError(t, err)
+	_, err = stmt.Exec()
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Commit())
+	assert.NoError(t, stmt.Close())
+
+	stmt, err = database.Prepare("SELECT id FROM users")
+	assert.NoError(t, err)
+	_, err = stmt.Query()
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Close())
+
+	_, err = database.Exec("DELETE FROM users")
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{
+		"prepare update users: UPDATE users SET active = 1  (<nil>)",
+		"exec update users: UPDATE users SET active = 1  (<nil>)",
+		"commit  :  (<nil>)",
+		"prepare select users: SELECT id FROM users (<nil>)",
+		"query select users: SELECT id FROM users (<nil>)",
+		"rollback  :  (<nil>)",
+		"exec delete users: DELETE FROM users (permission denied)",
+	}, recorder.spans)
+}
@@ -0,0 +1,326 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bdlm/errors/v2"
+	nr "github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// tempTableNameRegex matches valid temporary table names. Names are emitted
+// unquoted, so they're limited to plain identifiers.
+var tempTableNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// oraclePTTPrefix is the default prefix Oracle requires of private temporary
+// table names, see CreateTempTable.
+const oraclePTTPrefix = "ORA$PTT_"
+
+// Transaction runs fn inside a new transaction. The transaction is committed
+// if fn returns nil and rolled back if fn returns an error or panics.
+func (db *DB) Transaction(ctx context.Context, fn func(*sql.Tx) error) (err error) {
+	txn, err := db.BeginTx(ctx, nil)
+	if nil != err {
+		return errors.Wrap(err, "unable to initialize database transaction")
+	}
+	db.trackTx(ctx, txn)
+
+	defer func() {
+		if p := recover(); nil != p {
+			_ = txn.Rollback()
+			db.untrackTx(txn)
+			db.endWrites(txn, false)
+			panic(p)
+		}
+	}()
+
+	if err = fn(txn); nil != err {
+		if err2 := db.endTx(ctx, "rollback", txn); nil != err2 {
+			return errors.WrapE(err, err2)
+		}
+		return err
+	}
+
+	return db.endTx(ctx, "commit", txn)
+}
+
+// TransactionRetry runs fn inside a new transaction like Transaction, retrying
+// up to attempts times in total when the transaction fails with a deadlock or
+// serialization failure (see ClassifyError). Each attempt rolls back and runs
+// fn from the beginning in a new transaction, so fn must not depend on state
+// from a previous attempt. Other errors are returned immediately. fn is always
+// run at least once.
+//
+// Retries are immediate unless Config.TxRetryDelay is set, and stop once
+// Config.RetryMaxElapsed is exceeded. Each deadlock retried is logged at the
+// warning level; the query that deadlocked isn't known here, see RetryPolicy
+// for retrying single statements.
+func (db *DB) TransactionRetry(ctx context.Context, attempts int, fn func(*sql.Tx) error) error {
+	var err error
+	if attempts < 1 {
+		attempts = 1
+	}
+	policy := db.Config().txRetryPolicy()
+	start := time.Now()
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = db.Transaction(ctx, fn); nil == err {
+			return nil
+		}
+		if !ClassifyError(err).Retryable() {
+			return err
+		}
+		if nil != ctx.Err() {
+			return errors.WrapE(err, ctx.Err())
+		}
+		if attempt == attempts {
+			break
+		}
+		delay := policy.Delay(attempt)
+		if db.Config().retryExhausted(start, delay) {
+			return errors.Wrap(err, "transaction failed after %d attempts, exceeding %s", attempt, db.Config().RetryMaxElapsed)
+		}
+		if ErrorClassDeadlock == ClassifyError(err) {
+			db.logDeadlockRetry(err, "", attempt, delay)
+		}
+		if err2 := wait(ctx, delay); nil != err2 {
+			return errors.WrapE(err, err2)
+		}
+	}
+	return errors.Wrap(err, "transaction failed after %d attempts", attempts)
+}
+
+// Tx defines a database transaction shared by multiple statements.
+//
+// Statements prepared by a Tx run in the shared transaction and don't roll it
+// back when closed; the transaction is finished with Commit or Rollback.
+type Tx struct {
+	ctx context.Context
+
+	// Reference to the database instance that spawned this transaction
+	db *DB
+
+	// Set once the transaction has been committed or rolled back
+	finished int32
+
+	// The NewRelic transaction agent
+	nrtxn *nr.Transaction
+
+	// Running total of rows affected by statements executed in the
+	// transaction
+	rowsAffected int64
+
+	// The transaction instance from the database/sql package
+	// https://golang.org/pkg/database/sql/#Tx
+	txn *sql.Tx
+}
+
+// Begin is the constructor for Tx instances. If a New Relic application has
+// been provided, transaction metrics will be written there.
+// https://golang.org/pkg/database/sql/#DB.BeginTx
+func (db *DB) Begin(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	ctx, nrtxn := db.startNewRelic(ctx)
+
+	txn, err := db.BeginTx(ctx, opts)
+	if nil != err {
+		if nil != nrtxn {
+			nrtxn.End()
+		}
+		return nil, errors.Wrap(err, "unable to initialize database transaction")
+	}
+	db.trackTx(ctx, txn)
+
+	return &Tx{
+		ctx:   ctx,
+		db:    db,
+		nrtxn: nrtxn,
+		txn:   txn,
+	}, nil
+}
+
+// Commit commits the transaction.
+// https://golang.org/pkg/database/sql/#Tx.Commit
+func (tx *Tx) Commit() error {
+	defer tx.end()
+	return tx.db.endTx(tx.ctx, "commit", tx.txn)
+}
+
+// CreateTempTable creates a temporary table to stage data in, i.e. for ETL
+// steps. columns is the column definition list, i.e. "id INT, name TEXT",
+// and is emitted as-is, so it must not contain untrusted input. Names must be
+// plain identifiers, and are folded according to Config.FoldIdentifiers. The
+// DDL depends on the DriverType:
+//
+//   - "mysql": `CREATE TEMPORARY TABLE`, dropped when the connection closes.
+//   - "oracle": `CREATE PRIVATE TEMPORARY TABLE ... ON COMMIT DROP
+//     DEFINITION`, dropped when the transaction ends, requires 18c or later.
+//     The name must start with the ORA$PTT_ prefix Oracle requires of
+//     private temporary tables, i.e. "ORA$PTT_staged_users". Unlike other
+//     DDL it doesn't commit the transaction.
+//   - "postgres": `CREATE TEMP TABLE ... ON COMMIT DROP`, dropped when the
+//     transaction ends.
+//   - "sqlite": `CREATE TEMP TABLE`, dropped when the connection closes.
+func (tx *Tx) CreateTempTable(ctx context.Context, name string, columns string) error {
+	var format string
+	switch tx.db.Config().DriverType {
+	case "mysql":
+		format = "CREATE TEMPORARY TABLE %s (%s)"
+	case "oracle":
+		format = "CREATE PRIVATE TEMPORARY TABLE %s (%s) ON COMMIT DROP DEFINITION"
+	case "postgres":
+		format = "CREATE TEMP TABLE %s (%s) ON COMMIT DROP"
+	case "sqlite":
+		format = "CREATE TEMP TABLE %s (%s)"
+	default:
+		return errors.Errorf("temporary tables are not supported for driver type '%s'", tx.db.Config().DriverType)
+	}
+	ident := name
+	if "oracle" == tx.db.Config().DriverType {
+		if !strings.HasPrefix(strings.ToUpper(name), oraclePTTPrefix) {
+			return errors.Errorf("oracle temporary table name '%s' must start with %s", name, oraclePTTPrefix)
+		}
+		ident = name[len(oraclePTTPrefix):]
+	}
+	if !tempTableNameRegex.MatchString(ident) {
+		return errors.Errorf("invalid temporary table name '%s'", name)
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(format, tx.db.Config().FoldIdentifier(name), columns)); nil != err {
+		return errors.Wrap(err, "unable to create temporary table '%s'", name)
+	}
+	return nil
+}
+
+// Exec executes a query that doesn't return rows in the transaction.
+// https://golang.org/pkg/database/sql/#Tx.Exec
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.ExecContext(tx.ctx, query, args...)
+}
+
+// ExecContext executes a query that doesn't return rows in the transaction.
+// https://golang.org/pkg/database/sql/#Tx.ExecContext
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	tx.db.logQuery(ctx, query)
+	start := time.Now()
+	span := tx.db.startSpan(ctx, "exec", query)
+	result, err := tx.txn.ExecContext(ctx, query, args...)
+	tx.db.endSpan(span, err)
+	tx.db.observe(ctx, query, args, start, err)
+	if nil == err {
+		tx.db.onWrite(tx.txn, query, result)
+		tx.addRowsAffected(result)
+	}
+	return result, err
+}
+
+// Prepare is the constructor for Statement instances that run in the
+// transaction.
+func (tx *Tx) Prepare(query string) (*Statement, error) {
+	return tx.PrepareContext(tx.ctx, query)
+}
+
+// PrepareContext is the constructor for Statement instances that run in the
+// transaction. The statement isn't prepared on the server if
+// Config.DisableServerPrepare is set.
+// https://golang.org/pkg/database/sql/#Tx.PrepareContext
+func (tx *Tx) PrepareContext(ctx context.Context, query string) (*Statement, error) {
+	var stmt *sql.Stmt
+	if tx.db.Config().serverPrepare() {
+		var err error
+		stmt, err = tx.db.prepare(ctx, tx.txn, query)
+		if nil != err {
+			return nil, errors.Wrap(err, "error preparing statement")
+		}
+	}
+
+	return &Statement{
+		nil,
+		nil,
+		make([]sql.NamedArg, 0),
+		nil,
+		ctx,
+		tx.db,
+		false,
+		false,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		query,
+		stmt,
+		0,
+		nil,
+		tx,
+		tx.txn,
+	}, nil
+}
+
+// Rollback aborts the transaction.
+// https://golang.org/pkg/database/sql/#Tx.Rollback
+func (tx *Tx) Rollback() error {
+	defer tx.end()
+	return tx.db.endTx(tx.ctx, "rollback", tx.txn)
+}
+
+// PendingRows returns the number of rows affected by the statements executed
+// in the transaction that haven't been committed yet, i.e. to report progress
+// during a long batch job. It returns 0 once the transaction has been
+// committed or rolled back; RowsAffected keeps the final total.
+func (tx *Tx) PendingRows() int64 {
+	if 0 != atomic.LoadInt32(&tx.finished) {
+		return 0
+	}
+	return atomic.LoadInt64(&tx.rowsAffected)
+}
+
+// RowsAffected returns the total number of rows affected by the statements
+// executed in the transaction so far. Results from drivers that don't report
+// rows affected are not counted.
+func (tx *Tx) RowsAffected() int64 {
+	return atomic.LoadInt64(&tx.rowsAffected)
+}
+
+// SetConstraintsDeferred defers checking deferrable constraints, such as
+// foreign keys declared DEFERRABLE, until the transaction is committed. This
+// allows bulk loads of rows with circular references. Requires an "oracle" or
+// "postgres" DriverType; mysql doesn't support deferred constraints.
+func (tx *Tx) SetConstraintsDeferred(ctx context.Context) error {
+	switch tx.db.Config().DriverType {
+	case "oracle", "postgres":
+	default:
+		return errors.Errorf("deferred constraints are not supported for driver type '%s'", tx.db.Config().DriverType)
+	}
+	if _, err := tx.ExecContext(ctx, "SET CONSTRAINTS ALL DEFERRED"); nil != err {
+		return errors.Wrap(err, "unable to defer constraints")
+	}
+	return nil
+}
+
+// Tx returns the internal sql.Tx pointer.
+func (tx *Tx) Tx() *sql.Tx {
+	return tx.txn
+}
+
+// addRowsAffected adds the rows affected by a statement result to the
+// running total.
+func (tx *Tx) addRowsAffected(result sql.Result) {
+	if nil == result {
+		return
+	}
+	if rowsAffected, err := result.RowsAffected(); nil == err && 0 < rowsAffected {
+		atomic.AddInt64(&tx.rowsAffected, rowsAffected)
+	}
+}
+
+// end marks the transaction finished and ends the NewRelic transaction, if
+// any.
+func (tx *Tx) end() {
+	atomic.StoreInt32(&tx.finished, 1)
+	if nil != tx.nrtxn {
+		tx.nrtxn.End()
+	}
+}
@@ -0,0 +1,299 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bdlm/db"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTransaction tests committing and rolling back transactions.
+func TestTransaction(t *testing.T) {
+	database, stub := newStubDB(t)
+
+	err := database.Transaction(context.Background(), func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO users (name) VALUES ('alice')")
+		return err
+	})
+	assert.NoError(t, err)
+
+	fnErr := fmt.Errorf("failed")
+	err = database.Transaction(context.Background(), func(tx *sql.Tx) error {
+		return fnErr
+	})
+	assert.Equal(t, fnErr, err)
+
+	assert.Equal(t, []string{
+		"begin",
+		"exec: INSERT INTO users (name) VALUES ('alice')",
+		"commit",
+		"begin",
+		"rollback",
+	}, stub.Log())
+}
+
+// TestTransactionRetry tests retrying transactions that fail with a deadlock.
+func TestTransactionRetry(t *testing.T) {
+	database, stub := newStubDB(t)
+	deadlocks := 1
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		if deadlocks > 0 {
+			deadlocks--
+			return nil, &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+		}
+		return driver.RowsAffected(1), nil
+	}
+
+	// a deadlock on the first attempt is retried
+	attempts := 0
+	err := database.TransactionRetry(context.Background(), 3, func(tx *sql.Tx) error {
+		attempts++
+		_, err := tx.Exec("UPDATE accounts SET balance = balance - 1")
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, []string{
+		"begin",
+		"exec: UPDATE accounts SET balance = balance - 1",
+		"rollback",
+		"begin",
+		"exec: UPDATE accounts SET balance = balance - 1",
+		"commit",
+	}, stub.Log())
+
+	// other errors are not retried
+	attempts = 0
+	err = database.TransactionRetry(context.Background(), 3, func(tx *sql.Tx) error {
+		attempts++
+		return fmt.Errorf("constraint violation")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+
+	// retries stop after the configured number of attempts
+	attempts = 0
+	deadlocks = 5
+	err = database.TransactionRetry(context.Background(), 3, func(tx *sql.Tx) error {
+		attempts++
+		_, err := tx.Exec("UPDATE accounts SET balance = balance - 1")
+		return err
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+// TestTxRowsAffected tests totaling rows affected across the statements in a
+// shared transaction.
+func TestTxRowsAffected(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		switch query {
+		case "INSERT INTO users (name) SELECT name FROM legacy_users":
+			return driver.RowsAffected(3), nil
+		case "CREATE INDEX users_name ON users (name)":
+			return driver.ResultNoRows, nil
+		}
+		return driver.RowsAffected(1), nil
+	}
+
+	tx, err := database.Begin(context.Background(), nil)
+	assert.NoError(t, err)
+
+	stmt, err := tx.Prepare("INSERT INTO users (name) VALUES (:name)")
+	assert.NoError(t, err)
+	_, err = stmt.Bind("name", "alice").Exec()
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Close())
+
+	stmt, err = tx.Prepare("INSERT INTO users (name) SELECT name FROM legacy_users")
+	assert.NoError(t, err)
+	_, err = stmt.Exec()
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Close())
+
+	// drivers that can't report rows affected are tolerated
+	_, err = tx.Exec("CREATE INDEX users_name ON users (name)")
+	assert.NoError(t, err)
+
+	assert.Equal(t, int64(4), tx.RowsAffected())
+	assert.NoError(t, tx.Commit())
+
+	assert.Equal(t, []string{
+		"begin",
+		"prepare: INSERT INTO users (name) VALUES (:name)",
+		"exec: INSERT INTO users (name) VALUES (:name)",
+		"prepare: INSERT INTO users (name) SELECT name FROM legacy_users",
+		"exec: INSERT INTO users (name) SELECT name FROM legacy_users",
+		"exec: CREATE INDEX users_name ON users (name)",
+		"commit",
+	}, stub.Log())
+}
+
+// TestTxPendingRows tests reporting the rows affected by a shared transaction
+// before it's committed.
+func TestTxPendingRows(t *testing.T) {
+	database, stub := newStubDB(t)
+	stub.Exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		return driver.RowsAffected(2), nil
+	}
+
+	tx, err := database.Begin(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), tx.PendingRows())
+
+	stmt, err := tx.Prepare("UPDATE users SET archived = 1 WHERE id = :id")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	for a := 1; a <= 3; a++ {
+		_, err = stmt.Bind("id", a).Exec()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2*a), tx.PendingRows())
+	}
+	_, err = tx.Exec("DELETE FROM sessions")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), tx.PendingRows())
+
+	// nothing is pending once committed
+	assert.NoError(t, tx.Commit())
+	assert.Equal(t, int64(0), tx.PendingRows())
+	assert.Equal(t, int64(8), tx.RowsAffected())
+}
+
+// TestCreateTempTable tests the temporary table DDL per driver.
+func TestCreateTempTable(t *testing.T) {
+	tests := []struct {
+		driverType string
+		query      string
+	}{
+		{"mysql", "CREATE TEMPORARY TABLE staged_users (id INT, name TEXT)"},
+		{"oracle", "CREATE PRIVATE TEMPORARY TABLE ORA$PTT_staged_users (id INT, name TEXT) ON COMMIT DROP DEFINITION"},
+		{"postgres", "CREATE TEMP TABLE staged_users (id INT, name TEXT) ON COMMIT DROP"},
+		{"sqlite", "CREATE TEMP TABLE staged_users (id INT, name TEXT)"},
+	}
+	for _, test := range tests {
+		database, stub := newStubDB(t, func(cfg *db.Config) {
+			cfg.DriverType = test.driverType
+		})
+		name := "staged_users"
+		if "oracle" == test.driverType {
+			name = "ORA$PTT_staged_users"
+		}
+		tx, err := database.Begin(context.Background(), nil)
+		assert.NoError(t, err)
+		assert.NoError(t, tx.CreateTempTable(context.Background(), name, "id INT, name TEXT"), test.driverType)
+		assert.NoError(t, tx.Commit())
+		assert.Equal(t, []string{"begin", "exec: " + test.query, "commit"}, stub.Log(), test.driverType)
+	}
+
+	// names are folded like other generated identifiers
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "oracle"
+		cfg.FoldIdentifiers = db.FoldUpper
+	})
+	tx, err := database.Begin(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.NoError(t, tx.CreateTempTable(context.Background(), "ORA$PTT_staged_users", "id INT"))
+	assert.NoError(t, tx.Commit())
+	assert.Equal(t, []string{"begin", "exec: CREATE PRIVATE TEMPORARY TABLE ORA$PTT_STAGED_USERS (id INT) ON COMMIT DROP DEFINITION", "commit"}, stub.Log())
+
+	// invalid names
+	database, stub = newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "postgres"
+	})
+	tx, err = database.Begin(context.Background(), nil)
+	assert.NoError(t, err)
+	defer tx.Rollback()
+	err = tx.CreateTempTable(context.Background(), "users; DROP TABLE users", "id INT")
+	assert.EqualError(t, err, "invalid temporary table name 'users; DROP TABLE users'")
+
+	// oracle names need the private temporary table prefix
+	database, stub = newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "oracle"
+	})
+	tx, err = database.Begin(context.Background(), nil)
+	assert.NoError(t, err)
+	defer tx.Rollback()
+	err = tx.CreateTempTable(context.Background(), "staged_users", "id INT")
+	assert.EqualError(t, err, "oracle temporary table name 'staged_users' must start with ORA$PTT_")
+	err = tx.CreateTempTable(context.Background(), "ORA$PTT_x; DROP TABLE users", "id INT")
+	assert.EqualError(t, err, "invalid temporary table name 'ORA$PTT_x; DROP TABLE users'")
+	assert.Equal(t, []string{"begin"}, stub.Log())
+
+	// unsupported drivers
+	database, stub = newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "snowflake"
+	})
+	tx, err = database.Begin(context.Background(), nil)
+	assert.NoError(t, err)
+	defer tx.Rollback()
+	err = tx.CreateTempTable(context.Background(), "staged_users", "id INT")
+	assert.EqualError(t, err, "temporary tables are not supported for driver type 'snowflake'")
+	assert.Equal(t, []string{"begin"}, stub.Log())
+}
+
+// TestSetConstraintsDeferred tests deferring constraint checks per driver.
+func TestSetConstraintsDeferred(t *testing.T) {
+	for _, driverType := range []string{"oracle", "postgres"} {
+		database, stub := newStubDB(t, func(cfg *db.Config) {
+			cfg.DriverType = driverType
+		})
+		tx, err := database.Begin(context.Background(), nil)
+		assert.NoError(t, err)
+		assert.NoError(t, tx.SetConstraintsDeferred(context.Background()), driverType)
+		assert.NoError(t, tx.Commit())
+		assert.Equal(t, []string{"begin", "exec: SET CONSTRAINTS ALL DEFERRED", "commit"}, stub.Log(), driverType)
+	}
+
+	// unsupported drivers
+	database, stub := newStubDB(t, func(cfg *db.Config) {
+		cfg.DriverType = "mysql"
+	})
+	tx, err := database.Begin(context.Background(), nil)
+	assert.NoError(t, err)
+	defer tx.Rollback()
+	err = tx.SetConstraintsDeferred(context.Background())
+	assert.EqualError(t, err, "deferred constraints are not supported for driver type 'mysql'")
+	assert.Equal(t, []string{"begin"}, stub.Log())
+}
+
+// TestOpenTxWarnAfter tests that transactions left open too long are reported
+// once with the stack that began them.
+func TestOpenTxWarnAfter(t *testing.T) {
+	logs := captureLogs(t)
+	database, _ := newStubDB(t, func(cfg *db.Config) {
+		cfg.OpenTxWarnAfter = 20 * time.Millisecond
+	})
+
+	// finished transactions aren't reported
+	tx, err := database.Begin(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+
+	// transactions finished by the caller aren't watched
+	txn, err := database.BeginTx(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.NoError(t, txn.Commit())
+	rows, err := database.QueryContext(context.Background(), "SELECT 1")
+	assert.NoError(t, err)
+	assert.NoError(t, rows.Close())
+
+	tx, err = database.Begin(context.Background(), nil)
+	assert.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, tx.Rollback())
+
+	entries := logs.Entries("transaction open longer than threshold")
+	if assert.Len(t, entries, 1) {
+		stack, _ := entries[0].Data["stack"].([]string)
+		assert.NotEmpty(t, stack)
+		assert.Contains(t, stack[0], "TestOpenTxWarnAfter")
+		assert.Equal(t, database.Config().DatabaseName, entries[0].Data["database"])
+	}
+}